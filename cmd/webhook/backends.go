@@ -0,0 +1,111 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+)
+
+// Lists the zones in Tidy
+type zoneLister interface {
+	ListZones(ctx context.Context) ([]tidydns.Zone, error)
+}
+
+// The Tidy servers the zones are spread across. Each zone is served by the
+// server configured for it by zone name, or else the default server. Zone IDs
+// are only unique within a server, so requests are routed by zone name.
+type tidyBackends struct {
+	fallback tidydns.TidyDNSClient
+	zones    map[string]tidydns.TidyDNSClient
+
+	// Time listing the zones of all servers may take, unless it's zero
+	listTimeout time.Duration
+}
+
+// The server serving a zone
+func (b tidyBackends) forZone(zoneName string) tidydns.TidyDNSClient {
+	if client, ok := b.zones[zoneKey(zoneName)]; ok {
+		return client
+	}
+
+	return b.fallback
+}
+
+// List the zones of all the servers. The zones of a server are only kept when
+// it serves them, so a zone found on several servers is taken from the one
+// configured for it.
+func (b tidyBackends) ListZones(ctx context.Context) ([]tidydns.Zone, error) {
+	if b.listTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.listTimeout)
+		defer cancel()
+	}
+
+	zoneNames := make([]string, 0, len(b.zones))
+	for zoneName := range b.zones {
+		zoneNames = append(zoneNames, zoneName)
+	}
+
+	// Servers are listed in a fixed order for the zones to be too
+	slices.Sort(zoneNames)
+	clients := []tidydns.TidyDNSClient{b.fallback}
+	for _, zoneName := range zoneNames {
+		if client := b.zones[zoneName]; !slices.Contains(clients, client) {
+			clients = append(clients, client)
+		}
+	}
+
+	zones := []tidydns.Zone{}
+	for _, client := range clients {
+		listed, err := client.ListZones(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, zone := range listed {
+			if b.forZone(zone.Name) == client {
+				zones = append(zones, zone)
+			}
+		}
+	}
+
+	return zones, nil
+}
+
+// Parse the Tidy servers of zones formatted as a comma separated list of
+// zone=endpoint pairs, e.g. example.org=https://tidy2.example.com
+func parseZoneEndpoints(value string) (map[string]string, error) {
+	zoneEndpoints, err := parseZoneValues(value, "zone endpoint")
+	if err != nil {
+		return nil, err
+	}
+
+	for zone, endpoint := range zoneEndpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid Tidy endpoint for zone %s: %q", zone, endpoint)
+		}
+	}
+
+	return zoneEndpoints, nil
+}
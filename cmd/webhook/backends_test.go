@@ -0,0 +1,181 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+type staticZoneProvider []tidydns.Zone
+
+func (zones staticZoneProvider) getZones() []tidydns.Zone {
+	return zones
+}
+
+func TestParseZoneEndpoints(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    map[string]string
+		expectError bool
+	}{
+		{"Empty", "", map[string]string{}, false},
+		{"Single", "example.org=https://tidy2.example.com", map[string]string{"example.org": "https://tidy2.example.com"}, false},
+		{"Multiple", "example.org=https://tidy2.example.com, Example.NET.=http://tidy3.example.com/tidy", map[string]string{"example.org": "https://tidy2.example.com", "example.net": "http://tidy3.example.com/tidy"}, false},
+		{"Missing endpoint", "example.org", nil, true},
+		{"Missing zone", "=https://tidy2.example.com", nil, true},
+		{"Not a URL", "example.org=tidy2.example.com", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			zoneEndpoints, err := parseZoneEndpoints(test.value)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got %v", zoneEndpoints)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if fmt.Sprint(zoneEndpoints) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, zoneEndpoints)
+			}
+		})
+	}
+}
+
+func TestTidyBackendsListZones(t *testing.T) {
+	// Both servers have a zone with ID 1, and example.net is on both
+	first := &mockTidyDNSClient{zones: []tidydns.Zone{{ID: "1", Name: "example.com"}, {ID: "2", Name: "example.net"}}}
+	second := &mockTidyDNSClient{zones: []tidydns.Zone{{ID: "1", Name: "example.org"}, {ID: "3", Name: "example.net"}}}
+
+	backends := tidyBackends{
+		fallback: first,
+		zones:    map[string]tidydns.TidyDNSClient{"example.org": second, "example.net": second},
+	}
+
+	zones, err := backends.ListZones(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := []tidydns.Zone{{ID: "1", Name: "example.com"}, {ID: "1", Name: "example.org"}, {ID: "3", Name: "example.net"}}
+	if !slices.Equal(zones, expected) {
+		t.Errorf("expected %v, got %v", expected, zones)
+	}
+
+	if backends.forZone("example.com") != first || backends.forZone("Example.ORG.") != second {
+		t.Errorf("expected the zones to be routed to their servers")
+	}
+}
+
+// Lists zones only when done before the context is
+type slowZoneClient struct {
+	mockTidyDNSClient
+	delay time.Duration
+}
+
+func (c *slowZoneClient) ListZones(ctx context.Context) ([]tidydns.Zone, error) {
+	select {
+	case <-time.After(c.delay):
+		return c.mockTidyDNSClient.ListZones(ctx)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestTidyBackendsListTimeout(t *testing.T) {
+	slow := &slowZoneClient{mockTidyDNSClient{zones: []tidydns.Zone{{ID: "1", Name: "example.com"}}}, time.Second}
+
+	backends := tidyBackends{fallback: slow, listTimeout: 10 * time.Millisecond}
+	if _, err := backends.ListZones(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the listing to time out, got %v", err)
+	}
+
+	slow.delay = 0
+	if zones, err := backends.ListZones(context.Background()); err != nil || len(zones) != 1 {
+		t.Errorf("expected the zone to be listed, got %v and %v", zones, err)
+	}
+}
+
+func TestProviderRoutesToZoneBackend(t *testing.T) {
+	// The zones of both servers have ID 1 and a record with ID 1
+	first := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{{ID: "1", Type: "A", Name: "www", Destination: "10.0.0.1", TTL: "300", ZoneName: "example.com", ZoneID: "1"}},
+	}
+	second := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{{ID: "1", Type: "A", Name: "www", Destination: "10.0.0.2", TTL: "300", ZoneName: "example.org", ZoneID: "1"}},
+	}
+
+	provider := &tidyProvider{
+		tidy:        first,
+		zoneClients: map[string]tidydns.TidyDNSClient{"example.org": second},
+		zoneProvider: staticZoneProvider{
+			{ID: "1", Name: "example.com"},
+			{ID: "1", Name: "example.org"},
+		},
+	}
+
+	endpoints, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(endpoints) != 2 {
+		t.Fatalf("expected an endpoint from each server, got %v", endpoints)
+	}
+
+	// The record IDs of the endpoint from the second server match a record on
+	// the first server too, which mustn't be deleted
+	var deleted *Endpoint
+	for _, ep := range endpoints {
+		if ep.DNSName == "www.example.org" {
+			deleted = ep
+		}
+	}
+
+	changes := &plan.Changes{
+		Create: []*Endpoint{endpoint.NewEndpoint("api.example.org", "A", "10.0.0.3")},
+		Delete: []*Endpoint{deleted},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(first.deletedRecordIds) != 0 || !slices.Equal(second.deletedRecordIds, []json.Number{"1"}) {
+		t.Errorf("expected the record to be deleted on the second server only, got %v and %v", first.deletedRecordIds, second.deletedRecordIds)
+	}
+
+	if len(first.createdRecords) != 1 || len(second.createdRecords) != 2 {
+		t.Errorf("expected the record to be created on the second server")
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// The credentials of Tidy, read from the files named by TIDYDNS_USER_FILE and
+// TIDYDNS_PASS_FILE when set, e.g. a mounted Kubernetes secret, and otherwise
+// taken from TIDYDNS_USER and TIDYDNS_PASS
+type tidyCredentials struct {
+	username     string
+	password     string
+	usernameFile string
+	passwordFile string
+}
+
+func tidyCredentialsFromEnv() tidyCredentials {
+	return tidyCredentials{
+		username:     os.Getenv("TIDYDNS_USER"),
+		password:     os.Getenv("TIDYDNS_PASS"),
+		usernameFile: os.Getenv("TIDYDNS_USER_FILE"),
+		passwordFile: os.Getenv("TIDYDNS_PASS_FILE"),
+	}
+}
+
+// Reports whether the credentials are read from files, which Kubernetes keeps
+// up to date when the secret is rotated
+func (c tidyCredentials) reloadable() bool {
+	return c.usernameFile != "" || c.passwordFile != ""
+}
+
+// Read the username and password, from their files when given
+func (c tidyCredentials) read() (string, string, error) {
+	username, err := readCredential(c.usernameFile, c.username)
+	if err != nil {
+		return "", "", err
+	}
+
+	password, err := readCredential(c.passwordFile, c.password)
+	if err != nil {
+		return "", "", err
+	}
+
+	return username, password, nil
+}
+
+// Read a credential from a file, ignoring a trailing newline, or use the given
+// value without a file
+func readCredential(file, value string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
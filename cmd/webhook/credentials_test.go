@@ -0,0 +1,78 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTidyCredentials(t *testing.T) {
+	dir := t.TempDir()
+	usernameFile := filepath.Join(dir, "username")
+	passwordFile := filepath.Join(dir, "password")
+	os.WriteFile(usernameFile, []byte("file-user\n"), 0o600)
+	os.WriteFile(passwordFile, []byte("file-pass"), 0o600)
+
+	tests := []struct {
+		name             string
+		credentials      tidyCredentials
+		reloadable       bool
+		expectedUsername string
+		expectedPassword string
+		expectError      bool
+	}{
+		{"Environment", tidyCredentials{username: "user", password: "pass"}, false, "user", "pass", false},
+		{"Files", tidyCredentials{username: "user", password: "pass", usernameFile: usernameFile, passwordFile: passwordFile}, true, "file-user", "file-pass", false},
+		{"Password file", tidyCredentials{username: "user", passwordFile: passwordFile}, true, "user", "file-pass", false},
+		{"Missing file", tidyCredentials{username: "user", passwordFile: filepath.Join(dir, "missing")}, true, "", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if reloadable := test.credentials.reloadable(); reloadable != test.reloadable {
+				t.Errorf("expected reloadable %v, got %v", test.reloadable, reloadable)
+			}
+
+			username, password, err := test.credentials.read()
+			if test.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", test.expectError, err)
+			}
+
+			if username != test.expectedUsername || password != test.expectedPassword {
+				t.Errorf("expected %s/%s, got %s/%s", test.expectedUsername, test.expectedPassword, username, password)
+			}
+		})
+	}
+}
+
+// Rotating the secret updates the files, which are read again
+func TestTidyCredentialsRotated(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	os.WriteFile(passwordFile, []byte("old"), 0o600)
+
+	credentials := tidyCredentials{username: "user", passwordFile: passwordFile}
+	if _, password, _ := credentials.read(); password != "old" {
+		t.Fatalf("expected the old password, got %s", password)
+	}
+
+	os.WriteFile(passwordFile, []byte("new"), 0o600)
+	if _, password, _ := credentials.read(); password != "new" {
+		t.Errorf("expected the rotated password, got %s", password)
+	}
+}
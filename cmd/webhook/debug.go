@@ -0,0 +1,160 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// Debug endpoints exposing the internal state of the provider. They are meant
+// for triage and only served when enabled.
+type debugHandler struct {
+	provider  *tidyProvider
+	effective map[string]string
+}
+
+func newDebugHandler(provider *tidyProvider, effective map[string]string) http.Handler {
+	d := &debugHandler{
+		provider:  provider,
+		effective: effective,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/domainfilter", d.domainFilter)
+	mux.HandleFunc("GET /debug/config", d.config)
+	mux.HandleFunc("GET /debug/records", d.records)
+	mux.HandleFunc("POST /debug/refresh-zones", d.refreshZones)
+
+	return mux
+}
+
+type domainFilterResponse struct {
+	DomainFilter endpoint.DomainFilterInterface `json:"domainFilter"`
+	Zones        []tidydns.Zone                 `json:"zones"`
+}
+
+// Respond with the domain filter negotiated with External-DNS along with the
+// cached zones it's made from.
+func (d *debugHandler) domainFilter(w http.ResponseWriter, req *http.Request) {
+	resp := domainFilterResponse{
+		DomainFilter: d.provider.GetDomainFilter(),
+		Zones:        d.provider.zoneProvider.getZones(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// Respond with the effective configuration, with secrets redacted
+func (d *debugHandler) config(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.effective); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// A record as listed by Tidy next to the endpoint it's parsed into, which is
+// null when the record can't be parsed and the error tells why
+type debugRecord struct {
+	Record   tidydns.Record `json:"record"`
+	Endpoint *Endpoint      `json:"endpoint"`
+	Error    string         `json:"error,omitempty"`
+}
+
+type recordsResponse struct {
+	Zone    tidydns.Zone  `json:"zone"`
+	Records []debugRecord `json:"records"`
+}
+
+// Respond with the records of the zone given by the zone parameter, listed
+// from Tidy on demand, and the endpoints they're parsed into. The zone is
+// looked up in the cached zones, and names below a zone resolve to it. Records
+// are neither merged nor filtered, so discrepancies with /records can be told
+// apart from parsing.
+func (d *debugHandler) records(w http.ResponseWriter, req *http.Request) {
+	name := zoneKey(req.URL.Query().Get("zone"))
+	if name == "" {
+		http.Error(w, "missing zone parameter", http.StatusBadRequest)
+		return
+	}
+
+	zone, ok := newZoneIndex(d.provider.zoneProvider.getZones()).find(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no zone %s in Tidy", name), http.StatusNotFound)
+		return
+	}
+
+	records, err := d.provider.backends().forZone(zone.Name).ListRecords(req.Context(), zone.ID)
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, fmt.Sprintf("cannot list records of zone %s: %s", zone.Name, err), http.StatusBadGateway)
+		return
+	}
+
+	resp := recordsResponse{Zone: zone, Records: []debugRecord{}}
+	for _, record := range records {
+		endpoint, err := parseTidyRecord(&record)
+
+		debug := debugRecord{Record: record, Endpoint: endpoint}
+		if err != nil {
+			debug.Error = err.Error()
+		}
+
+		resp.Records = append(resp.Records, debug)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+type refreshZonesResponse struct {
+	Zones []tidydns.Zone `json:"zones"`
+}
+
+// List the zones from Tidy right away and respond with them, so a zone added
+// in Tidy is managed without waiting for the next zone update
+func (d *debugHandler) refreshZones(w http.ResponseWriter, req *http.Request) {
+	refresher, ok := d.provider.zoneProvider.(zoneRefresher)
+	if !ok {
+		http.Error(w, "zones can't be refreshed", http.StatusNotImplemented)
+		return
+	}
+
+	zones, err := refresher.refreshZones(req.Context())
+	if err != nil {
+		slog.Error(err.Error())
+		http.Error(w, fmt.Sprintf("cannot refresh zones: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	slog.Info("zones refreshed on request", "zones", len(zones))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(refreshZonesResponse{Zones: zones}); err != nil {
+		slog.Error(err.Error())
+	}
+}
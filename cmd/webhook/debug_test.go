@@ -0,0 +1,202 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+)
+
+func TestDebugDomainFilter(t *testing.T) {
+	handler := newDebugHandler(&tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+	}, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/domainfilter", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status OK, got %d", rec.Code)
+	}
+
+	resp := struct {
+		DomainFilter struct {
+			Include []string `json:"include"`
+		} `json:"domainFilter"`
+		Zones []struct {
+			Name string `json:"name"`
+		} `json:"zones"`
+	}{}
+
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(resp.DomainFilter.Include) != 1 || resp.DomainFilter.Include[0] != "example.com" {
+		t.Errorf("expected domain filter to include example.com, got %v", resp.DomainFilter.Include)
+	}
+
+	if len(resp.Zones) != 1 || resp.Zones[0].Name != "example.com" {
+		t.Errorf("expected zone example.com, got %v", resp.Zones)
+	}
+}
+
+func TestDebugConfig(t *testing.T) {
+	effective := map[string]string{"zone-update-interval": "15m", "tidydns-pass": redacted}
+	handler := newDebugHandler(&tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+	}, effective)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/config", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status OK, got %d", rec.Code)
+	}
+
+	resp := map[string]string{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(resp) != 2 || resp["zone-update-interval"] != "15m" || resp["tidydns-pass"] != redacted {
+		t.Errorf("expected %v, got %v", effective, resp)
+	}
+}
+
+func TestDebugRecords(t *testing.T) {
+	records := []tidydns.Record{
+		{ID: "1", Type: "A", Name: "www", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+		{ID: "2", Type: "A", Name: "bad", Destination: "1.2.3.4", TTL: "1e999", ZoneName: "example.com", ZoneID: "1"},
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		err       error
+		status    int
+		endpoints []string
+	}{
+		{"Zone", "?zone=example.com", nil, http.StatusOK, []string{"www.example.com", ""}},
+		{"Zone with trailing dot", "?zone=Example.com.", nil, http.StatusOK, []string{"www.example.com", ""}},
+		{"Name below zone", "?zone=www.example.com", nil, http.StatusOK, []string{"www.example.com", ""}},
+		{"Missing zone", "", nil, http.StatusBadRequest, nil},
+		{"Unknown zone", "?zone=example.org", nil, http.StatusNotFound, nil},
+		{"Tidy failing", "?zone=example.com", errors.New("unavailable"), http.StatusBadGateway, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := newDebugHandler(&tidyProvider{
+				tidy:         &mockTidyDNSClient{createdRecords: records, err: test.err},
+				zoneProvider: &mockZoneProvider{},
+			}, nil)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/records"+test.query, nil))
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, rec.Code)
+			}
+
+			if test.status != http.StatusOK {
+				return
+			}
+
+			resp := struct {
+				Zone    tidydns.Zone `json:"zone"`
+				Records []struct {
+					Record   tidydns.Record `json:"record"`
+					Endpoint *struct {
+						DNSName string `json:"dnsName"`
+					} `json:"endpoint"`
+				} `json:"records"`
+			}{}
+
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if resp.Zone.Name != "example.com" || len(resp.Records) != len(test.endpoints) {
+				t.Fatalf("expected %d records of example.com, got %+v", len(test.endpoints), resp)
+			}
+
+			for i, expected := range test.endpoints {
+				dnsName := ""
+				if resp.Records[i].Endpoint != nil {
+					dnsName = resp.Records[i].Endpoint.DNSName
+				}
+
+				if resp.Records[i].Record.ID != records[i].ID || dnsName != expected {
+					t.Errorf("expected record %s parsed as %q, got %+v", records[i].ID, expected, resp.Records[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDebugRefreshZones(t *testing.T) {
+	tidy := &mockTidyDNSClient{zones: []tidydns.Zone{{ID: "1", Name: "example.com"}}}
+
+	tests := []struct {
+		name         string
+		zoneProvider ZoneProvider
+		status       int
+	}{
+		{"Refreshed", newZoneProviderWithClock(tidy, time.Hour, 0, "", nil, newManualClock().after), http.StatusOK},
+		{"Not refreshable", &mockZoneProvider{}, http.StatusNotImplemented},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := newDebugHandler(&tidyProvider{tidy: tidy, zoneProvider: test.zoneProvider}, nil)
+
+			tidy.zones = append(tidy.zones, tidydns.Zone{ID: "2", Name: "example.org"})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/refresh-zones", nil))
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, rec.Code)
+			}
+
+			if test.status != http.StatusOK {
+				return
+			}
+
+			resp := refreshZonesResponse{}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(resp.Zones) != 2 || resp.Zones[1].Name != "example.org" {
+				t.Errorf("expected the new zone example.org, got %v", resp.Zones)
+			}
+
+			if zones := test.zoneProvider.getZones(); len(zones) != 2 {
+				t.Errorf("expected the zone provider to have the new zones, got %v", zones)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// Returned when a change would delete more records than allowed
+var errTooManyDeletes = errors.New("too many deletes")
+
+// Safety valve refusing changes deleting more records than expected, as when a
+// misconfigured source makes External-DNS propose to delete everything. A zero
+// limit is off, so the zero value allows any number of deletes.
+type deleteLimit struct {
+	maxDeletes int
+	maxPercent float64
+}
+
+// Check the number of records deleted by a change against the number of
+// records in Tidy
+func (l deleteLimit) check(deletes, records int) error {
+	if l.maxDeletes > 0 && deletes > l.maxDeletes {
+		return fmt.Errorf("%w: %d records would be deleted, at most %d are allowed", errTooManyDeletes, deletes, l.maxDeletes)
+	}
+
+	if l.maxPercent > 0 && records > 0 {
+		percent := 100 * float64(deletes) / float64(records)
+		if percent > l.maxPercent {
+			return fmt.Errorf("%w: %d of %d records (%.1f%%) would be deleted, at most %.1f%% are allowed", errTooManyDeletes, deletes, records, percent, l.maxPercent)
+		}
+	}
+
+	return nil
+}
+
+// Count the records deleted by the deletes and updates of a change, with the
+// records of each endpoint given by records. Records matched by more than one
+// endpoint are counted once.
+func countDeletes(changes *plan.Changes, records func(*Endpoint) []tidyRecord) int {
+	deleted := map[string]bool{}
+	for _, endpoints := range [][]*Endpoint{changes.Delete, changes.UpdateOld} {
+		for _, endpoint := range endpoints {
+			for _, record := range records(endpoint) {
+				deleted[record.ZoneID.String()+"/"+record.ID.String()] = true
+			}
+		}
+	}
+
+	return len(deleted)
+}
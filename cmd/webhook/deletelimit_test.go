@@ -0,0 +1,101 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestDeleteLimitCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       deleteLimit
+		deletes     int
+		records     int
+		expectError bool
+	}{
+		{"No limit", deleteLimit{}, 100, 100, false},
+		{"Below max deletes", deleteLimit{maxDeletes: 5}, 5, 100, false},
+		{"Above max deletes", deleteLimit{maxDeletes: 5}, 6, 100, true},
+		{"Below max percent", deleteLimit{maxPercent: 10}, 10, 100, false},
+		{"Above max percent", deleteLimit{maxPercent: 10}, 11, 100, true},
+		{"No records", deleteLimit{maxPercent: 10}, 0, 0, false},
+		{"Both limits", deleteLimit{maxDeletes: 50, maxPercent: 10}, 20, 100, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.limit.check(test.deletes, test.records)
+			if test.expectError && !errors.Is(err, errTooManyDeletes) {
+				t.Errorf("expected %v, got %v", errTooManyDeletes, err)
+			} else if !test.expectError && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyChangesDeleteLimit(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{ID: "1", ZoneID: "1", Name: "www", ZoneName: "example.com", Type: "A", Destination: "10.0.0.1"},
+			{ID: "2", ZoneID: "1", Name: "api", ZoneName: "example.com", Type: "A", Destination: "10.0.0.2"},
+			{ID: "3", ZoneID: "1", Name: "app", ZoneName: "example.com", Type: "A", Destination: "10.0.0.3"},
+		},
+	}
+
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+		deleteLimit:  deleteLimit{maxDeletes: 1},
+	}
+
+	changes := &plan.Changes{
+		Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.example.com", "A", "10.0.0.1"),
+		},
+		UpdateOld: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("api.example.com", "A", "10.0.0.2"),
+		},
+		UpdateNew: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("api.example.com", "A", "10.0.0.4"),
+		},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); !errors.Is(err, errTooManyDeletes) {
+		t.Fatalf("expected %v, got %v", errTooManyDeletes, err)
+	}
+
+	if len(tidy.deletedRecordIds) != 0 {
+		t.Errorf("expected no records to be deleted, got %v", tidy.deletedRecordIds)
+	}
+
+	provider.deleteLimit.maxDeletes = 2
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(tidy.deletedRecordIds) != 2 {
+		t.Errorf("expected 2 records to be deleted, got %v", tidy.deletedRecordIds)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// Labels of a domain once punycode encoded. Underscores are allowed as they're
+// used in names like _dmarc.example.com.
+var domainLabelPattern = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9_])?$`)
+
+// List of domains given by a flag. Like the domain filter flags of External-DNS
+// the flag can be repeated, and each value can hold several domains separated
+// by commas, spaces or newlines, so lists can be pasted as they are.
+type domainList []string
+
+func (l *domainList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *domainList) Set(value string) error {
+	separator := func(r rune) bool { return r == ',' || unicode.IsSpace(r) }
+	*l = append(*l, strings.FieldsFunc(value, separator)...)
+	return nil
+}
+
+// Normalize the domains of the list, leaving out duplicates. The domains are
+// validated here rather than when the flag is set, so an invalid domain is
+// reported like other invalid configuration.
+func (l domainList) normalize() ([]string, error) {
+	domains := []string{}
+	for _, domain := range l {
+		domain, err := normalizeDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.Contains(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+
+	return domains, nil
+}
+
+// Lower case, punycode encode and validate a domain, so it compares equal to
+// the punycode encoded zone names. A trailing dot is removed.
+func normalizeDomain(domain string) (string, error) {
+	name, err := idna.ToASCII(strings.ToLower(strings.TrimSuffix(domain, ".")))
+	if err != nil {
+		return "", fmt.Errorf("invalid domain %s: %w", domain, err)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if !domainLabelPattern.MatchString(label) {
+			return "", fmt.Errorf("invalid domain %s", domain)
+		}
+	}
+
+	return name, nil
+}
+
+// The domains managed by the webhook within the zones in Tidy. Without any
+// included domains all zones are managed.
+type domainScope struct {
+	include []string
+	exclude []string
+}
+
+// Make the domain filter negotiated with External-DNS from the names of the
+// zones in Tidy. Included domains narrow the filter to the zones below them,
+// or to the included domain itself when it's below a zone.
+func (s domainScope) filter(zoneNames []string) endpoint.DomainFilter {
+	if len(s.include) == 0 {
+		return endpoint.NewDomainFilterWithExclusions(zoneNames, s.exclude)
+	}
+
+	filters := []string{}
+	for _, zoneName := range zoneNames {
+		for _, domain := range s.include {
+			var filter string
+			if isSubdomain(zoneName, domain) {
+				filter = zoneName
+			} else if isSubdomain(domain, zoneName) {
+				filter = domain
+			} else {
+				continue
+			}
+
+			if !slices.Contains(filters, filter) {
+				filters = append(filters, filter)
+			}
+		}
+	}
+
+	// An empty domain filter matches everything, so rather fall back to the
+	// included domains when none of them are in a zone
+	if len(filters) == 0 {
+		filters = s.include
+	}
+
+	return endpoint.NewDomainFilterWithExclusions(filters, s.exclude)
+}
+
+// Reports whether name is the domain itself or a name below it
+func isSubdomain(name, domain string) bool {
+	name = strings.ToLower(name)
+	domain = strings.ToLower(domain)
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
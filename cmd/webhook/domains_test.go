@@ -0,0 +1,145 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDomainListNormalize(t *testing.T) {
+	tests := []struct {
+		name        string
+		values      []string
+		expected    []string
+		expectError bool
+	}{
+		{"Single", []string{"example.com"}, []string{"example.com"}, false},
+		{"Commas", []string{"example.com,example.org"}, []string{"example.com", "example.org"}, false},
+		{"Newlines", []string{"example.com\nexample.org\n"}, []string{"example.com", "example.org"}, false},
+		{"Mixed separators", []string{" example.com,\n  example.org ,,example.net\t"}, []string{"example.com", "example.org", "example.net"}, false},
+		{"Repeated flag", []string{"example.com", "example.org"}, []string{"example.com", "example.org"}, false},
+		{"Duplicates", []string{"example.com,EXAMPLE.com."}, []string{"example.com"}, false},
+		{"Unicode", []string{"bølle.dk"}, []string{"xn--blle-gra.dk"}, false},
+		{"Underscore", []string{"_dmarc.example.com"}, []string{"_dmarc.example.com"}, false},
+		{"Empty", []string{""}, nil, false},
+		{"Empty label", []string{"example..com"}, nil, true},
+		{"URL", []string{"https://example.com"}, nil, true},
+		{"Wildcard", []string{"*.example.com"}, nil, true},
+		{"Leading hyphen", []string{"-example.com"}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			domains := domainList{}
+
+			for _, value := range test.values {
+				if err := domains.Set(value); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			normalized, err := domains.normalize()
+
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got %v", normalized)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !slices.Equal(normalized, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, normalized)
+			}
+		})
+	}
+}
+
+func TestDomainScopeFilter(t *testing.T) {
+	zoneNames := []string{"example.com", "example.org", "sub.example.net"}
+
+	tests := []struct {
+		name     string
+		scope    domainScope
+		matches  []string
+		excluded []string
+	}{
+		{
+			name:    "All zones",
+			scope:   domainScope{},
+			matches: []string{"www.example.com", "www.example.org", "www.sub.example.net"},
+		},
+		{
+			name:     "Included zone",
+			scope:    domainScope{include: []string{"example.com"}},
+			matches:  []string{"www.example.com"},
+			excluded: []string{"www.example.org"},
+		},
+		{
+			name:     "Included domain below a zone",
+			scope:    domainScope{include: []string{"k8s.example.com"}},
+			matches:  []string{"www.k8s.example.com"},
+			excluded: []string{"www.example.com", "www.example.org"},
+		},
+		{
+			name:     "Included domain above a zone",
+			scope:    domainScope{include: []string{"example.net"}},
+			matches:  []string{"www.sub.example.net"},
+			excluded: []string{"www.example.net", "www.example.com"},
+		},
+		{
+			name:     "Excluded domain",
+			scope:    domainScope{exclude: []string{"legacy.example.com"}},
+			matches:  []string{"www.example.com"},
+			excluded: []string{"www.legacy.example.com"},
+		},
+		{
+			name:     "Included parent with an excluded child",
+			scope:    domainScope{include: []string{"example.com"}, exclude: []string{"k8s.example.com"}},
+			matches:  []string{"example.com", "www.example.com"},
+			excluded: []string{"k8s.example.com", "www.k8s.example.com", "www.example.org"},
+		},
+		{
+			name:     "Included domain outside the zones",
+			scope:    domainScope{include: []string{"example.io"}},
+			matches:  []string{"www.example.io"},
+			excluded: []string{"www.example.com"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := test.scope.filter(zoneNames)
+
+			for _, name := range test.matches {
+				if !filter.Match(name) {
+					t.Errorf("expected %s to match", name)
+				}
+			}
+
+			for _, name := range test.excluded {
+				if filter.Match(name) {
+					t.Errorf("expected %s not to match", name)
+				}
+			}
+		})
+	}
+}
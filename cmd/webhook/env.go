@@ -0,0 +1,62 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const envPrefix = "TIDYDNS_"
+
+// Flags which can't be set from the environment. A variable like
+// TIDYDNS_VERSION is likely set for other reasons and would stop the webhook.
+var envExcludedFlags = []string{"version", "once"}
+
+// Name of the environment variable setting a flag, e.g. TIDYDNS_ENDPOINT for
+// tidydns-endpoint and TIDYDNS_ZONE_UPDATE_INTERVAL for zone-update-interval
+func envName(flagName string) string {
+	name := strings.TrimPrefix(flagName, "tidydns-")
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Set the flags which weren't given on the command line from the environment,
+// so flags take precedence over environment variables
+func applyEnv(flags *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || slices.Contains(envExcludedFlags, f.Name) {
+			return
+		}
+
+		value, ok := lookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q of %s: %w", value, envName(f.Name), setErr)
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		flagName string
+		expected string
+	}{
+		{"tidydns-endpoint", "TIDYDNS_ENDPOINT"},
+		{"tidydns-zone-group", "TIDYDNS_ZONE_GROUP"},
+		{"zone-update-interval", "TIDYDNS_ZONE_UPDATE_INTERVAL"},
+		{"log-level", "TIDYDNS_LOG_LEVEL"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.flagName, func(t *testing.T) {
+			if actual := envName(test.flagName); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+	"go.opentelemetry.io/otel/metric/noop"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// Names of the Tidy type-numbers. Type 0 is both A and AAAA records, told apart
+// by the destination.
+var fakeTidyTypes = map[string]string{"0": "A", "2": "CNAME", "3": "MX", "5": "TXT", "6": "SRV", "7": "DS", "8": "SSHFP"}
+
+// An in-memory Tidy serving the parts of its API the client uses, for testing
+// the provider against Tidy over HTTP
+type fakeTidy struct {
+	lock    sync.Mutex
+	zones   []tidydns.Zone
+	records []tidydns.Record
+	lastID  int
+}
+
+// Start a fake Tidy with the given zones, stopped when the test ends, and a
+// client talking to it
+func newFakeTidy(t *testing.T, zones ...tidydns.Zone) (*fakeTidy, tidydns.TidyDNSClient) {
+	t.Helper()
+
+	fake := &fakeTidy{zones: zones}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /=/zone", fake.listZones)
+	mux.HandleFunc("GET /=/record_merged", fake.listRecords)
+	mux.HandleFunc("POST /=/record/new/{zone}", fake.createRecord)
+	mux.HandleFunc("DELETE /=/record/{id}/{zone}", fake.deleteRecord)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client, err := tidydns.NewTidyDnsClient(server.URL, "user", "pass", time.Second, noop.NewMeterProvider().Meter("test"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	return fake, client
+}
+
+// Add a record to a zone, as if made in Tidy by hand
+func (f *fakeTidy) add(zoneID json.Number, record tidydns.Record) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.insert(zoneID, record)
+}
+
+// The records in Tidy, ordered by ID
+func (f *fakeTidy) list() []tidydns.Record {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return slices.Clone(f.records)
+}
+
+func (f *fakeTidy) insert(zoneID json.Number, record tidydns.Record) bool {
+	index := slices.IndexFunc(f.zones, func(zone tidydns.Zone) bool { return zone.ID == zoneID })
+	if index == -1 {
+		return false
+	}
+
+	f.lastID++
+	record.ID = json.Number(strconv.Itoa(f.lastID))
+	record.ZoneID = zoneID
+	record.ZoneName = f.zones[index].Name
+	if record.Status == "" {
+		record.Status = tidydns.RecordStatusActive
+	}
+
+	f.records = append(f.records, record)
+	return true
+}
+
+func (f *fakeTidy) listZones(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	writeJSON(w, f.zones)
+}
+
+func (f *fakeTidy) listRecords(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	zoneID := json.Number(r.URL.Query().Get("zone_id"))
+	records := []tidydns.Record{}
+	for _, record := range f.records {
+		if record.ZoneID == zoneID {
+			records = append(records, record)
+		}
+	}
+
+	writeJSON(w, records)
+}
+
+func (f *fakeTidy) createRecord(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	form := func(key string) json.Number { return json.Number(r.PostForm.Get(key)) }
+
+	recordType, ok := fakeTidyTypes[r.PostForm.Get("type")]
+	if !ok {
+		http.Error(w, "unknown record type", http.StatusBadRequest)
+		return
+	}
+
+	if recordType == "A" && strings.Contains(r.PostForm.Get("destination"), ":") {
+		recordType = "AAAA"
+	}
+
+	record := tidydns.Record{
+		Type:        recordType,
+		Name:        r.PostForm.Get("name"),
+		Description: r.PostForm.Get("description"),
+		Destination: r.PostForm.Get("destination"),
+		TTL:         form("ttl"),
+		Status:      form("status"),
+		LocationID:  form("location_id"),
+
+		Priority: form("priority"),
+		Weight:   form("weight"),
+		Port:     form("port"),
+
+		SSHFPAlgorithm: form("sshfp_algorithm"),
+		SSHFPType:      form("sshfp_type"),
+
+		DSKeyTag:     form("ds_keytag"),
+		DSAlgorithm:  form("ds_algorithm"),
+		DSDigestType: form("ds_digest_type"),
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.insert(json.Number(r.PathValue("zone")), record) {
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeTidy) deleteRecord(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	index := slices.IndexFunc(f.records, func(record tidydns.Record) bool {
+		return record.ID.String() == r.PathValue("id") && record.ZoneID.String() == r.PathValue("zone")
+	})
+
+	if index == -1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	f.records = slices.Delete(f.records, index, index+1)
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func TestFakeTidyCycle(t *testing.T) {
+	fake, client := newFakeTidy(t, tidydns.Zone{ID: "1", Name: "example.com"}, tidydns.Zone{ID: "2", Name: "example.org"})
+	fake.add("1", tidydns.Record{Type: "A", Name: "www", Destination: "1.2.3.4", TTL: "300"})
+	fake.add("2", tidydns.Record{Type: "TXT", Name: "old", Destination: "heritage=external-dns", TTL: "300"})
+
+	provider, err := newProvider(client, nil, providerConfig{zoneUpdateInterval: time.Hour, apexToken: apexTokenAuto, rollbackUpdates: true, metricsPrefix: "tidy_"}, nil, noop.NewMeterProvider().Meter("test"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Records are in sync when planning the desired endpoints against them
+	// changes nothing
+	changesFor := func(desired []*Endpoint) *plan.Changes {
+		t.Helper()
+
+		current, err := provider.Records(context.Background())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		adjusted, err := provider.AdjustEndpoints(desired)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		return (&plan.Plan{
+			Current:        current,
+			Desired:        adjusted,
+			Policies:       []plan.Policy{&plan.SyncPolicy{}},
+			ManagedRecords: []string{"A", "AAAA", "MX", "TXT"},
+		}).Calculate().Changes
+	}
+
+	desired := []*Endpoint{
+		endpoint.NewEndpointWithTTL("www.example.com", "A", 600, "5.6.7.8"),
+		endpoint.NewEndpointWithTTL("multi.example.com", "AAAA", 300, "2001:db8::1", "2001:db8::2"),
+		endpoint.NewEndpointWithTTL("example.org", "MX", 300, "10 mail.example.org"),
+	}
+
+	changes := changesFor(desired)
+	if len(changes.Create) != 2 || len(changes.UpdateNew) != 1 || len(changes.Delete) != 1 {
+		t.Fatalf("expected 2 creates, 1 update and 1 delete, got %+v", changes)
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if changes := changesFor(desired); changes.HasChanges() {
+		t.Errorf("expected the records to be in sync, got %+v", changes)
+	}
+
+	expected := []string{"example.com AAAA multi 2001:db8::1", "example.com AAAA multi 2001:db8::2", "example.com A www 5.6.7.8", "example.org MX . mail.example.org."}
+	records := []string{}
+	for _, record := range fake.list() {
+		records = append(records, strings.Join([]string{record.ZoneName, record.Type, record.Name, record.Destination}, " "))
+	}
+
+	slices.Sort(expected)
+	slices.Sort(records)
+	if !slices.Equal(records, expected) {
+		t.Errorf("expected records %v, got %v", expected, records)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errHealthCheckTimeout = errors.New("health check of Tidy timed out")
+
+// Health check listing the zones in Tidy, so an unreachable Tidy or failing
+// authentication shows up as unhealthy. The result is cached for cacheFor to
+// not load Tidy with requests from probes.
+type tidyHealthCheck struct {
+	check    func() error
+	timeout  time.Duration
+	cacheFor time.Duration
+	now      func() time.Time
+
+	lock      sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+func newTidyHealthCheck(check func() error, timeout, cacheFor time.Duration) *tidyHealthCheck {
+	return &tidyHealthCheck{
+		check:    check,
+		timeout:  timeout,
+		cacheFor: cacheFor,
+		now:      time.Now,
+	}
+}
+
+// Check Tidy unless the last result is recent enough. Concurrent probes wait
+// for the same check rather than each asking Tidy.
+func (h *tidyHealthCheck) result() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if !h.checkedAt.IsZero() && h.now().Sub(h.checkedAt) < h.cacheFor {
+		return h.err
+	}
+
+	// The Tidy client has its own timeout, which can be longer than a probe
+	// is willing to wait
+	done := make(chan error, 1)
+	go func() { done <- h.check() }()
+
+	select {
+	case h.err = <-done:
+	case <-time.After(h.timeout):
+		h.err = errHealthCheckTimeout
+	}
+
+	h.checkedAt = h.now()
+	return h.err
+}
+
+func (h *tidyHealthCheck) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if err := h.result(); err != nil {
+		slog.Warn("Tidy is unhealthy: " + err.Error())
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	resp.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTidyHealthCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"Healthy", nil, http.StatusOK},
+		{"Unauthorized", errors.New("401 Unauthorized"), http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			check := newTidyHealthCheck(func() error { return test.err }, time.Second, time.Minute)
+
+			rec := httptest.NewRecorder()
+			check.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+			if rec.Code != test.expected {
+				t.Errorf("expected status %d, got %d", test.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestTidyHealthCheckCache(t *testing.T) {
+	checks := 0
+	check := newTidyHealthCheck(func() error { checks++; return nil }, time.Second, time.Minute)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	check.now = func() time.Time { return now }
+
+	check.result()
+	now = now.Add(30 * time.Second)
+	check.result()
+	if checks != 1 {
+		t.Errorf("expected the cached result to be used, got %d checks", checks)
+	}
+
+	now = now.Add(time.Minute)
+	check.result()
+	if checks != 2 {
+		t.Errorf("expected Tidy to be checked again, got %d checks", checks)
+	}
+}
+
+func TestTidyHealthCheckTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	check := newTidyHealthCheck(func() error { <-release; return nil }, time.Millisecond, time.Minute)
+	if err := check.result(); !errors.Is(err, errHealthCheckTimeout) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
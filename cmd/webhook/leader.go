@@ -0,0 +1,358 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// Returned by ApplyChanges on replicas which aren't the leader
+var errNotLeader = errors.New("not the leader, changes are only applied by the leader")
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// The format of the times in a lease
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// The parts of a coordination.k8s.io/v1 Lease used for leader election
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// Elects a leader among the webhook replicas using a Kubernetes lease. The
+// lease is held by renewing it before it expires, and taken over by another
+// replica when it isn't renewed in time. The leader steps down when it can't
+// renew the lease within the renew deadline, which is shorter than the lease
+// duration, so it has stopped leading before another replica takes over.
+type leaderElector struct {
+	client        *http.Client
+	apiURL        string
+	token         func() (string, error)
+	namespace     string
+	name          string
+	identity      string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+
+	leading atomic.Bool
+
+	// The time the lease was last renewed, in Unix nanoseconds
+	lastRenew atomic.Int64
+
+	// The lease held by another replica as last seen, and the local time it
+	// was first seen like that. Only used by the election loop.
+	observed     observedLease
+	observedTime time.Time
+}
+
+// The fields of a lease which change whenever its holder renews it
+type observedLease struct {
+	holderIdentity  string
+	renewTime       string
+	resourceVersion string
+}
+
+// Make a leader elector talking to the Kubernetes API the pod is running in
+func newInClusterLeaderElector(namespace, name, identity string, leaseDuration, renewDeadline time.Duration) (*leaderElector, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("leader election requires running in Kubernetes")
+	}
+
+	caCert, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("invalid Kubernetes CA certificate")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: certPool}
+
+	// The token is read for every request as Kubernetes rotates it
+	token := func() (string, error) {
+		token, err := os.ReadFile(serviceAccountDir + "/token")
+		return strings.TrimSpace(string(token)), err
+	}
+
+	return &leaderElector{
+		client:        &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		apiURL:        "https://" + net.JoinHostPort(host, port),
+		token:         token,
+		namespace:     namespace,
+		name:          name,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+	}, nil
+}
+
+// The namespace the pod is running in, according to its service account
+func inClusterNamespace() string {
+	namespace, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(namespace))
+}
+
+// Reports whether this replica leads, which it stops doing once the renew
+// deadline has passed since the lease was last renewed
+func (l *leaderElector) isLeader() bool {
+	return l.leading.Load() && l.withinRenewDeadline(time.Now())
+}
+
+func (l *leaderElector) withinRenewDeadline(now time.Time) bool {
+	return now.Sub(time.Unix(0, l.lastRenew.Load())) < l.renewDeadline
+}
+
+// Try to acquire or renew the lease several times per lease duration until the
+// context is cancelled
+func (l *leaderElector) run(ctx context.Context) {
+	ticker := time.NewTicker(l.leaseDuration / 5)
+	defer ticker.Stop()
+
+	for {
+		l.tryAcquireOrRenew(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			l.leading.Store(false)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Try to acquire or renew the lease once, updating whether this replica is the
+// leader. Leadership is kept through errors until the renew deadline.
+func (l *leaderElector) tryAcquireOrRenew(ctx context.Context, now time.Time) {
+	leading, err := l.acquireOrRenew(ctx, now)
+	if err != nil {
+		slog.Error("leader election failed", "error", err)
+		leading = l.leading.Load() && l.withinRenewDeadline(now)
+	} else if leading {
+		l.lastRenew.Store(now.UnixNano())
+	}
+
+	if l.leading.Swap(leading) != leading {
+		slog.Info("leadership changed", "leader", leading, "identity", l.identity)
+	}
+}
+
+func (l *leaderElector) acquireOrRenew(ctx context.Context, now time.Time) (bool, error) {
+	current := &lease{}
+	status, err := l.request(ctx, "GET", l.leasePath(), nil, current)
+	if err != nil {
+		return false, err
+	}
+
+	// Nobody has held the lease yet
+	if status == http.StatusNotFound {
+		created := &lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: l.name, Namespace: l.namespace},
+		}
+		l.hold(created, now)
+
+		path := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", l.namespace)
+		status, err := l.request(ctx, "POST", path, created, nil)
+		if err != nil {
+			return false, err
+		}
+
+		// Another replica created it first
+		return status == http.StatusCreated, l.unexpected(status, http.StatusCreated, http.StatusConflict)
+	}
+
+	if err := l.unexpected(status, http.StatusOK); err != nil {
+		return false, err
+	}
+
+	if current.Spec.HolderIdentity != l.identity && !l.expired(current, now) {
+		return false, nil
+	}
+
+	// Only the fields of the lease used for leader election are changed, so
+	// the rest of the lease is kept. The resource version makes the patch fail
+	// with a conflict if another replica updated the lease in the meantime.
+	l.hold(current, now)
+	patch := map[string]any{
+		"metadata": map[string]string{"resourceVersion": current.Metadata.ResourceVersion},
+		"spec":     current.Spec,
+	}
+
+	status, err = l.request(ctx, "PATCH", l.leasePath(), patch, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return status == http.StatusOK, l.unexpected(status, http.StatusOK, http.StatusConflict)
+}
+
+// Make this replica the holder of the lease, renewed at the given time
+func (l *leaderElector) hold(lease *lease, now time.Time) {
+	if lease.Spec.HolderIdentity != l.identity {
+		if lease.Spec.HolderIdentity != "" {
+			lease.Spec.LeaseTransitions++
+		}
+
+		lease.Spec.HolderIdentity = l.identity
+		lease.Spec.AcquireTime = now.UTC().Format(microTime)
+	}
+
+	lease.Spec.LeaseDurationSeconds = int(l.leaseDuration.Seconds())
+	lease.Spec.RenewTime = now.UTC().Format(microTime)
+}
+
+// Reports whether the holder of a lease failed to renew it in time. The renew
+// time in the lease is taken from the clock of the holder, so like client-go
+// the lease only expires once the lease duration has passed on the local clock
+// without the lease changing. Clock skew between the replicas doesn't matter.
+func (l *leaderElector) expired(lease *lease, now time.Time) bool {
+	if lease.Spec.HolderIdentity == "" {
+		return true
+	}
+
+	observed := observedLease{
+		holderIdentity:  lease.Spec.HolderIdentity,
+		renewTime:       lease.Spec.RenewTime,
+		resourceVersion: lease.Metadata.ResourceVersion,
+	}
+	if observed != l.observed {
+		l.observed = observed
+		l.observedTime = now
+	}
+
+	duration := time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second
+	return now.After(l.observedTime.Add(duration))
+}
+
+func (l *leaderElector) leasePath() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", l.namespace, l.name)
+}
+
+func (l *leaderElector) unexpected(status int, expected ...int) error {
+	for _, code := range expected {
+		if status == code {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unexpected status %d from Kubernetes for lease %s/%s", status, l.namespace, l.name)
+}
+
+// Send a request to the Kubernetes API, decoding the response into resp when
+// the request succeeds. Patches are sent as JSON merge patches.
+func (l *leaderElector) request(ctx context.Context, method, path string, body, resp any) (int, error) {
+	var value io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+
+		value = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, (l.apiURL + path), value)
+	if err != nil {
+		return 0, err
+	}
+
+	token, err := l.token()
+	if err != nil {
+		return 0, err
+	}
+
+	contentType := "application/json"
+	if method == "PATCH" {
+		contentType = "application/merge-patch+json"
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer res.Body.Close()
+
+	if resp != nil && res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+			return 0, err
+		}
+	}
+
+	return res.StatusCode, nil
+}
+
+// Provider only applying changes while it's the leader. Records are read by all
+// replicas.
+type leaderOnlyProvider struct {
+	Provider
+	isLeader func() bool
+}
+
+func (p *leaderOnlyProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
+	if !p.isLeader() {
+		return errNotLeader
+	}
+
+	return p.Provider.ApplyChanges(ctx, changes)
+}
@@ -0,0 +1,328 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// Serves a single lease like the Kubernetes API, with optimistic concurrency
+// through the resource version. The lease is kept as it was sent, so fields
+// not used for leader election are kept too.
+type fakeLeaseAPI struct {
+	lock    sync.Mutex
+	lease   map[string]any
+	version int
+}
+
+func (f *fakeLeaseAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if f.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(f.lease)
+	case "POST":
+		if f.lease != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		json.NewDecoder(r.Body).Decode(&f.lease)
+		f.setVersion()
+		w.WriteHeader(http.StatusCreated)
+	case "PATCH":
+		if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		patch := map[string]any{}
+		json.NewDecoder(r.Body).Decode(&patch)
+
+		version := patch["metadata"].(map[string]any)["resourceVersion"]
+		if version != f.lease["metadata"].(map[string]any)["resourceVersion"] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		mergePatch(f.lease, patch)
+		f.setVersion()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeLeaseAPI) setVersion() {
+	f.version++
+	f.lease["metadata"].(map[string]any)["resourceVersion"] = strconv.Itoa(f.version)
+}
+
+// The fields of the lease used for leader election
+func (f *fakeLeaseAPI) current() *lease {
+	data, _ := json.Marshal(f.lease)
+	current := &lease{}
+	json.Unmarshal(data, current)
+	return current
+}
+
+// Apply a JSON merge patch as described in RFC 7386
+func mergePatch(target, patch map[string]any) {
+	for key, value := range patch {
+		nested, isObject := value.(map[string]any)
+		existing, hasObject := target[key].(map[string]any)
+
+		switch {
+		case value == nil:
+			delete(target, key)
+		case isObject && hasObject:
+			mergePatch(existing, nested)
+		default:
+			target[key] = value
+		}
+	}
+}
+
+func newTestLeaderElector(url, identity string) *leaderElector {
+	return &leaderElector{
+		client:        http.DefaultClient,
+		apiURL:        url,
+		token:         func() (string, error) { return "token", nil },
+		namespace:     "dns",
+		name:          "webhook",
+		identity:      identity,
+		leaseDuration: 15 * time.Second,
+		renewDeadline: 10 * time.Second,
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	api := &fakeLeaseAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	first := newTestLeaderElector(server.URL, "first")
+	second := newTestLeaderElector(server.URL, "second")
+	now := time.Now()
+
+	// The first replica creates the lease and the second finds it held
+	first.tryAcquireOrRenew(context.Background(), now)
+	second.tryAcquireOrRenew(context.Background(), now)
+	if !first.isLeader() || second.isLeader() {
+		t.Fatalf("expected only the first replica to lead, got %v and %v", first.isLeader(), second.isLeader())
+	}
+
+	// Renewing keeps the lease with the first replica
+	now = now.Add(10 * time.Second)
+	first.tryAcquireOrRenew(context.Background(), now)
+	second.tryAcquireOrRenew(context.Background(), now.Add(10*time.Second))
+	if !first.isLeader() || second.isLeader() {
+		t.Fatalf("expected the first replica to keep leading, got %v and %v", first.isLeader(), second.isLeader())
+	}
+
+	// The lease is taken over when the second replica hasn't seen it renewed
+	// for the lease duration
+	second.tryAcquireOrRenew(context.Background(), now.Add(20*time.Second))
+	if second.isLeader() {
+		t.Fatalf("expected the lease to be kept until the lease duration has passed since it was renewed")
+	}
+
+	second.tryAcquireOrRenew(context.Background(), now.Add(30*time.Second))
+	if !second.isLeader() {
+		t.Fatalf("expected the second replica to take over")
+	}
+
+	if spec := api.current().Spec; spec.HolderIdentity != "second" || spec.LeaseTransitions != 1 {
+		t.Errorf("expected the lease to be held by the second replica after 1 transition, got %+v", spec)
+	}
+
+	first.tryAcquireOrRenew(context.Background(), now.Add(30*time.Second))
+	if first.isLeader() {
+		t.Errorf("expected the first replica to lose leadership")
+	}
+}
+
+func TestLeaderElectionErrors(t *testing.T) {
+	api := &fakeLeaseAPI{}
+	server := httptest.NewServer(api)
+
+	elector := newTestLeaderElector(server.URL, "first")
+	now := time.Now()
+	elector.tryAcquireOrRenew(context.Background(), now)
+
+	// Leadership is kept through errors until the renew deadline, which is
+	// before the lease would have expired
+	server.Close()
+	elector.tryAcquireOrRenew(context.Background(), now.Add(5*time.Second))
+	if !elector.isLeader() {
+		t.Errorf("expected leadership to be kept within the renew deadline")
+	}
+
+	elector.tryAcquireOrRenew(context.Background(), now.Add(12*time.Second))
+	if elector.isLeader() {
+		t.Errorf("expected leadership to be lost after the renew deadline")
+	}
+}
+
+func TestLeaderRenewDeadline(t *testing.T) {
+	api := &fakeLeaseAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	// The leader steps down at the renew deadline, even when no attempt to
+	// renew the lease has failed yet
+	elector := newTestLeaderElector(server.URL, "first")
+	elector.tryAcquireOrRenew(context.Background(), time.Now().Add(-9*time.Second))
+	if !elector.isLeader() {
+		t.Fatalf("expected leadership within the renew deadline")
+	}
+
+	elector.lastRenew.Store(time.Now().Add(-11 * time.Second).UnixNano())
+	if elector.isLeader() {
+		t.Errorf("expected leadership to be lost after the renew deadline")
+	}
+}
+
+func TestLeaderElectionKeepsLease(t *testing.T) {
+	api := &fakeLeaseAPI{
+		lease: map[string]any{
+			"apiVersion": "coordination.k8s.io/v1",
+			"kind":       "Lease",
+			"metadata": map[string]any{
+				"name":            "webhook",
+				"namespace":       "dns",
+				"resourceVersion": "0",
+				"labels":          map[string]any{"app": "webhook"},
+			},
+			"spec": map[string]any{
+				"holderIdentity":   "gone",
+				"renewTime":        time.Now().Add(-time.Hour).UTC().Format(microTime),
+				"leaseTransitions": 3,
+				"preferredHolder":  "first",
+			},
+		},
+	}
+
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	// The lease is only known to be expired once it hasn't changed for the
+	// lease duration
+	elector := newTestLeaderElector(server.URL, "first")
+	elector.tryAcquireOrRenew(context.Background(), time.Now().Add(-16*time.Second))
+	elector.tryAcquireOrRenew(context.Background(), time.Now())
+	if !elector.isLeader() {
+		t.Fatalf("expected the expired lease to be taken over")
+	}
+
+	// Fields of the lease not used for leader election are left alone
+	metadata := api.lease["metadata"].(map[string]any)
+	if labels, _ := metadata["labels"].(map[string]any); labels["app"] != "webhook" || metadata["name"] != "webhook" {
+		t.Errorf("expected the metadata of the lease to be kept, got %v", metadata)
+	}
+
+	spec := api.lease["spec"].(map[string]any)
+	if spec["preferredHolder"] != "first" || spec["holderIdentity"] != "first" || spec["leaseTransitions"] != 4.0 {
+		t.Errorf("expected the lease to be taken over and its other fields kept, got %v", spec)
+	}
+}
+
+func TestLeaderElectionClockSkew(t *testing.T) {
+	tests := []struct {
+		name string
+		skew time.Duration
+	}{
+		{"Holder behind", -time.Hour},
+		{"Holder ahead", time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Now()
+			api := &fakeLeaseAPI{
+				lease: map[string]any{
+					"metadata": map[string]any{"name": "webhook", "namespace": "dns", "resourceVersion": "1"},
+					"spec": map[string]any{
+						"holderIdentity":       "first",
+						"leaseDurationSeconds": 15,
+						"renewTime":            now.Add(test.skew).UTC().Format(microTime),
+					},
+				},
+			}
+
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			// The renew time of the holder isn't compared with the local clock
+			second := newTestLeaderElector(server.URL, "second")
+			second.tryAcquireOrRenew(context.Background(), now)
+			second.tryAcquireOrRenew(context.Background(), now.Add(10*time.Second))
+			if second.isLeader() {
+				t.Fatalf("expected the lease to be kept by its holder")
+			}
+
+			second.tryAcquireOrRenew(context.Background(), now.Add(16*time.Second))
+			if !second.isLeader() {
+				t.Errorf("expected the lease to be taken over once it wasn't renewed for the lease duration")
+			}
+		})
+	}
+}
+
+func TestLeaderOnlyProvider(t *testing.T) {
+	leader := false
+	provider := &leaderOnlyProvider{
+		Provider: &tidyProvider{
+			tidy:         &mockTidyDNSClient{},
+			zoneProvider: &mockZoneProvider{},
+		},
+		isLeader: func() bool { return leader },
+	}
+
+	if err := provider.ApplyChanges(context.Background(), &plan.Changes{}); !errors.Is(err, errNotLeader) {
+		t.Errorf("expected errNotLeader, got %v", err)
+	}
+
+	if _, err := provider.Records(context.Background()); err != nil {
+		t.Errorf("expected followers to read records, got %v", err)
+	}
+
+	leader = true
+	if err := provider.ApplyChanges(context.Background(), &plan.Changes{}); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
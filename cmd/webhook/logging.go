@@ -24,9 +24,9 @@ import (
 const defaultLogLevel = slog.LevelInfo
 
 // Set up logging with slog using JSON format. Take a level string the can be
-// one of (debug, info, warn, error), an out file where the log will be printet
-// to and the addSource boolean which when true will cause slog to print the
-// func, file and sourceline of the log call.
+// one of (debug, info, warn, error), an out writer where the log will be
+// printet to and the addSource boolean which when true will cause slog to print
+// the func, file and sourceline of the log call.
 func loggingSetup(logFormat, logLevel string, out io.Writer, addSource bool) *slog.Logger {
 	programLevel := new(slog.LevelVar)
 	handlerOpts := slog.HandlerOptions{
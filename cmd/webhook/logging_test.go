@@ -63,6 +63,24 @@ func TestLoggingSetup(t *testing.T) {
 	}
 }
 
+func TestLoggingSetupInvalidLevel(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := loggingSetup("text", "verbose", &buf, false)
+
+	// The bad level is reported through the logger itself
+	if !bytes.Contains(buf.Bytes(), []byte("level=ERROR")) || !bytes.Contains(buf.Bytes(), []byte("verbose")) {
+		t.Errorf("Expected error about the invalid level, got %q", buf.String())
+	}
+
+	// The level falls back to the default, so debug messages are dropped
+	buf.Reset()
+	logger.Debug("test debug log")
+	if buf.Len() != 0 {
+		t.Errorf("Expected debug log to be dropped, got %q", buf.String())
+	}
+}
+
 func TestLoggingSetupWithSource(t *testing.T) {
 	var buf bytes.Buffer
 	out := &buf
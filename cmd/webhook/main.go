@@ -17,39 +17,155 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
 	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
-	"go.opentelemetry.io/otel/exporters/prometheus"
-	"go.opentelemetry.io/otel/sdk/metric"
-	"sigs.k8s.io/external-dns/provider/webhook/api"
+	otel "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
 )
 
+// Largest request body accepted from External-DNS by default, which is far more
+// than the changes of even large zones take up
+const defaultMaxRequestBytes = 10 << 20
+
+var metricsPrefixPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_.-]*)?$`)
+
 type config struct {
 	logLevel           string
 	logFormat          string
+	logOutput          string
 	tidyEndpoint       string
+	tidyZoneEndpoints  map[string]string
 	readTimeout        time.Duration
 	writeTimeout       time.Duration
+	applyTimeout       time.Duration
+	maxRequestBytes    int64
 	zoneUpdateInterval time.Duration
+	zoneUpdateJitter   float64
+	zoneListTimeout    time.Duration
 	tidyUsername       string
 	tidyPassword       string
+	tidyCredentials    tidyCredentials
+	zoneGroup          string
+	includeInactive    bool
+	enableDebug        bool
+	enablePprof        bool
+	metricsPrefix      string
+	tidyProxy          *url.URL
+	tidyNoProxy        bool
+	showVersion        bool
+	tidyUserAgent      string
+	tidyAuthMode       string
+	tidyAPIVersion     string
+	tidyCreateFields   map[string]string
+	tidyRetries        int
+	tidyMaxRetryWait   time.Duration
+	tidyConcurrency    int
+	maxConcurrent      int
+	metricsTimeouts    exposedTimeouts
+	ttl                ttlPolicy
+	zoneDefaults       zoneDefaults
+	webhookAddress     string
+	trustProxy         bool
+	leaderElection     leaderElectionConfig
+	tidyHealthCheck    tidyHealthCheckConfig
+	domains            domainScope
+	idnaProfile        string
+	disableIDNA        bool
+	deleteLimit        deleteLimit
+
+	// Provider specific property holding the description of created records
+	descriptionProperty string
+
+	// Marker in the description of the records the webhook manages, when only
+	// those are to be managed
+	ownerMarker string
+
+	// Record types managed by the webhook, all supported types when empty
+	managedRecordTypes []string
+
+	// Merge records of the same name and type into one endpoint
+	mergeRecords bool
+
+	// Keep targets in the order of the records in Tidy rather than sorted
+	preserveTargetOrder bool
+	rejectEmptyTargets  bool
+	apexToken           string
+
+	// Restore the records replaced by updates whose new records fail
+	rollbackUpdates bool
+
+	// List the zones and records in Tidy once and exit
+	once bool
+
+	// The values of all flags and the credentials, with secrets redacted
+	effective map[string]string
+
+	// Don't collect or serve metrics, otherwise export them the given way
+	disableMetrics  bool
+	metricsExporter string
+
+	// Trace provider operations and requests to Tidy
+	enableTracing bool
+}
+
+type leaderElectionConfig struct {
+	enabled       bool
+	leaseName     string
+	namespace     string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+}
+
+type tidyHealthCheckConfig struct {
+	enabled  bool
+	timeout  time.Duration
+	cacheFor time.Duration
 }
 
 func main() {
 	cfg, parsingErr := parseConfig()
 
+	// Print the build information and exit before anything is started
+	if parsingErr == nil && cfg.showVersion {
+		fmt.Println(readBuildInfo())
+		return
+	}
+
+	// Without a configuration the parsing error is logged the default way, as
+	// the logging can't be set up as configured
+	if parsingErr != nil {
+		loggingSetup("text", "info", os.Stderr, true)
+		slog.Error(parsingErr.Error())
+		os.Exit(2)
+	}
+
+	logOutput := os.Stderr
+	if cfg.logOutput == "stdout" {
+		logOutput = os.Stdout
+	}
+
 	// Setup the default slog logger
-	loggingSetup(cfg.logFormat, cfg.logLevel, os.Stderr, true)
+	loggingSetup(cfg.logFormat, cfg.logLevel, logOutput, true)
 
 	// External DNS uses logrus for logging, so we set that up as well
+	log.SetOutput(logOutput)
 	if cfg.logFormat == "json" {
 		log.SetFormatter(&log.JSONFormatter{})
 	} else {
@@ -65,38 +181,198 @@ func main() {
 		}
 	}()
 
-	// We check for parsingerrors here, as we want to log them with slog
-	if parsingErr != nil {
-		panic(parsingErr.Error())
+	slog.Info("effective configuration", "config", cfg.effective)
+
+	// Without metrics the instrumentation records to a noop meter and nothing
+	// is served on /metrics
+	var meterProvider otel.MeterProvider = noop.NewMeterProvider()
+	var metricsHandler http.Handler
+	if !cfg.disableMetrics {
+		// Use the exporter to make a meter for Tidy to attach instrumentation
+		var err error
+		if meterProvider, metricsHandler, err = newMeterProvider(cfg.metricsExporter); err != nil {
+			panic(err.Error())
+		}
 	}
 
-	// Create a Prometheus reader/exporter
-	prom, err := prometheus.New(prometheus.WithoutScopeInfo())
+	tidyMeter := meterProvider.Meter("tidy")
+	webhookMeter := meterProvider.Meter("webhook")
+
+	// Spans are only recorded when tracing is enabled
+	tracerProvider, err := newTracerProvider(cfg.enableTracing)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	// Use the exporter to make a meter for Tidy to attach instrumentation
-	meterProvider := metric.NewMeterProvider(metric.WithReader(prom))
-	tidyMeter := meterProvider.Meter("tidy")
+	// Metrics and spans pushed over OTLP are buffered, so they're flushed
+	// before the webhook exits, including when it's told to terminate
+	shutdown := sync.OnceFunc(func() {
+		shutdownTelemetry(telemetryShutdownTimeout, meterProvider, tracerProvider)
+	})
+	defer shutdown()
+
+	terminate, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	go func() {
+		<-terminate.Done()
+		slog.Info("terminating")
+		shutdown()
+		os.Exit(0)
+	}()
 
 	// Make a Tidy object to abstract calls to Tidy
-	tidy, err := tidydns.NewTidyDnsClient(cfg.tidyEndpoint, cfg.tidyUsername, cfg.tidyPassword, (10 * time.Second), tidyMeter)
+	tidyOpts := []tidydns.Option{
+		tidydns.WithMetricsPrefix(cfg.metricsPrefix),
+		tidydns.WithUserAgent(cfg.tidyUserAgent),
+		tidydns.WithAuthMode(tidydns.AuthMode(cfg.tidyAuthMode)),
+		tidydns.WithAPIVersion(tidydns.APIVersion(cfg.tidyAPIVersion)),
+		tidydns.WithRateLimitRetries(cfg.tidyRetries, cfg.tidyMaxRetryWait),
+		tidydns.WithTracerProvider(tracerProvider),
+		tidydns.WithCreateFields(cfg.tidyCreateFields),
+	}
+
+	if cfg.tidyNoProxy || cfg.tidyProxy != nil {
+		tidyOpts = append(tidyOpts, tidydns.WithProxy(cfg.tidyProxy))
+	}
+
+	// Credentials read from files are read again when Tidy rejects them, as
+	// the files are updated when the secret is rotated
+	if cfg.tidyCredentials.reloadable() {
+		tidyOpts = append(tidyOpts, tidydns.WithCredentialsReload(cfg.tidyCredentials.read))
+	}
+
+	tidy, err := tidydns.NewTidyDnsClient(cfg.tidyEndpoint, cfg.tidyUsername, cfg.tidyPassword, (10 * time.Second), tidyMeter, tidyOpts...)
 	if err != nil {
 		panic(err.Error())
 	}
 
+	// Zones served by other Tidy servers get a client per server, using the
+	// same credentials and options
+	endpointClients := map[string]tidydns.TidyDNSClient{}
+	zoneClients := map[string]tidydns.TidyDNSClient{}
+	for zone, endpoint := range cfg.tidyZoneEndpoints {
+		if _, ok := endpointClients[endpoint]; !ok {
+			client, err := tidydns.NewTidyDnsClient(endpoint, cfg.tidyUsername, cfg.tidyPassword, (10 * time.Second), tidyMeter, tidyOpts...)
+			if err != nil {
+				panic(err.Error())
+			}
+
+			endpointClients[endpoint] = client
+		}
+
+		zoneClients[zone] = endpointClients[endpoint]
+	}
+
+	backends := tidyBackends{fallback: tidy, zones: zoneClients, listTimeout: cfg.zoneListTimeout}
+
+	// Only check the connection to Tidy without starting the servers
+	if cfg.once {
+		if err := checkConnectivity(context.Background(), backends, cfg.zoneGroup, os.Stdout); err != nil {
+			slog.Error(err.Error())
+			shutdown()
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	// With the Tidy object, make a provider to handle the logic and conversions
 	// between External-DNS and Tidy
-	provider := newProvider(tidy, cfg.zoneUpdateInterval)
+	providerCfg := providerConfig{
+		zoneUpdateInterval:  cfg.zoneUpdateInterval,
+		zoneUpdateJitter:    cfg.zoneUpdateJitter,
+		zoneListTimeout:     cfg.zoneListTimeout,
+		zoneGroup:           cfg.zoneGroup,
+		includeInactive:     cfg.includeInactive,
+		ttl:                 cfg.ttl,
+		zoneDefaults:        cfg.zoneDefaults,
+		concurrency:         cfg.tidyConcurrency,
+		applyWorkers:        cfg.maxConcurrent,
+		domains:             cfg.domains,
+		idnaProfile:         idnaProfiles[cfg.idnaProfile],
+		disableIDNA:         cfg.disableIDNA,
+		deleteLimit:         cfg.deleteLimit,
+		descriptionProperty: cfg.descriptionProperty,
+		ownerMarker:         cfg.ownerMarker,
+		managedTypes:        cfg.managedRecordTypes,
+		splitRecords:        !cfg.mergeRecords,
+		preserveTargetOrder: cfg.preserveTargetOrder,
+		apexToken:           cfg.apexToken,
+		rejectEmptyTargets:  cfg.rejectEmptyTargets,
+		rollbackUpdates:     cfg.rollbackUpdates,
+		metricsPrefix:       cfg.metricsPrefix,
+	}
+
+	provider, err := newProvider(tidy, zoneClients, providerCfg, tracerProvider.Tracer("webhook"), webhookMeter)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// With leader election only the leader applies changes to Tidy
+	var webhookProvider Provider = provider
+	if cfg.leaderElection.enabled {
+		identity, err := os.Hostname()
+		if err != nil {
+			panic(err.Error())
+		}
+
+		election := cfg.leaderElection
+		elector, err := newInClusterLeaderElector(election.namespace, election.leaseName, identity, election.leaseDuration, election.renewDeadline)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		go elector.run(context.Background())
+		webhookProvider = &leaderOnlyProvider{Provider: provider, isLeader: elector.isLeader}
+	}
 
 	// Start webserver to service requests from External-DNS
-	go api.StartHTTPApi(provider, nil, cfg.readTimeout, cfg.writeTimeout, "127.0.0.1:8888")
+	webhook := newWebhook(webhookProvider)
+	webhook.applyTimeout = cfg.applyTimeout
+	webhook.maxRequestBytes = cfg.maxRequestBytes
+	webhook.trustProxy = cfg.trustProxy
+	if len(cfg.managedRecordTypes) > 0 {
+		webhook.recordTypes = cfg.managedRecordTypes
+	}
+	go func() {
+		err := serveWebhook(webhook, cfg.webhookAddress, cfg.readTimeout, cfg.writeTimeout, webhookMeter, cfg.metricsPrefix)
+		slog.Error(err.Error())
+		shutdown()
+		os.Exit(1)
+	}()
 
-	metricsHandler := promhttp.Handler()
+	// List the records of all zones once before reporting ready, so External-DNS
+	// isn't served a partial record set while Tidy is slow to answer
+	go func() {
+		if err := provider.prefetch(context.Background(), (10 * time.Second)); err == nil {
+			webhook.prefetched.Store(true)
+		}
+	}()
+
+	handlers := exposedHandlers{
+		metrics: metricsHandler,
+		livez:   http.HandlerFunc(webhook.livez),
+		readyz:  http.HandlerFunc(webhook.readyz),
+	}
+
+	// Debug endpoints are only served when enabled
+	if cfg.enableDebug {
+		handlers.debug = newDebugHandler(provider, cfg.effective)
+	}
+
+	// The health check only asks Tidy when enabled
+	if healthCheck := cfg.tidyHealthCheck; healthCheck.enabled {
+		listZones := func() error {
+			_, err := backends.ListZones(context.Background())
+			return err
+		}
+
+		handlers.healthz = newTidyHealthCheck(listZones, healthCheck.timeout, healthCheck.cacheFor)
+	}
 
 	// Start website to service metrics and health check
-	if err = serveExposed("0.0.0.0:8080", metricsHandler); err != nil {
+	if err = serveExposed("0.0.0.0:8080", cfg.metricsTimeouts, handlers, cfg.enablePprof); err != nil {
 		panic(err.Error())
 	}
 }
@@ -104,17 +380,190 @@ func main() {
 func parseConfig() (*config, error) {
 	logLevel := flag.String("log-level", "info", "Set the level of logging. (default: info, options: debug, info, warning, error)")
 	logFormat := flag.String("log-format", "text", "The format in which log messages are printed (default: text, options: text, json)")
+	logOutput := flag.String("log-output", "stderr", "The stream log messages are printed to (default: stderr, options: stderr, stdout)")
 	tidyEndpoint := flag.String("tidydns-endpoint", "", "DNS server address")
+	tidyZoneEndpointsArg := flag.String("tidydns-zone-endpoints", "", "Tidy servers of zones not served by tidydns-endpoint e.g. example.org=https://tidy2.example.com")
+	tidyAuthMode := flag.String("tidydns-auth-mode", "basic", "How to authenticate to Tidy (default: basic, options: basic, session)")
+	tidyAPIVersion := flag.String("tidydns-api-version", string(tidydns.APIVersionV1), "Version of the Tidy API to use (default: v1, options: v1)")
+	tidyProxyArg := flag.String("tidydns-proxy-url", "", "Proxy for requests to Tidy (default: taken from the environment)")
+	tidyNoProxy := flag.Bool("tidydns-no-proxy", false, "Never use a proxy for requests to Tidy, ignoring the environment (default: false)")
+	tidyCreateFieldsArg := flag.String("tidydns-create-fields", "", "Extra form fields sent to Tidy when creating records e.g. dynamic=1,view_id=2")
+	tidyUserAgent := flag.String("tidydns-user-agent", "", "User-Agent sent with requests to Tidy (default: external-dns-tidydns-webhook/<version>)")
+	tidyRetries := flag.Int("tidydns-rate-limit-retries", 0, "Times to retry requests rate limited by Tidy (default: 0)")
+	tidyMaxRetryWait := flag.Duration("tidydns-max-retry-wait", (30 * time.Second), "Longest wait before retrying a rate limited request (default: 30s)")
+	tidyConcurrency := flag.Int("tidydns-concurrency", 4, "Number of zones whose records are listed from Tidy at the same time (default: 4)")
+	maxConcurrent := flag.Int("max-concurrent-requests", 8, "Number of records created or deleted in Tidy at the same time when applying changes (default: 8)")
+	webhookAddress := flag.String("webhook-address", "127.0.0.1:8888", "Address the webhook API used by External-DNS is served on (default: 127.0.0.1:8888)")
+	trustProxy := flag.Bool("trust-proxy", false, "Log the client address given by proxies in the Forwarded, X-Forwarded-For or X-Real-IP header of webhook requests (default: false)")
+	allowRemote := flag.Bool("insecure-allow-remote", false, "Allow serving the unauthenticated webhook API on a non-loopback address (default: false)")
 	readTimeout := flag.Duration("read-timeout", (5 * time.Second), "Read timeout in duration format (default: 5s)")
+	maxRequestBytes := flag.Int64("max-request-bytes", defaultMaxRequestBytes, "Largest body of requests from External-DNS accepted, 0 for no limit (default: 10485760)")
+	applyTimeout := flag.Duration("apply-timeout", 0, "Time applying changes may take before the remaining changes are abandoned (default: 90% of write-timeout)")
 	writeTimeout := flag.Duration("write-timeout", (10 * time.Second), "Write timeout in duration format (default: 10s)")
+	metricsReadTimeout := flag.Duration("metrics-read-timeout", (5 * time.Second), "Read timeout of the metrics and health server (default: 5s)")
+	metricsWriteTimeout := flag.Duration("metrics-write-timeout", (10 * time.Second), "Write timeout of the metrics and health server (default: 10s)")
+	metricsIdleTimeout := flag.Duration("metrics-idle-timeout", (60 * time.Second), "Idle timeout of the metrics and health server (default: 60s)")
 
 	zoneArgDescription := "The intercval at which to update zone information format 00h00m00s e.g. 1h32m"
 	zoneUpdateIntervalArg := flag.String("zone-update-interval", "10m", zoneArgDescription)
+	zoneUpdateJitter := flag.Float64("zone-update-jitter", 0, "Fraction of the zone update interval it's randomly lengthened or shortened by e.g. 0.1 (default: 0)")
+	zoneListTimeout := flag.Duration("zone-list-timeout", 0, "Time listing the zones in Tidy may take, shorter than the Tidy request timeout of 10s to have effect (default: the Tidy request timeout)")
+	zoneGroup := flag.String("tidydns-zone-group", "", "Only manage zones in this Tidy group (default: all zones)")
+	enableDebug := flag.Bool("enable-debug-endpoints", false, "Serve debug endpoints under /debug/ with the metrics (default: false)")
+	enablePprof := flag.Bool("enable-pprof", false, "Serve the Go profiler under /debug/pprof/ with the metrics (default: false)")
+	metricsPrefix := flag.String("metrics-prefix", tidydns.DefaultMetricsPrefix, "Prefix of the metric names (default: tidy_)")
+	includeInactive := flag.Bool("include-inactive-records", false, "Report records which are inactive in Tidy to External-DNS (default: false)")
+	minTTL := flag.Int("min-ttl", defaultMinTTL, "The lowest TTL of records created in Tidy (default: 300)")
+	maxTTL := flag.Int("max-ttl", 0, "The highest TTL of records created in Tidy, 0 for no maximum (default: 0)")
+	zoneMinTTLArg := flag.String("zone-min-ttl", "", "The lowest TTL in specific zones overriding min-ttl e.g. example.com=60,example.org=300")
+	zoneDefaultLocationArg := flag.String("zone-default-location", "", "Location of records created in specific zones e.g. example.com=3,example.org=5 (default: 0)")
+	zoneDefaultStatusArg := flag.String("zone-default-status", "", "Status of records created in specific zones, active or disabled, e.g. example.com=disabled (default: active)")
+	ttlZeroMeansDefault := flag.Bool("ttl-zero-means-default", true, "Leave TTL 0, the zone default in Tidy, alone instead of applying min-ttl (default: true)")
+	enableLeaderElection := flag.Bool("enable-leader-election", false, "Only apply changes to Tidy from the replica holding a Kubernetes lease (default: false)")
+	leaseName := flag.String("leader-election-lease-name", "external-dns-tidydns-webhook", "Name of the lease used for leader election")
+	leaseNamespace := flag.String("leader-election-namespace", "", "Namespace of the lease used for leader election (default: the namespace of the pod)")
+	leaseDuration := flag.Duration("leader-election-lease-duration", (15 * time.Second), "Time the leader holds the lease without renewing it (default: 15s)")
+	renewDeadline := flag.Duration("leader-election-renew-deadline", (10 * time.Second), "Time the leader keeps leading without renewing the lease, shorter than the lease duration (default: 10s)")
+	healthCheckTidy := flag.Bool("health-check-tidy", false, "Make /healthz list the zones in Tidy and fail when Tidy is unreachable or rejects the credentials (default: false)")
+	healthCheckTimeout := flag.Duration("health-check-tidy-timeout", (5 * time.Second), "Time /healthz waits for Tidy to answer (default: 5s)")
+	healthCheckCache := flag.Duration("health-check-tidy-cache", (30 * time.Second), "Time the result of checking Tidy is reused by /healthz (default: 30s)")
+	domainFilter := domainList{}
+	flag.Var(&domainFilter, "domain-filter", "Only manage these domains within the zones in Tidy, separated by commas or newlines (default: all zones)")
+	excludeDomains := domainList{}
+	flag.Var(&excludeDomains, "exclude-domain", "Don't manage these domains within the zones in Tidy, separated by commas or newlines")
+	idnaProfile := flag.String("idna-profile", "lookup", "Profile unicode names are punycode encoded with (default: lookup, options: lookup, registration, nontransitional)")
+	disableIDNA := flag.Bool("disable-idna", false, "Pass names and zones on as they are instead of punycode encoding them (default: false)")
+	maxDeletes := flag.Int("max-deletes", 0, "Refuse changes deleting more records than this (default: 0, no limit)")
+	maxDeletePercent := flag.Float64("max-delete-percent", 0, "Refuse changes deleting more than this percentage of the records in Tidy (default: 0, no limit)")
+	descriptionLabel := flag.String("description-label", defaultDescriptionAnnotation, "Annotation with the description of records created in Tidy, empty to not set descriptions")
+	ownerMarker := flag.String("owner-marker", "", "Only manage records whose description in Tidy contains this marker e.g. managed-by=external-dns, adding it to created records (default: manage all records)")
+	managedRecordTypesArg := flag.String("managed-record-types", "", "Only manage records of these types e.g. A,AAAA,CNAME,TXT (default: all supported types)")
+	mergeRecords := flag.Bool("merge-records", true, "Report records with the same name and type as one endpoint with multiple targets (default: true)")
+	preserveTargetOrder := flag.Bool("preserve-target-order", false, "Report the targets of merged records in the order the records were created in Tidy rather than sorted")
+	apexToken := flag.String("apex-name-token", apexTokenAuto, "Name of the apex of a zone in Tidy, ., @ or empty (default: auto, as seen in the records in Tidy, otherwise .)")
+	rollbackUpdates := flag.Bool("rollback-failed-updates", true, "Recreate the records replaced by an update when its new records can't be created (default: true)")
+	rejectEmptyTargets := flag.Bool("reject-empty-targets", false, "Fail changes with endpoints without targets rather than only warning about them (default: false)")
+	metricsExporter := flag.String("metrics-exporter", metricsExporterPrometheus, "How metrics are exported, otlp pushing them to the collector given by the OTEL_EXPORTER_OTLP_* environment variables (default: prometheus, options: prometheus, otlp)")
+	enableTracing := flag.Bool("enable-tracing", false, "Trace provider operations and requests to Tidy, pushing the spans to the collector given by the OTEL_EXPORTER_OTLP_* environment variables (default: false)")
+	disableMetrics := flag.Bool("disable-metrics", false, "Don't collect metrics or serve them on port 8080, which still serves the health checks (default: false)")
+	once := flag.Bool("once", false, "List the zones and records in Tidy once, print a summary and exit without serving, failing on any error")
+	showVersion := flag.Bool("version", false, "Print the version, commit and Go version of the build and exit")
 
 	flag.Parse()
 
-	tidyUsername := os.Getenv("TIDYDNS_USER")
-	tidyPassword := os.Getenv("TIDYDNS_PASS")
+	// Flags not given on the command line are taken from the environment
+	if err := applyEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	// Nothing else is needed to print the version
+	if *showVersion {
+		return &config{showVersion: true}, nil
+	}
+
+	if *logOutput != "stderr" && *logOutput != "stdout" {
+		return nil, fmt.Errorf("invalid log output %s", *logOutput)
+	}
+
+	if *tidyAuthMode != string(tidydns.AuthModeBasic) && *tidyAuthMode != string(tidydns.AuthModeSession) {
+		return nil, fmt.Errorf("invalid auth mode %s", *tidyAuthMode)
+	}
+
+	if !slices.Contains(tidydns.APIVersions(), tidydns.APIVersion(*tidyAPIVersion)) {
+		return nil, fmt.Errorf("invalid Tidy API version %s", *tidyAPIVersion)
+	}
+
+	// The webhook API changes DNS without any authentication, so it mustn't
+	// be reachable from the pod network by accident
+	if !*allowRemote && !isLoopbackAddress(*webhookAddress) {
+		return nil, fmt.Errorf("webhook address %s isn't a loopback address, set insecure-allow-remote to serve the webhook API to remote clients", *webhookAddress)
+	}
+
+	if *tidyRetries < 0 {
+		return nil, fmt.Errorf("invalid rate limit retries %d", *tidyRetries)
+	}
+
+	if !slices.Contains(metricsExporters, *metricsExporter) {
+		return nil, fmt.Errorf("invalid metrics exporter %s", *metricsExporter)
+	}
+
+	if _, ok := idnaProfiles[*idnaProfile]; !ok {
+		return nil, fmt.Errorf("invalid IDNA profile %s", *idnaProfile)
+	}
+
+	if *maxRequestBytes < 0 {
+		return nil, fmt.Errorf("invalid max request bytes %d", *maxRequestBytes)
+	}
+
+	if *applyTimeout < 0 {
+		return nil, fmt.Errorf("invalid apply timeout %s", *applyTimeout)
+	}
+
+	// Unless given, changes are abandoned a little before the server stops
+	// waiting to write the response, so the response still reaches External-DNS
+	if *applyTimeout == 0 {
+		*applyTimeout = *writeTimeout * 9 / 10
+	}
+
+	if *apexToken != apexTokenAuto && !isApexName(*apexToken) {
+		return nil, fmt.Errorf("invalid apex name token %q, must be ., @ or empty", *apexToken)
+	}
+
+	if *maxDeletes < 0 {
+		return nil, fmt.Errorf("invalid max deletes %d", *maxDeletes)
+	}
+
+	if *maxDeletePercent < 0 || *maxDeletePercent > 100 {
+		return nil, fmt.Errorf("invalid max delete percent %v, must be between 0 and 100", *maxDeletePercent)
+	}
+
+	descriptionProperty, err := annotationProperty(*descriptionLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	managedRecordTypes, err := parseRecordTypes(*managedRecordTypesArg)
+	if err != nil {
+		return nil, err
+	}
+
+	if *tidyConcurrency < 1 {
+		return nil, fmt.Errorf("invalid Tidy concurrency %d, must be at least 1", *tidyConcurrency)
+	}
+
+	if *maxConcurrent < 1 {
+		return nil, fmt.Errorf("invalid max concurrent requests %d, must be at least 1", *maxConcurrent)
+	}
+
+	if *minTTL < 1 {
+		return nil, fmt.Errorf("invalid minimum TTL %d", *minTTL)
+	}
+
+	if *maxTTL < 0 {
+		return nil, fmt.Errorf("invalid maximum TTL %d", *maxTTL)
+	}
+
+	// Metric names must start with a letter and continue with letters,
+	// digits, underscores, dots or dashes
+	if !metricsPrefixPattern.MatchString(*metricsPrefix) {
+		return nil, fmt.Errorf("invalid metrics prefix %s", *metricsPrefix)
+	}
+
+	// Identify the webhook and its version to Tidy unless told otherwise
+	if *tidyUserAgent == "" {
+		*tidyUserAgent = tidydns.DefaultUserAgent + "/" + readBuildInfo().version
+	}
+
+	tidyCreateFields, err := parseFormFields(*tidyCreateFieldsArg)
+	if err != nil {
+		return nil, err
+	}
+
+	tidyCredentials := tidyCredentialsFromEnv()
+	tidyUsername, tidyPassword, err := tidyCredentials.read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read tidyDNS credentials: %w", err)
+	}
 
 	// Parse the interval deciding how often the zone information is updated
 	zoneUpdateInterval, err := time.ParseDuration(*zoneUpdateIntervalArg)
@@ -122,14 +571,222 @@ func parseConfig() (*config, error) {
 		return nil, err
 	}
 
+	if *zoneListTimeout < 0 {
+		return nil, fmt.Errorf("invalid zone list timeout %s", *zoneListTimeout)
+	}
+
+	if *zoneUpdateJitter < 0 || *zoneUpdateJitter >= 1 {
+		return nil, fmt.Errorf("invalid zone update jitter %v, must be at least 0 and less than 1", *zoneUpdateJitter)
+	}
+
+	if *enableLeaderElection {
+		if *leaseNamespace == "" {
+			*leaseNamespace = inClusterNamespace()
+		}
+
+		if *leaseNamespace == "" {
+			return nil, fmt.Errorf("leader-election-namespace is required outside a Kubernetes pod")
+		}
+
+		if *leaseDuration < time.Second {
+			return nil, fmt.Errorf("invalid lease duration %s", *leaseDuration)
+		}
+
+		if *renewDeadline <= 0 || *renewDeadline >= *leaseDuration {
+			return nil, fmt.Errorf("invalid renew deadline %s, it must be shorter than the lease duration %s", *renewDeadline, *leaseDuration)
+		}
+	}
+
+	if *healthCheckTidy && *healthCheckTimeout <= 0 {
+		return nil, fmt.Errorf("invalid Tidy health check timeout %s", *healthCheckTimeout)
+	}
+
+	tidyZoneEndpoints, err := parseZoneEndpoints(*tidyZoneEndpointsArg)
+	if err != nil {
+		return nil, err
+	}
+
+	zoneMinTTL, err := parseZoneMinTTL(*zoneMinTTLArg)
+	if err != nil {
+		return nil, err
+	}
+
+	// A maximum under a minimum would make the minimum meaningless
+	if *maxTTL != 0 {
+		if *maxTTL < *minTTL {
+			return nil, fmt.Errorf("invalid maximum TTL %d, must be at least the minimum TTL %d", *maxTTL, *minTTL)
+		}
+
+		for zone, ttl := range zoneMinTTL {
+			if *maxTTL < ttl {
+				return nil, fmt.Errorf("invalid maximum TTL %d, must be at least the minimum TTL %d of zone %s", *maxTTL, ttl, zone)
+			}
+		}
+	}
+
+	zoneLocations, err := parseZoneLocations(*zoneDefaultLocationArg)
+	if err != nil {
+		return nil, err
+	}
+
+	zoneStatuses, err := parseZoneStatuses(*zoneDefaultStatusArg)
+	if err != nil {
+		return nil, err
+	}
+
+	includeDomains, err := domainFilter.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	excludedDomains, err := excludeDomains.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the proxy, which is left nil to use the environment
+	var tidyProxy *url.URL
+	if *tidyProxyArg != "" {
+		if *tidyNoProxy {
+			return nil, fmt.Errorf("tidydns-proxy-url and tidydns-no-proxy are mutually exclusive")
+		}
+
+		if tidyProxy, err = url.Parse(*tidyProxyArg); err != nil {
+			return nil, err
+		}
+
+		if tidyProxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %s", *tidyProxyArg)
+		}
+	}
+
 	return &config{
+		effective:          effectiveConfig(flag.CommandLine, tidyUsername, tidyPassword),
 		logLevel:           *logLevel,
 		logFormat:          *logFormat,
+		logOutput:          *logOutput,
 		tidyEndpoint:       *tidyEndpoint,
+		tidyZoneEndpoints:  tidyZoneEndpoints,
 		readTimeout:        *readTimeout,
 		writeTimeout:       *writeTimeout,
+		applyTimeout:       *applyTimeout,
+		maxRequestBytes:    *maxRequestBytes,
 		zoneUpdateInterval: zoneUpdateInterval,
+		zoneUpdateJitter:   *zoneUpdateJitter,
+		zoneListTimeout:    *zoneListTimeout,
 		tidyUsername:       tidyUsername,
 		tidyPassword:       tidyPassword,
+		tidyCredentials:    tidyCredentials,
+		zoneGroup:          *zoneGroup,
+		includeInactive:    *includeInactive,
+		enableDebug:        *enableDebug,
+		enablePprof:        *enablePprof,
+		metricsPrefix:      *metricsPrefix,
+		tidyProxy:          tidyProxy,
+		tidyNoProxy:        *tidyNoProxy,
+		tidyUserAgent:      *tidyUserAgent,
+		tidyAuthMode:       *tidyAuthMode,
+		tidyAPIVersion:     *tidyAPIVersion,
+		tidyCreateFields:   tidyCreateFields,
+		tidyRetries:        *tidyRetries,
+		tidyMaxRetryWait:   *tidyMaxRetryWait,
+		tidyConcurrency:    *tidyConcurrency,
+		maxConcurrent:      *maxConcurrent,
+		metricsTimeouts: exposedTimeouts{
+			read:  *metricsReadTimeout,
+			write: *metricsWriteTimeout,
+			idle:  *metricsIdleTimeout,
+		},
+		webhookAddress: *webhookAddress,
+		trustProxy:     *trustProxy,
+		leaderElection: leaderElectionConfig{
+			enabled:       *enableLeaderElection,
+			leaseName:     *leaseName,
+			namespace:     *leaseNamespace,
+			leaseDuration: *leaseDuration,
+			renewDeadline: *renewDeadline,
+		},
+		idnaProfile: *idnaProfile,
+		disableIDNA: *disableIDNA,
+
+		descriptionProperty: descriptionProperty,
+		ownerMarker:         strings.TrimSpace(*ownerMarker),
+		managedRecordTypes:  managedRecordTypes,
+		mergeRecords:        *mergeRecords,
+		preserveTargetOrder: *preserveTargetOrder,
+		rejectEmptyTargets:  *rejectEmptyTargets,
+		rollbackUpdates:     *rollbackUpdates,
+		apexToken:           *apexToken,
+		once:                *once,
+		disableMetrics:      *disableMetrics,
+		metricsExporter:     *metricsExporter,
+		enableTracing:       *enableTracing,
+		deleteLimit: deleteLimit{
+			maxDeletes: *maxDeletes,
+			maxPercent: *maxDeletePercent,
+		},
+		domains: domainScope{
+			include: includeDomains,
+			exclude: excludedDomains,
+		},
+		tidyHealthCheck: tidyHealthCheckConfig{
+			enabled:  *healthCheckTidy,
+			timeout:  *healthCheckTimeout,
+			cacheFor: *healthCheckCache,
+		},
+		ttl: ttlPolicy{
+			minTTL:     *minTTL,
+			zoneMinTTL: zoneMinTTL,
+			maxTTL:     *maxTTL,
+			clampZero:  !*ttlZeroMeansDefault,
+		},
+		zoneDefaults: zoneDefaults{
+			location: zoneLocations,
+			status:   zoneStatuses,
+		},
 	}, nil
 }
+
+// External-DNS passes the annotations with this prefix to the webhook as
+// provider specific properties prefixed webhook/
+const webhookAnnotationPrefix = "external-dns.alpha.kubernetes.io/webhook-"
+
+const defaultDescriptionAnnotation = webhookAnnotationPrefix + "tidydns-description"
+
+// Name of the provider specific property an annotation is passed to the webhook
+// as. Other annotations never reach the webhook. An empty annotation is
+// returned as an empty property.
+func annotationProperty(annotation string) (string, error) {
+	if annotation == "" {
+		return "", nil
+	}
+
+	name, found := strings.CutPrefix(annotation, webhookAnnotationPrefix)
+	if !found || name == "" {
+		return "", fmt.Errorf("annotation %s isn't passed to the webhook by External-DNS, it must start with %s", annotation, webhookAnnotationPrefix)
+	}
+
+	return "webhook/" + name, nil
+}
+
+// Parse form fields formatted as a comma separated list of field=value pairs,
+// e.g. dynamic=1,view_id=2. Values may be empty.
+func parseFormFields(value string) (map[string]string, error) {
+	return parsePairs(value, "form field")
+}
+
+// Reports whether a listen address only accepts connections from the host
+// itself. An address without a host listens on all interfaces.
+func isLoopbackAddress(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"net/url"
 	"os"
+	"os/exec"
+	"strings"
 	"testing"
 	"time"
 )
@@ -26,6 +32,7 @@ func TestParseConfig(t *testing.T) {
 		args           []string
 		envUser        string
 		envPass        string
+		env            map[string]string
 		expectedConfig *config
 		expectError    bool
 	}{
@@ -35,34 +42,441 @@ func TestParseConfig(t *testing.T) {
 			envUser: "testuser",
 			envPass: "testpass",
 			expectedConfig: &config{
-				logLevel:           "info",
-				logFormat:          "text",
-				tidyEndpoint:       "",
-				readTimeout:        5 * time.Second,
-				writeTimeout:       10 * time.Second,
-				zoneUpdateInterval: 10 * time.Minute,
-				tidyUsername:       "testuser",
-				tidyPassword:       "testpass",
+				logLevel:            "info",
+				logFormat:           "text",
+				logOutput:           "stderr",
+				tidyEndpoint:        "",
+				readTimeout:         5 * time.Second,
+				writeTimeout:        10 * time.Second,
+				applyTimeout:        9 * time.Second,
+				maxRequestBytes:     defaultMaxRequestBytes,
+				zoneUpdateInterval:  10 * time.Minute,
+				metricsPrefix:       "tidy_",
+				tidyUserAgent:       "external-dns-tidydns-webhook/" + readBuildInfo().version,
+				tidyAuthMode:        "basic",
+				tidyAPIVersion:      "v1",
+				tidyMaxRetryWait:    30 * time.Second,
+				tidyConcurrency:     4,
+				maxConcurrent:       8,
+				metricsTimeouts:     exposedTimeouts{read: 5 * time.Second, write: 10 * time.Second, idle: time.Minute},
+				ttl:                 ttlPolicy{minTTL: 300, zoneMinTTL: map[string]int{}},
+				zoneDefaults:        zoneDefaults{location: map[string]int{}, status: map[string]json.Number{}},
+				metricsExporter:     "prometheus",
+				apexToken:           "auto",
+				tidyZoneEndpoints:   map[string]string{},
+				webhookAddress:      "127.0.0.1:8888",
+				leaderElection:      leaderElectionConfig{leaseName: "external-dns-tidydns-webhook", leaseDuration: 15 * time.Second, renewDeadline: 10 * time.Second},
+				tidyHealthCheck:     tidyHealthCheckConfig{timeout: 5 * time.Second, cacheFor: 30 * time.Second},
+				idnaProfile:         "lookup",
+				descriptionProperty: "webhook/tidydns-description",
+				mergeRecords:        true,
+				rollbackUpdates:     true,
+				tidyUsername:        "testuser",
+				tidyPassword:        "testpass",
 			},
 			expectError: false,
 		},
 		{
 			name:    "custom values",
-			args:    []string{"cmd", "--log-level=debug", "--log-format=json", "--tidydns-endpoint=http://example.com", "--read-timeout=3s", "--write-timeout=6s", "--zone-update-interval=15m"},
+			args:    []string{"cmd", "--log-level=debug", "--log-format=json", "--log-output=stdout", "--tidydns-endpoint=http://example.com", "--tidydns-zone-endpoints=example.org=https://tidy2.example.com", "--read-timeout=3s", "--write-timeout=6s", "--apply-timeout=5s", "--max-request-bytes=1024", "--zone-update-interval=15m", "--zone-update-jitter=0.1", "--zone-list-timeout=2s", "--tidydns-zone-group=k8s", "--include-inactive-records", "--enable-debug-endpoints", "--enable-pprof", "--metrics-prefix=externaldns_tidydns_", "--tidydns-proxy-url=http://proxy.example.com:3128", "--tidydns-user-agent=webhook/test", "--tidydns-auth-mode=session", "--tidydns-api-version=v1", "--tidydns-create-fields=dynamic=1,view_id=", "--tidydns-rate-limit-retries=3", "--tidydns-max-retry-wait=1m", "--tidydns-concurrency=8", "--max-concurrent-requests=16", "--metrics-read-timeout=1s", "--metrics-write-timeout=2s", "--metrics-idle-timeout=3s", "--min-ttl=60", "--max-ttl=86400", "--ttl-zero-means-default=false", "--zone-min-ttl=example.com=30", "--zone-default-location=example.com=3", "--zone-default-status=Example.org.=disabled", "--webhook-address=0.0.0.0:8888", "--insecure-allow-remote", "--trust-proxy", "--enable-leader-election", "--leader-election-lease-name=webhook", "--leader-election-namespace=dns", "--leader-election-lease-duration=30s", "--health-check-tidy", "--health-check-tidy-timeout=2s", "--health-check-tidy-cache=1m", "--domain-filter=example.com, example.org\n", "--domain-filter=example.net", "--exclude-domain=legacy.example.com", "--idna-profile=registration", "--disable-idna", "--max-deletes=10", "--max-delete-percent=25", "--description-label=external-dns.alpha.kubernetes.io/webhook-description", "--owner-marker=managed-by=external-dns", "--managed-record-types=TXT,A,CNAME", "--merge-records=false", "--preserve-target-order", "--reject-empty-targets", "--rollback-failed-updates=false", "--apex-name-token=@", "--disable-metrics", "--metrics-exporter=otlp", "--once"},
 			envUser: "customuser",
 			envPass: "custompass",
 			expectedConfig: &config{
-				logLevel:           "debug",
-				logFormat:          "json",
-				tidyEndpoint:       "http://example.com",
-				readTimeout:        3 * time.Second,
-				writeTimeout:       6 * time.Second,
-				zoneUpdateInterval: 15 * time.Minute,
-				tidyUsername:       "customuser",
-				tidyPassword:       "custompass",
+				logLevel:            "debug",
+				logFormat:           "json",
+				logOutput:           "stdout",
+				tidyEndpoint:        "http://example.com",
+				readTimeout:         3 * time.Second,
+				writeTimeout:        6 * time.Second,
+				applyTimeout:        5 * time.Second,
+				maxRequestBytes:     1024,
+				zoneUpdateInterval:  15 * time.Minute,
+				zoneUpdateJitter:    0.1,
+				zoneListTimeout:     2 * time.Second,
+				metricsPrefix:       "externaldns_tidydns_",
+				tidyProxy:           &url.URL{Scheme: "http", Host: "proxy.example.com:3128"},
+				tidyUserAgent:       "webhook/test",
+				tidyAuthMode:        "session",
+				tidyAPIVersion:      "v1",
+				tidyCreateFields:    map[string]string{"dynamic": "1", "view_id": ""},
+				tidyRetries:         3,
+				tidyMaxRetryWait:    time.Minute,
+				tidyConcurrency:     8,
+				maxConcurrent:       16,
+				metricsTimeouts:     exposedTimeouts{read: time.Second, write: 2 * time.Second, idle: 3 * time.Second},
+				ttl:                 ttlPolicy{minTTL: 60, zoneMinTTL: map[string]int{"example.com": 30}, maxTTL: 86400, clampZero: true},
+				zoneDefaults:        zoneDefaults{location: map[string]int{"example.com": 3}, status: map[string]json.Number{"example.org": "1"}},
+				tidyZoneEndpoints:   map[string]string{"example.org": "https://tidy2.example.com"},
+				webhookAddress:      "0.0.0.0:8888",
+				trustProxy:          true,
+				leaderElection:      leaderElectionConfig{enabled: true, leaseName: "webhook", namespace: "dns", leaseDuration: 30 * time.Second, renewDeadline: 10 * time.Second},
+				tidyHealthCheck:     tidyHealthCheckConfig{enabled: true, timeout: 2 * time.Second, cacheFor: time.Minute},
+				idnaProfile:         "registration",
+				disableIDNA:         true,
+				deleteLimit:         deleteLimit{maxDeletes: 10, maxPercent: 25},
+				descriptionProperty: "webhook/description",
+				ownerMarker:         "managed-by=external-dns",
+				managedRecordTypes:  []string{"A", "CNAME", "TXT"},
+				preserveTargetOrder: true,
+				rejectEmptyTargets:  true,
+				apexToken:           "@",
+				once:                true,
+				disableMetrics:      true,
+				metricsExporter:     "otlp",
+				domains:             domainScope{include: []string{"example.com", "example.org", "example.net"}, exclude: []string{"legacy.example.com"}},
+				tidyUsername:        "customuser",
+				tidyPassword:        "custompass",
+				zoneGroup:           "k8s",
+				includeInactive:     true,
+				enableDebug:         true,
+				enablePprof:         true,
 			},
 			expectError: false,
 		},
+		{
+			name:    "environment",
+			args:    []string{"cmd", "--log-level=warning"},
+			envUser: "testuser",
+			envPass: "testpass",
+			env: map[string]string{
+				"TIDYDNS_ENDPOINT":                 "http://example.com",
+				"TIDYDNS_ZONE_UPDATE_INTERVAL":     "15m",
+				"TIDYDNS_INCLUDE_INACTIVE_RECORDS": "true",
+				"TIDYDNS_MIN_TTL":                  "60",
+				"TIDYDNS_DOMAIN_FILTER":            "example.com,\nexample.org",
+				"TIDYDNS_LOG_LEVEL":                "debug",
+				"TIDYDNS_VERSION":                  "true",
+				"TIDYDNS_ONCE":                     "true",
+			},
+			expectedConfig: &config{
+				logLevel:            "warning",
+				logFormat:           "text",
+				logOutput:           "stderr",
+				tidyEndpoint:        "http://example.com",
+				readTimeout:         5 * time.Second,
+				writeTimeout:        10 * time.Second,
+				applyTimeout:        9 * time.Second,
+				maxRequestBytes:     defaultMaxRequestBytes,
+				zoneUpdateInterval:  15 * time.Minute,
+				includeInactive:     true,
+				metricsPrefix:       "tidy_",
+				tidyUserAgent:       "external-dns-tidydns-webhook/" + readBuildInfo().version,
+				tidyAuthMode:        "basic",
+				tidyAPIVersion:      "v1",
+				tidyMaxRetryWait:    30 * time.Second,
+				tidyConcurrency:     4,
+				maxConcurrent:       8,
+				metricsTimeouts:     exposedTimeouts{read: 5 * time.Second, write: 10 * time.Second, idle: time.Minute},
+				ttl:                 ttlPolicy{minTTL: 60, zoneMinTTL: map[string]int{}},
+				zoneDefaults:        zoneDefaults{location: map[string]int{}, status: map[string]json.Number{}},
+				metricsExporter:     "prometheus",
+				apexToken:           "auto",
+				tidyZoneEndpoints:   map[string]string{},
+				webhookAddress:      "127.0.0.1:8888",
+				leaderElection:      leaderElectionConfig{leaseName: "external-dns-tidydns-webhook", leaseDuration: 15 * time.Second, renewDeadline: 10 * time.Second},
+				tidyHealthCheck:     tidyHealthCheckConfig{timeout: 5 * time.Second, cacheFor: 30 * time.Second},
+				idnaProfile:         "lookup",
+				descriptionProperty: "webhook/tidydns-description",
+				mergeRecords:        true,
+				rollbackUpdates:     true,
+				domains:             domainScope{include: []string{"example.com", "example.org"}, exclude: []string{}},
+				tidyUsername:        "testuser",
+				tidyPassword:        "testpass",
+			},
+			expectError: false,
+		},
+		{
+			name:           "invalid environment",
+			args:           []string{"cmd"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			env:            map[string]string{"TIDYDNS_READ_TIMEOUT": "soon"},
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "unreadable credentials file",
+			args:           []string{"cmd"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			env:            map[string]string{"TIDYDNS_PASS_FILE": "/nonexistent/password"},
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:    "version",
+			args:    []string{"cmd", "--version", "--log-output=file"},
+			envUser: "testuser",
+			envPass: "testpass",
+			expectedConfig: &config{
+				showVersion: true,
+			},
+			expectError: false,
+		},
+		{
+			name:           "invalid log output",
+			args:           []string{"cmd", "--log-output=file"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid API version",
+			args:           []string{"cmd", "--tidydns-api-version=v2"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid auth mode",
+			args:           []string{"cmd", "--tidydns-auth-mode=token"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "negative retries",
+			args:           []string{"cmd", "--tidydns-rate-limit-retries=-1"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid minimum TTL",
+			args:           []string{"cmd", "--min-ttl=0"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid maximum TTL",
+			args:           []string{"cmd", "--max-ttl=-1"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "maximum TTL under minimum TTL",
+			args:           []string{"cmd", "--max-ttl=60"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "maximum TTL under zone minimum TTL",
+			args:           []string{"cmd", "--min-ttl=60", "--max-ttl=120", "--zone-min-ttl=example.com=300"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid zone minimum TTL",
+			args:           []string{"cmd", "--zone-min-ttl=example.com"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid zone default location",
+			args:           []string{"cmd", "--zone-default-location=example.com=-1"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid zone default status",
+			args:           []string{"cmd", "--zone-default-status=example.com=paused"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid metrics exporter",
+			args:           []string{"cmd", "--metrics-exporter=statsd"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid max request bytes",
+			args:           []string{"cmd", "--max-request-bytes=-1"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid create fields",
+			args:           []string{"cmd", "--tidydns-create-fields=dynamic"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid apply timeout",
+			args:           []string{"cmd", "--apply-timeout=-1s"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid apex name token",
+			args:           []string{"cmd", "--apex-name-token=apex"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "remote webhook address",
+			args:           []string{"cmd", "--webhook-address=:8888"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid lease duration",
+			args:           []string{"cmd", "--enable-leader-election", "--leader-election-namespace=dns", "--leader-election-lease-duration=1ms"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "renew deadline not shorter than the lease duration",
+			args:           []string{"cmd", "--enable-leader-election", "--leader-election-namespace=dns", "--leader-election-renew-deadline=15s"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid metrics prefix",
+			args:           []string{"cmd", "--metrics-prefix=1tidy"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "proxy and no proxy",
+			args:           []string{"cmd", "--tidydns-proxy-url=http://proxy.example.com:3128", "--tidydns-no-proxy"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid proxy",
+			args:           []string{"cmd", "--tidydns-proxy-url=proxy"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid Tidy concurrency",
+			args:           []string{"cmd", "--tidydns-concurrency=0"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid zone endpoint",
+			args:           []string{"cmd", "--tidydns-zone-endpoints=example.org=tidy2.example.com"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid max concurrent requests",
+			args:           []string{"cmd", "--max-concurrent-requests=0"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid Tidy health check timeout",
+			args:           []string{"cmd", "--health-check-tidy", "--health-check-tidy-timeout=0s"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid domain filter",
+			args:           []string{"cmd", "--domain-filter=example.com,https://example.org"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid IDNA profile",
+			args:           []string{"cmd", "--idna-profile=lenient"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid max delete percent",
+			args:           []string{"cmd", "--max-delete-percent=150"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "description label not passed to the webhook",
+			args:           []string{"cmd", "--description-label=example.com/description"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "unsupported managed record type",
+			args:           []string{"cmd", "--managed-record-types=A,PTR"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid zone list timeout",
+			args:           []string{"cmd", "--zone-list-timeout=-1s"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
+		{
+			name:           "invalid zone update jitter",
+			args:           []string{"cmd", "--zone-update-jitter=1.5"},
+			envUser:        "testuser",
+			envPass:        "testpass",
+			expectedConfig: nil,
+			expectError:    true,
+		},
 		{
 			name:           "invalid duration",
 			args:           []string{"cmd", "--zone-update-interval=invalid"},
@@ -81,6 +495,9 @@ func TestParseConfig(t *testing.T) {
 			// Set environment variables
 			os.Setenv("TIDYDNS_USER", tt.envUser)
 			os.Setenv("TIDYDNS_PASS", tt.envPass)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
 
 			// Reset the flag package to avoid conflicts
 			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
@@ -103,14 +520,151 @@ func TestParseConfig(t *testing.T) {
 			// Compare the result with the expected config
 			if cfg.logLevel != tt.expectedConfig.logLevel ||
 				cfg.logFormat != tt.expectedConfig.logFormat ||
+				cfg.logOutput != tt.expectedConfig.logOutput ||
 				cfg.tidyEndpoint != tt.expectedConfig.tidyEndpoint ||
 				cfg.readTimeout != tt.expectedConfig.readTimeout ||
 				cfg.writeTimeout != tt.expectedConfig.writeTimeout ||
+				cfg.applyTimeout != tt.expectedConfig.applyTimeout ||
+				cfg.maxRequestBytes != tt.expectedConfig.maxRequestBytes ||
 				cfg.zoneUpdateInterval != tt.expectedConfig.zoneUpdateInterval ||
+				cfg.zoneUpdateJitter != tt.expectedConfig.zoneUpdateJitter ||
+				cfg.zoneListTimeout != tt.expectedConfig.zoneListTimeout ||
 				cfg.tidyUsername != tt.expectedConfig.tidyUsername ||
-				cfg.tidyPassword != tt.expectedConfig.tidyPassword {
+				cfg.tidyPassword != tt.expectedConfig.tidyPassword ||
+				cfg.zoneGroup != tt.expectedConfig.zoneGroup ||
+				cfg.includeInactive != tt.expectedConfig.includeInactive ||
+				cfg.enableDebug != tt.expectedConfig.enableDebug ||
+				cfg.enablePprof != tt.expectedConfig.enablePprof ||
+				cfg.metricsPrefix != tt.expectedConfig.metricsPrefix ||
+				fmt.Sprint(cfg.tidyProxy) != fmt.Sprint(tt.expectedConfig.tidyProxy) ||
+				cfg.tidyNoProxy != tt.expectedConfig.tidyNoProxy ||
+				cfg.showVersion != tt.expectedConfig.showVersion ||
+				cfg.tidyUserAgent != tt.expectedConfig.tidyUserAgent ||
+				cfg.tidyAuthMode != tt.expectedConfig.tidyAuthMode ||
+				cfg.tidyAPIVersion != tt.expectedConfig.tidyAPIVersion ||
+				fmt.Sprint(cfg.tidyCreateFields) != fmt.Sprint(tt.expectedConfig.tidyCreateFields) ||
+				cfg.tidyRetries != tt.expectedConfig.tidyRetries ||
+				cfg.tidyMaxRetryWait != tt.expectedConfig.tidyMaxRetryWait ||
+				cfg.tidyConcurrency != tt.expectedConfig.tidyConcurrency ||
+				cfg.maxConcurrent != tt.expectedConfig.maxConcurrent ||
+				cfg.metricsTimeouts != tt.expectedConfig.metricsTimeouts ||
+				fmt.Sprint(cfg.ttl) != fmt.Sprint(tt.expectedConfig.ttl) ||
+				fmt.Sprint(cfg.zoneDefaults) != fmt.Sprint(tt.expectedConfig.zoneDefaults) ||
+				fmt.Sprint(cfg.tidyZoneEndpoints) != fmt.Sprint(tt.expectedConfig.tidyZoneEndpoints) ||
+				cfg.webhookAddress != tt.expectedConfig.webhookAddress ||
+				cfg.trustProxy != tt.expectedConfig.trustProxy ||
+				cfg.leaderElection != tt.expectedConfig.leaderElection ||
+				cfg.tidyHealthCheck != tt.expectedConfig.tidyHealthCheck ||
+				fmt.Sprint(cfg.domains) != fmt.Sprint(tt.expectedConfig.domains) ||
+				cfg.idnaProfile != tt.expectedConfig.idnaProfile ||
+				cfg.disableIDNA != tt.expectedConfig.disableIDNA ||
+				cfg.deleteLimit != tt.expectedConfig.deleteLimit ||
+				cfg.descriptionProperty != tt.expectedConfig.descriptionProperty ||
+				cfg.ownerMarker != tt.expectedConfig.ownerMarker ||
+				fmt.Sprint(cfg.managedRecordTypes) != fmt.Sprint(tt.expectedConfig.managedRecordTypes) ||
+				cfg.mergeRecords != tt.expectedConfig.mergeRecords ||
+				cfg.preserveTargetOrder != tt.expectedConfig.preserveTargetOrder ||
+				cfg.rejectEmptyTargets != tt.expectedConfig.rejectEmptyTargets ||
+				cfg.rollbackUpdates != tt.expectedConfig.rollbackUpdates ||
+				cfg.apexToken != tt.expectedConfig.apexToken ||
+				cfg.once != tt.expectedConfig.once ||
+				cfg.disableMetrics != tt.expectedConfig.disableMetrics ||
+				cfg.metricsExporter != tt.expectedConfig.metricsExporter {
 				t.Errorf("expected config %+v, but got %+v", tt.expectedConfig, cfg)
 			}
+
+			// The effective configuration holds the credentials redacted
+			if !cfg.showVersion && (cfg.effective["tidydns-user"] != tt.envUser || cfg.effective["tidydns-pass"] != redacted) {
+				t.Errorf("expected the user %s and a redacted password, got %s and %s", tt.envUser, cfg.effective["tidydns-user"], cfg.effective["tidydns-pass"])
+			}
+		})
+	}
+}
+
+func TestAnnotationProperty(t *testing.T) {
+	tests := []struct {
+		annotation  string
+		expected    string
+		expectError bool
+	}{
+		{"external-dns.alpha.kubernetes.io/webhook-tidydns-description", "webhook/tidydns-description", false},
+		{"", "", false},
+		{"external-dns.alpha.kubernetes.io/tidydns-description", "", true},
+		{"external-dns.alpha.kubernetes.io/webhook-", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.annotation, func(t *testing.T) {
+			property, err := annotationProperty(test.annotation)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error %v, got %v", test.expectError, err)
+			}
+
+			if property != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, property)
+			}
+		})
+	}
+}
+
+func TestIsLoopbackAddress(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected bool
+	}{
+		{"127.0.0.1:8888", true},
+		{"localhost:8888", true},
+		{"[::1]:8888", true},
+		{":8888", false},
+		{"0.0.0.0:8888", false},
+		{"10.0.0.1:8888", false},
+		{"127.0.0.1", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.addr, func(t *testing.T) {
+			if actual := isLoopbackAddress(test.addr); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMainConfigError(t *testing.T) {
+	// Run as the webhook in the child process started below, as main exits
+	if args := os.Getenv("WEBHOOK_TEST_MAIN_ARGS"); args != "" {
+		os.Args = append([]string{"webhook"}, strings.Split(args, "\n")...)
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		main()
+		return
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--log-output=file"}, "invalid log output file"},
+		{[]string{"--max-deletes=-1"}, "invalid max deletes -1"},
+		{[]string{"--webhook-address=0.0.0.0:8888"}, "isn't a loopback address"},
+	}
+
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestMainConfigError$")
+			cmd.Env = append(os.Environ(), "WEBHOOK_TEST_MAIN_ARGS="+strings.Join(test.args, "\n"))
+			stderr := &strings.Builder{}
+			cmd.Stderr = stderr
+
+			err := cmd.Run()
+
+			exitErr := &exec.ExitError{}
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != 2 {
+				t.Fatalf("expected exit code 2, got %v", err)
+			}
+
+			if !strings.Contains(stderr.String(), test.expected) {
+				t.Errorf("expected %q logged, got %q", test.expected, stderr.String())
+			}
 		})
 	}
 }
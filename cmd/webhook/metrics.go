@@ -0,0 +1,65 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	otel "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/metric"
+)
+
+// How metrics are exported
+const (
+	// Served on /metrics for Prometheus to scrape
+	metricsExporterPrometheus = "prometheus"
+	// Pushed to an OTLP collector over HTTP, configured by the standard
+	// OTEL_EXPORTER_OTLP_* environment variables
+	metricsExporterOTLP = "otlp"
+)
+
+var metricsExporters = []string{metricsExporterPrometheus, metricsExporterOTLP}
+
+// Make the meter provider exporting metrics the given way, and the handler
+// serving them on /metrics. The handler is nil when metrics are pushed.
+func newMeterProvider(exporter string) (otel.MeterProvider, http.Handler, error) {
+	switch exporter {
+	case metricsExporterPrometheus:
+		// Create a Prometheus reader/exporter
+		prom, err := prometheus.New(prometheus.WithoutScopeInfo())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return metric.NewMeterProvider(metric.WithReader(prom)), promhttp.Handler(), nil
+	case metricsExporterOTLP:
+		// The endpoint and headers are taken from the environment
+		otlp, err := otlpmetrichttp.New(context.Background())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return metric.NewMeterProvider(metric.WithReader(metric.NewPeriodicReader(otlp))), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown metrics exporter %s", exporter)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestNewMeterProvider(t *testing.T) {
+	tests := []struct {
+		name          string
+		exporter      string
+		expectHandler bool
+		expectError   bool
+	}{
+		{"Prometheus", metricsExporterPrometheus, true, false},
+		{"OTLP", metricsExporterOTLP, false, false},
+		{"Unknown", "statsd", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider, handler, err := newMeterProvider(test.exporter)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected an error, got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if provider == nil {
+				t.Errorf("expected a meter provider")
+			}
+
+			if (handler != nil) != test.expectHandler {
+				t.Errorf("expected handler %v, got %v", test.expectHandler, handler)
+			}
+		})
+	}
+}
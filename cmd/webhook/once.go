@@ -0,0 +1,50 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// List the zones and their records in Tidy once and write a summary to out.
+// The zones are selected like the provider does, so they're the ones the
+// webhook would manage. It's used to validate credentials and connectivity,
+// e.g. in a CI job, so any failure is returned rather than retried.
+func checkConnectivity(ctx context.Context, tidy tidyBackends, zoneGroup string, out io.Writer) error {
+	zones, err := tidy.ListZones(ctx)
+	if err != nil {
+		return fmt.Errorf("listing zones: %w", err)
+	}
+
+	zones = forwardZones(selectZones(zones, zoneGroup))
+
+	total := 0
+	for _, zone := range zones {
+		records, err := tidy.forZone(zone.Name).ListRecords(ctx, zone.ID)
+		if err != nil {
+			return fmt.Errorf("listing records of zone %s: %w", zone.Name, err)
+		}
+
+		fmt.Fprintf(out, "zone %s (id %s): %d records\n", zone.Name, zone.ID, len(records))
+		total += len(records)
+	}
+
+	fmt.Fprintf(out, "%d zones, %d records\n", len(zones), total)
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+)
+
+func TestCheckConnectivity(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		zones: []tidydns.Zone{
+			{ID: "1", Name: "example.com", Group: "k8s"},
+			{ID: "2", Name: "example.org"},
+			{ID: "3", Name: "example.org"},
+			{ID: "4", Name: "0.10.in-addr.arpa"},
+		},
+		createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "www", Destination: "10.0.0.1"},
+			{ID: "2", Type: "A", Name: "api", Destination: "10.0.0.2"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		zoneGroup string
+		expected  string
+	}{
+		{"All zones", "", "zone example.com (id 1): 2 records\nzone example.org (id 2): 2 records\n2 zones, 4 records\n"},
+		{"Zone group", "k8s", "zone example.com (id 1): 2 records\n1 zones, 2 records\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &strings.Builder{}
+			if err := checkConnectivity(context.Background(), tidyBackends{fallback: tidy}, test.zoneGroup, out); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if out.String() != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestCheckConnectivityError(t *testing.T) {
+	tidyErr := errors.New("401 Unauthorized")
+	tidy := &mockTidyDNSClient{err: tidyErr}
+
+	if err := checkConnectivity(context.Background(), tidyBackends{fallback: tidy}, "", &strings.Builder{}); !errors.Is(err, tidyErr) {
+		t.Errorf("expected %v, got %v", tidyErr, err)
+	}
+}
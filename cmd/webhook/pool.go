@@ -0,0 +1,75 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	otel "go.opentelemetry.io/otel/metric"
+)
+
+// Pool running up to a fixed number of workers at a time. The number of active
+// workers and how often a worker had to wait for the pool are measured, when
+// the instruments are set, to tell whether the pool is large enough.
+type workerPool struct {
+	sem       chan struct{}
+	wg        sync.WaitGroup
+	active    otel.Int64UpDownCounter
+	saturated otel.Int64Counter
+}
+
+func newWorkerPool(size int, active otel.Int64UpDownCounter, saturated otel.Int64Counter) *workerPool {
+	return &workerPool{
+		sem:       make(chan struct{}, max(size, 1)),
+		active:    active,
+		saturated: saturated,
+	}
+}
+
+// Run work in a new worker, waiting for one to finish first when the pool is
+// full
+func (p *workerPool) run(work func()) {
+	select {
+	case p.sem <- struct{}{}:
+	default:
+		if p.saturated != nil {
+			p.saturated.Add(context.Background(), 1)
+		}
+
+		p.sem <- struct{}{}
+	}
+
+	p.wg.Add(1)
+	p.addActive(1)
+
+	go func() {
+		defer func() { p.addActive(-1); <-p.sem; p.wg.Done() }()
+		work()
+	}()
+}
+
+// Wait for all workers to finish
+func (p *workerPool) wait() {
+	p.wg.Wait()
+}
+
+func (p *workerPool) addActive(delta int64) {
+	if p.active != nil {
+		p.active.Add(context.Background(), delta)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestWorkerPool(t *testing.T) {
+	reader := metric.NewManualReader()
+	meter := metric.NewMeterProvider(metric.WithReader(reader)).Meter("test")
+
+	active, err := meter.Int64UpDownCounter("tidy_apply_workers_active")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	saturated, err := meter.Int64Counter("tidy_apply_workers_saturated")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	pool := newWorkerPool(2, active, saturated)
+
+	// Two workers fill the pool until released
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	for range 2 {
+		pool.run(func() {
+			started <- struct{}{}
+			<-release
+		})
+	}
+
+	<-started
+	<-started
+
+	// A third worker has to wait for one of the others to be released
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	ran := atomic.Bool{}
+	pool.run(func() { ran.Store(true) })
+	pool.wait()
+
+	if !ran.Load() {
+		t.Errorf("expected the third worker to run")
+	}
+
+	data := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	values := map[string]int64{}
+	for _, instrument := range data.ScopeMetrics[0].Metrics {
+		values[instrument.Name] = instrument.Data.(metricdata.Sum[int64]).DataPoints[0].Value
+	}
+
+	if values["tidy_apply_workers_active"] != 0 {
+		t.Errorf("expected no active workers, got %d", values["tidy_apply_workers_active"])
+	}
+
+	if values["tidy_apply_workers_saturated"] != 1 {
+		t.Errorf("expected the pool to be full once, got %d", values["tidy_apply_workers_saturated"])
+	}
+}
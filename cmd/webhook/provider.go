@@ -19,74 +19,430 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	otel "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
 	"golang.org/x/net/idna"
 	"sigs.k8s.io/external-dns/endpoint"
 	"sigs.k8s.io/external-dns/plan"
 	"sigs.k8s.io/external-dns/provider"
 )
 
+// Provider specific property controlling the status of created records. It's
+// set through the annotation
+// external-dns.alpha.kubernetes.io/webhook-tidydns-status. When the value is
+// "disabled" records are created inactive in Tidy, awaiting manual activation.
+const statusProperty = "webhook/tidydns-status"
+const statusDisabled = "disabled"
+
+// Provider specific property with the priority of MX and SRV records, set
+// through the annotation external-dns.alpha.kubernetes.io/webhook-tidydns-priority.
+// It applies to the targets which don't include a priority themselves.
+const priorityProperty = "webhook/tidydns-priority"
+
+// Returned when an endpoint doesn't belong to any of the zones in Tidy
+var errNoZone = errors.New("no managed zone")
+
+// Returned for changes with endpoints without targets, when they're rejected
+var errNoTargets = errors.New("endpoint without targets")
+
+// Kinds of changes to records, as reported in changeErrors
+const changeCreate = "create"
+const changeUpdate = "update"
+const changeDelete = "delete"
+
+// Returned for a change to the records of an endpoint which failed, telling
+// which endpoint it was
+type changeError struct {
+	change     string
+	dnsName    string
+	recordType string
+	err        error
+}
+
+func (e *changeError) Error() string {
+	return fmt.Sprintf("%s %s %s: %s", e.change, e.recordType, e.dnsName, e.err)
+}
+
+func (e *changeError) Unwrap() error {
+	return e.err
+}
+
+// The error of a change to an endpoint, or nil if it didn't fail
+func changeFailed(change string, endpoint *Endpoint, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &changeError{change: change, dnsName: endpoint.DNSName, recordType: endpoint.RecordType, err: err}
+}
+
+// Returned when creating a CNAME record at the apex of a zone, where it isn't
+// allowed. Tidy has no ALIAS or ANAME records to use instead.
+var errApexCNAME = errors.New("CNAME records can't be created at the apex of a zone, and Tidy doesn't support ALIAS records")
+
+// Profiles names can be punycode encoded with. Lookup follows the recommended
+// profile of x/net for looking up names, which may change over time, where
+// nontransitional pins the current behaviour. Registration is the strictest.
+var idnaProfiles = map[string]*idna.Profile{
+	"lookup":          idna.Lookup,
+	"registration":    idna.Registration,
+	"nontransitional": idna.New(idna.MapForLookup(), idna.Transitional(false), idna.BidiRule()),
+}
+
+// Reasons endpoints from External-DNS are dropped, as counted by the metric
+// endpoints_dropped
+const droppedUnsupportedType = "unsupported_type"
+const droppedNoZone = "no_zone"
+const droppedUnmanagedType = "unmanaged_type"
+
+// Reasons records read from Tidy are skipped, as counted by the metric
+// records_parse_errors
+const parseErrorInvalidTTL = "invalid_ttl"
+
+// Returned when a record read from Tidy can't be parsed into an endpoint,
+// telling why so it can be counted
+type recordParseError struct {
+	reason string
+	err    error
+}
+
+func (e *recordParseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *recordParseError) Unwrap() error {
+	return e.err
+}
+
 type tidyProvider struct {
-	tidy         tidydns.TidyDNSClient
-	zoneProvider ZoneProvider
+	tidy            tidydns.TidyDNSClient
+	zoneClients     map[string]tidydns.TidyDNSClient
+	zoneProvider    ZoneProvider
+	includeInactive bool
+
+	// Time listing the zones in Tidy may take, unless it's zero
+	zoneListTimeout time.Duration
+
+	ttl          ttlPolicy
+	zoneDefaults zoneDefaults
+	concurrency  int
+	applyWorkers int
+	domains      domainScope
+	dropped      otel.Int64Counter
+	parseErrors  otel.Int64Counter
+
+	// Active workers applying changes and how often all of them were busy
+	workersActive    otel.Int64UpDownCounter
+	workersSaturated otel.Int64Counter
+
+	// Spans of the provider operations, unless it's nil
+	tracer trace.Tracer
+
+	// Endpoints in changes without targets, which are rejected when set
+	emptyEndpoints     otel.Int64Counter
+	rejectEmptyTargets bool
+
+	// Recreate the records replaced by an update when creating their
+	// replacements fails
+	rollbackUpdates bool
+
+	idnaProfile *idna.Profile
+	disableIDNA bool
+	deleteLimit deleteLimit
+
+	// Provider specific property with the description of created records,
+	// unless it's empty
+	descriptionProperty string
+
+	// Marker in the description of the records managed by the webhook, unless
+	// it's empty. Records without it are neither reported nor changed.
+	ownerMarker string
+
+	// Record types managed by the webhook, all supported types when empty
+	managedTypes []string
+
+	// Report each record as its own endpoint rather than merging the records
+	// with the same name and type into one endpoint
+	splitRecords bool
+
+	// Keep the targets in the order of the record IDs in Tidy instead of
+	// sorting them
+	preserveTargetOrder bool
+
+	// The records of the endpoints last reported by Records
+	reported reportedRecords
+
+	// How Tidy names the apex of a zone, which differs between Tidy versions.
+	// Unless configured it's the name last seen in the records read.
+	apexToken    string
+	apexTokenSet bool
+	seenApex     atomic.Value
 }
 
 type Provider = provider.Provider
 type Endpoint = endpoint.Endpoint
 type tidyRecord = tidydns.Record
 
-func newProvider(tidy tidydns.TidyDNSClient, zoneUpdateInterval time.Duration) *tidyProvider {
+// How the provider is configured, see tidyProvider for the meaning of the
+// fields shared with it
+type providerConfig struct {
+	// How often the zones are listed in Tidy, spread by up to the jitter as a
+	// fraction of the interval, and how long listing them may take
+	zoneUpdateInterval time.Duration
+	zoneUpdateJitter   float64
+	zoneListTimeout    time.Duration
+
+	// Only zones of this group are used, unless it's empty
+	zoneGroup string
+
+	includeInactive bool
+	ttl             ttlPolicy
+	zoneDefaults    zoneDefaults
+	concurrency     int
+	applyWorkers    int
+	domains         domainScope
+
+	idnaProfile *idna.Profile
+	disableIDNA bool
+	deleteLimit deleteLimit
+
+	descriptionProperty string
+	ownerMarker         string
+	managedTypes        []string
+	splitRecords        bool
+	preserveTargetOrder bool
+	apexToken           string
+	rejectEmptyTargets  bool
+	rollbackUpdates     bool
+
+	// Prefix of the names of the metrics of the provider
+	metricsPrefix string
+}
+
+func newProvider(tidy tidydns.TidyDNSClient, zoneClients map[string]tidydns.TidyDNSClient, cfg providerConfig, tracer trace.Tracer, meter otel.Meter) (*tidyProvider, error) {
+	metricsPrefix := cfg.metricsPrefix
+
+	description := otel.WithDescription("Endpoints from External-DNS dropped as they can't be created in Tidy")
+	dropped, err := meter.Int64Counter((metricsPrefix + "endpoints_dropped"), description)
+	if err != nil {
+		return nil, err
+	}
+
+	parseErrors, err := meter.Int64Counter((metricsPrefix + "records_parse_errors"), otel.WithDescription("Records in Tidy skipped as they can't be parsed"))
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := meter.Int64UpDownCounter((metricsPrefix + "apply_workers_active"), otel.WithDescription("Workers creating and deleting records in Tidy"))
+	if err != nil {
+		return nil, err
+	}
+
+	saturated, err := meter.Int64Counter((metricsPrefix + "apply_workers_saturated"), otel.WithDescription("Times a worker waited for a free worker while applying changes"))
+	if err != nil {
+		return nil, err
+	}
+
+	empty, err := meter.Int64Counter((metricsPrefix + "endpoints_without_targets"), otel.WithDescription("Endpoints without targets in changes from External-DNS"))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &tidyProvider{
+		tidy:            tidy,
+		zoneClients:     zoneClients,
+		includeInactive: cfg.includeInactive,
+		zoneListTimeout: cfg.zoneListTimeout,
+		ttl:             cfg.ttl,
+		zoneDefaults:    cfg.zoneDefaults,
+		concurrency:     cfg.concurrency,
+		applyWorkers:    cfg.applyWorkers,
+		domains:         cfg.domains,
+		dropped:         dropped,
+		parseErrors:     parseErrors,
+
+		workersActive:    active,
+		workersSaturated: saturated,
+
+		tracer: tracer,
+
+		emptyEndpoints:     empty,
+		rejectEmptyTargets: cfg.rejectEmptyTargets,
+
+		rollbackUpdates: cfg.rollbackUpdates,
+
+		idnaProfile: cfg.idnaProfile,
+		disableIDNA: cfg.disableIDNA,
+		deleteLimit: cfg.deleteLimit,
+
+		descriptionProperty: cfg.descriptionProperty,
+		ownerMarker:         cfg.ownerMarker,
+		managedTypes:        cfg.managedTypes,
+		splitRecords:        cfg.splitRecords,
+		preserveTargetOrder: cfg.preserveTargetOrder,
+
+		apexToken:    cfg.apexToken,
+		apexTokenSet: cfg.apexToken != apexTokenAuto,
+	}
+
 	// Make zoneprovider to fetch the zone information with at the set interval
-	zoneProvider := newZoneProvider(tidy, zoneUpdateInterval)
+	provider.zoneProvider = newZoneProvider(provider.backends(), cfg.zoneUpdateInterval, cfg.zoneUpdateJitter, cfg.zoneGroup, provider.zonesChanged)
+
+	return provider, nil
+}
 
-	return &tidyProvider{
-		tidy:         tidy,
-		zoneProvider: zoneProvider,
+// The Tidy servers of the zones. Zones without a server of their own are served
+// by p.tidy.
+func (p *tidyProvider) backends() tidyBackends {
+	return tidyBackends{fallback: p.tidy, zones: p.zoneClients, listTimeout: p.zoneListTimeout}
+}
+
+// Tidy clients keeping the records of zones, which are forgotten when the zones
+// are removed from Tidy
+type zoneForgetter interface {
+	ForgetZone(zoneID json.Number)
+}
+
+// Observe changes to the zones in Tidy. The domain filter is made from the
+// cached zones on every call, so it follows the change without anything being
+// refreshed here. State derived from the zones and kept across requests must
+// be invalidated here.
+func (p *tidyProvider) zonesChanged(added, removed []tidydns.Zone) {
+	zoneNames := func(zones []tidydns.Zone) []string {
+		names := []string{}
+		for _, zone := range zones {
+			names = append(names, zone.Name)
+		}
+
+		return names
+	}
+
+	slog.Info("zones changed in Tidy", "added", zoneNames(added), "removed", zoneNames(removed))
+
+	// The records of removed zones are never listed again, so they would be
+	// kept by the clients forever
+	for _, zone := range removed {
+		if client, ok := p.backends().forZone(zone.Name).(zoneForgetter); ok {
+			client.ForgetZone(zone.ID)
+		}
 	}
 }
 
-// Get list of zones from Tidy and return a domain filter based on them.
+// Get list of zones from Tidy and return a domain filter based on them. Reverse
+// zones are left out as PTR records aren't supported. Zone names are punycode
+// encoded like the endpoint names in AdjustEndpoints, so they compare equal,
+// and each name is only reported once.
 func (p *tidyProvider) GetDomainFilter() endpoint.DomainFilterInterface {
 	// Make list of all zone names
 	zoneNames := []string{}
 	for _, zone := range p.zoneProvider.getZones() {
-		zoneNames = append(zoneNames, zone.Name)
+		if zone.IsReverse() {
+			continue
+		}
+
+		zoneName, err := p.toASCII(zone.Name)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("cannot punycode encode zone %s: %s", zone.Name, err))
+			zoneName = zone.Name
+		}
+
+		if !slices.Contains(zoneNames, zoneName) {
+			zoneNames = append(zoneNames, zoneName)
+		}
 	}
 
-	// Make domain filter
-	return endpoint.NewDomainFilter(zoneNames)
+	// Make domain filter, narrowed to the domains the webhook is told to manage
+	return p.domains.filter(zoneNames)
 }
 
 // Return a list of all DNS records in Tidy. An endpoint in External-DNS can
 // have multiple targets (called distination in Tidy). Tidy does not support
 // this so multiple records are instead created when this is necessary. This
 // function attempts to merge these together when reporting back to
-// External-DNS.
-func (p *tidyProvider) Records(ctx context.Context) ([]*Endpoint, error) {
-	allRecords, err := p.allRecords()
+// External-DNS, unless the provider is configured to report each record.
+// Inactive records aren't served by Tidy and are left out unless the provider
+// is configured to include them.
+func (p *tidyProvider) Records(ctx context.Context) (_ []*Endpoint, err error) {
+	ctx, span := p.startSpan(ctx, "Records")
+	defer func() { endSpan(span, err) }()
+
+	allRecords, err := p.allRecords(ctx)
 	if err != nil {
 		slog.Error(err.Error())
 		return nil, err
 	}
 
+	// Records are merged in the order they were created in Tidy, when the
+	// targets are to be kept in that order
+	if p.preserveTargetOrder {
+		slices.SortStableFunc(allRecords, compareRecordIDs)
+	}
+
 	endpoints := []*Endpoint{}
+	reported := map[string][]recordRef{}
+	descriptions := map[string]string{}
 
 	for _, record := range allRecords {
-		endpoint := parseTidyRecord(&record)
-		if endpoint == nil {
+		// Records with the default status of their zone are as requested, even
+		// when the zone defaults to disabled records
+		defaultStatus := record.Status == p.zoneDefaults.statusOf(record.ZoneName)
+		if !p.includeInactive && !record.IsActive() && !defaultStatus {
+			slog.Debug(fmt.Sprintf("skip inactive record %s", record.ID))
 			continue
 		}
 
+		// Records of types managed elsewhere aren't reported, so External-DNS
+		// leaves them alone
+		if !p.managesType(recordType(&record)) {
+			continue
+		}
+
+		// Records which can't be parsed are skipped, which External-DNS can't
+		// tell from the records missing, so they're logged and counted
+		endpoint, err := parseTidyRecord(&record)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("skipping record %s %s in zone %s: %s", record.ID, record.Name, record.ZoneName, err))
+			p.countParseError(ctx, err)
+			continue
+		}
+
+		if defaultStatus {
+			endpoint.DeleteProviderSpecificProperty(statusProperty)
+		}
+
+		key := reportedKey(endpoint.DNSName, endpoint.RecordType, endpoint.Targets[0])
+		reported[key] = append(reported[key], recordRef{record.ZoneID, record.ID})
+
+		// The description is reported without the owner marker, the way it's
+		// given to External-DNS, so it's only updated when it's changed
+		if description := withoutOwnerMarker(record.Description, p.ownerMarker); description != "" && p.descriptionProperty != "" {
+			endpoint.SetProviderSpecificProperty(p.descriptionProperty, description)
+
+			key := descriptionKey(endpoint.DNSName, endpoint.RecordType)
+			if _, ok := descriptions[key]; !ok {
+				descriptions[key] = description
+			}
+		}
+
+		// Records are merged with an earlier endpoint of the same name and type
+		// unless configured otherwise
 		index := -1
 		for i := range endpoints {
-			if endpoints[i].DNSName == endpoint.DNSName && endpoints[i].RecordType == endpoint.RecordType {
+			if !p.splitRecords && endpoints[i].DNSName == endpoint.DNSName && endpoints[i].RecordType == endpoint.RecordType {
 				index = i
 			}
 		}
@@ -99,6 +455,17 @@ func (p *tidyProvider) Records(ctx context.Context) ([]*Endpoint, error) {
 		}
 	}
 
+	// Tidy returns records in no particular order, so the targets are sorted
+	// for the same records to always be reported the same way, unless they're
+	// already in the order of the record IDs
+	if !p.preserveTargetOrder {
+		for _, endpoint := range endpoints {
+			slices.Sort(endpoint.Targets)
+		}
+	}
+
+	p.reported.set(reported, descriptions)
+
 	return endpoints, nil
 }
 
@@ -108,18 +475,150 @@ func (p *tidyProvider) Records(ctx context.Context) ([]*Endpoint, error) {
 // things like the TTL restrictions, labels not being supported and unicode
 // being punycode encoded is applied in this function.
 func (p *tidyProvider) AdjustEndpoints(endpoints []*Endpoint) ([]*Endpoint, error) {
+	adjusted := []*Endpoint{}
+	supportedTypes := tidydns.SupportedRecordTypes()
+
+	// Zones are looked up for every endpoint, so they are indexed by name once
+	zones := newZoneIndex(p.zoneProvider.getZones())
+
 	for _, v := range endpoints {
-		// Restrict TTL to permitted range by Tidy DNS
-		v.RecordTTL = endpoint.TTL(clampTTL(int(v.RecordTTL)))
+		// Endpoints which can never be created in Tidy are dropped, so
+		// External-DNS doesn't keep proposing them
+		if !slices.Contains(supportedTypes, v.RecordType) {
+			slog.Warn(fmt.Sprintf("dropping %s, record type %s isn't supported", v.DNSName, v.RecordType))
+			p.countDropped(context.Background(), droppedUnsupportedType)
+			continue
+		}
+
+		if !p.managesType(v.RecordType) {
+			slog.Debug(fmt.Sprintf("dropping %s, record type %s isn't managed", v.DNSName, v.RecordType))
+			p.countDropped(context.Background(), droppedUnmanagedType)
+			continue
+		}
+
+		adjusted = append(adjusted, v)
+
+		// Any unicode is encoded as punycode. Names which can't be encoded are
+		// left unchanged as the result of a failed conversion may be empty.
+		if dnsName, err := p.toASCII(v.DNSName); err != nil {
+			slog.Warn(fmt.Sprintf("cannot punycode encode %s: %s", v.DNSName, err))
+		} else {
+			v.DNSName = dnsName
+		}
+
+		// Restrict TTL to permitted range by Tidy DNS and the zone
+		zone, _ := zones.find(v.DNSName)
+		v.RecordTTL = endpoint.TTL(p.ttl.clamp(zone.Name, int(v.RecordTTL)))
+
+		// Records read from Tidy with the default status of their zone have no
+		// status property, so the property is removed when it's the default
+		if status, ok := v.GetProviderSpecificProperty(statusProperty); ok && status == statusDisabled && p.zoneDefaults.statusOf(zone.Name) == tidydns.RecordStatusDisabled {
+			v.DeleteProviderSpecificProperty(statusProperty)
+		}
 
 		// Labels are not supported hence removed
 		v.Labels = endpoint.Labels{}
 
-		// Any unicode is encoded as punycode
-		v.DNSName, _ = idna.Lookup.ToASCII(v.DNSName)
+		// Endpoints without a description keep the description of their
+		// records, as the description is reported with the records
+		if p.descriptionProperty != "" {
+			description, _ := v.GetProviderSpecificProperty(p.descriptionProperty)
+			if description == "" {
+				description = p.reported.description(v)
+			}
+
+			if description = withoutOwnerMarker(description, p.ownerMarker); description != "" {
+				v.SetProviderSpecificProperty(p.descriptionProperty, description)
+			} else {
+				v.DeleteProviderSpecificProperty(p.descriptionProperty)
+			}
+		}
+
+		// Records read from Tidy carry the priority in their targets, so the
+		// priority property is moved there for External-DNS to see no change
+		if priority, ok := v.GetProviderSpecificProperty(priorityProperty); ok {
+			for i, target := range v.Targets {
+				v.Targets[i] = withPriority(v.RecordType, target, priority)
+			}
+
+			v.DeleteProviderSpecificProperty(priorityProperty)
+		}
+
+		// Sorted like the targets of the records read from Tidy. Targets kept
+		// in order are created in the order given by External-DNS.
+		if !p.preserveTargetOrder {
+			slices.Sort(v.Targets)
+		}
 	}
 
-	return endpoints, nil
+	return adjusted, nil
+}
+
+// Punycode encode a name with the IDNA profile of the provider, which is the
+// lookup profile unless configured otherwise. With IDNA disabled names are
+// returned as they are.
+func (p *tidyProvider) toASCII(name string) (string, error) {
+	if p.disableIDNA {
+		return name, nil
+	}
+
+	profile := p.idnaProfile
+	if profile == nil {
+		profile = idna.Lookup
+	}
+
+	return profile.ToASCII(name)
+}
+
+// Reports whether records of a type are managed by the webhook
+func (p *tidyProvider) managesType(recordType string) bool {
+	return len(p.managedTypes) == 0 || slices.Contains(p.managedTypes, recordType)
+}
+
+// Count an endpoint dropped for the given reason. Providers made without
+// newProvider have no counter.
+func (p *tidyProvider) countDropped(ctx context.Context, reason string) {
+	if p.dropped == nil {
+		return
+	}
+
+	p.dropped.Add(ctx, 1, otel.WithAttributes(attribute.Key("reason").String(reason)))
+}
+
+// Count a record skipped as it can't be parsed. Providers made without
+// newProvider have no counter.
+func (p *tidyProvider) countParseError(ctx context.Context, err error) {
+	if p.parseErrors == nil {
+		return
+	}
+
+	parseErr := &recordParseError{}
+	if !errors.As(err, &parseErr) {
+		return
+	}
+
+	p.parseErrors.Add(ctx, 1, otel.WithAttributes(attribute.Key("reason").String(parseErr.reason)))
+}
+
+// Start a span of a provider operation. Providers made without newProvider
+// have no tracer.
+func (p *tidyProvider) startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := p.tracer
+	if tracer == nil {
+		tracer = tracenoop.NewTracerProvider().Tracer("")
+	}
+
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End a span, marking it failed when err isn't nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
 }
 
 // Create, delete or change records. We use a list of zones since External-DNS
@@ -127,183 +626,699 @@ func (p *tidyProvider) AdjustEndpoints(endpoints []*Endpoint) ([]*Endpoint, erro
 // changes in Tidy. It's assumed that update_old and update_new has equal number
 // of entries. Instead of changing records in-place, old records and simly
 // deleted and their corrections are created as new records.
-func (p *tidyProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
-	zones := p.zoneProvider.getZones()
-	wg := sync.WaitGroup{}
+func (p *tidyProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) (err error) {
+	ctx, span := p.startSpan(ctx, "ApplyChanges")
+	defer func() { endSpan(span, err) }()
 
-	for _, create := range changes.Create {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			p.createRecord(zones, create)
-		}()
+	// Zones are looked up for every endpoint, so they are indexed by name once
+	zones := newZoneIndex(p.zoneProvider.getZones())
+
+	// Errors from the concurrent operations are collected and returned joined
+	errs := []error{}
+	errMutex := sync.Mutex{}
+	collectErr := func(err error) {
+		if err == nil {
+			return
+		}
+
+		errMutex.Lock()
+		defer errMutex.Unlock()
+		errs = append(errs, err)
 	}
 
-	allRecords, err := p.allRecords()
+	allRecords, err := p.allRecords(ctx)
 	if err != nil {
 		slog.Error(err.Error())
 		return err
 	}
 
+	// Nothing is changed if there are endpoints without targets and they're
+	// rejected
+	if err := p.checkTargets(changes); err != nil {
+		return err
+	}
+
+	// Nothing is changed if the change deletes more records than allowed
+	if err := p.deleteLimit.check(countDeletes(changes, func(endpoint *Endpoint) []tidyRecord { return p.endpointRecords(allRecords, endpoint) }), len(allRecords)); err != nil {
+		return err
+	}
+
+	// Up to p.applyWorkers records are created and deleted at a time. Work
+	// not started before ctx is done is skipped, so nothing keeps changing
+	// Tidy after External-DNS has given up on the response.
+	pool := newWorkerPool(p.applyWorkers, p.workersActive, p.workersSaturated)
+	apply := func(work func() error) {
+		pool.run(func() {
+			if ctx.Err() == nil {
+				collectErr(work())
+			}
+		})
+	}
+
+	// Work finishing a change already partly made is done even when ctx is
+	// done, so records aren't left deleted
+	finish := func(work func() error) {
+		pool.run(func() {
+			collectErr(work())
+		})
+	}
+
+	// Deletes are started before creates, and creates wait for the deletes at
+	// their name to finish. Tidy rejects a CNAME next to other records, so a
+	// type change at a name must remove the old records first.
+	deletes := nameDeletes{}
 	for _, delete := range changes.Delete {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			p.deleteEndpoint(allRecords, delete)
-		}()
+		done := deletes.add(delete)
+		pool.run(func() {
+			defer done()
+			if ctx.Err() == nil {
+				collectErr(changeFailed(changeDelete, delete, p.deleteEndpoint(ctx, allRecords, delete)))
+			}
+		})
 	}
 
+	// The records replaced by updates are deleted, so they shouldn't be
+	// considered existing when creating their replacements. The deleted
+	// records are kept to restore them if their replacements can't be created.
+	// Records of a failed delete may still be in Tidy, so they're kept.
+	remainingRecords := allRecords
+	replaced := map[string][]tidyRecord{}
+	replacedMutex := sync.Mutex{}
+	deleted := map[string]bool{}
+	notDeleted := map[string]bool{}
 	for _, old := range changes.UpdateOld {
-		p.deleteEndpoint(allRecords, old)
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err := p.deleteEndpoint(ctx, allRecords, old); err != nil {
+			notDeleted[updateKey(old)] = true
+			collectErr(changeFailed(changeUpdate, old, err))
+			continue
+		}
+
+		replaced[updateKey(old)] = p.endpointRecords(allRecords, old)
+		deleted[updateKey(old)] = true
+		remainingRecords = excludeRecords(remainingRecords, p.endpointRecords(allRecords, old))
 	}
 
-	for _, new := range changes.UpdateNew {
+	for _, create := range dedupeEndpoints(changes.Create) {
+		apply(func() error {
+			deletes.wait(create)
+			return changeFailed(changeCreate, create, p.createRecord(ctx, zones, allRecords, create, p.description(create)))
+		})
+	}
+
+	// The records replaced by an update, which are only restored once
+	takeReplaced := func(new *Endpoint) []tidyRecord {
+		replacedMutex.Lock()
+		defer replacedMutex.Unlock()
+
+		records := replaced[updateKey(new)]
+		delete(replaced, updateKey(new))
+		return records
+	}
+
+	for _, new := range dedupeEndpoints(changes.UpdateNew) {
+		// Creating the records of an update whose delete failed would
+		// duplicate the replaced records left in Tidy, so the failed update is
+		// left for External-DNS to retry
+		if notDeleted[updateKey(new)] {
+			continue
+		}
+
+		// Descriptions maintained by operators in Tidy are carried over from
+		// the replaced records unless the endpoint has its own
+		description := p.description(new)
+		if description == "" {
+			description = recordDescription(allRecords, new)
+		}
+
+		// Once the records replaced by an update are deleted, the update is
+		// completed or rolled back regardless of ctx
+		updateCtx, run := ctx, apply
+		if deleted[updateKey(new)] {
+			updateCtx, run = context.WithoutCancel(ctx), finish
+		}
+
+		run(func() error {
+			deletes.wait(new)
+			err := p.createRecord(updateCtx, zones, remainingRecords, new, description)
+			if err != nil && p.rollbackUpdates {
+				err = p.rollbackUpdate(updateCtx, new, takeReplaced(new), err)
+			}
+
+			return changeFailed(changeUpdate, new, err)
+		})
+	}
+
+	pool.wait()
+
+	if err := ctx.Err(); err != nil {
+		collectErr(fmt.Errorf("changes only partly applied: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Deletes in progress by DNS name. All deletes are added before any create is
+// started, so the map is only read while creates wait.
+type nameDeletes map[string]*sync.WaitGroup
+
+// Add a delete at the name of endpoint, returning the function to call when
+// it's done
+func (d nameDeletes) add(endpoint *Endpoint) func() {
+	name := deleteName(endpoint)
+	if d[name] == nil {
+		d[name] = &sync.WaitGroup{}
+	}
+
+	d[name].Add(1)
+	return d[name].Done
+}
+
+// Wait for the deletes at the name of endpoint to finish
+func (d nameDeletes) wait(endpoint *Endpoint) {
+	if wg, ok := d[deleteName(endpoint)]; ok {
+		wg.Wait()
+	}
+}
+
+func deleteName(endpoint *Endpoint) string {
+	return strings.ToLower(strings.TrimSuffix(endpoint.DNSName, "."))
+}
+
+// Warn about and count the endpoints of changes without any targets, which
+// create nothing and match no records. They're likely a bug in External-DNS or
+// a source, so they can be rejected rather than silently doing nothing.
+func (p *tidyProvider) checkTargets(changes *plan.Changes) error {
+	errs := []error{}
+
+	check := func(change string, endpoints []*Endpoint) {
+		for _, endpoint := range endpoints {
+			if len(endpoint.Targets) > 0 {
+				continue
+			}
+
+			slog.Warn(fmt.Sprintf("%s of %s %s has no targets", change, endpoint.DNSName, endpoint.RecordType))
+			if p.emptyEndpoints != nil {
+				p.emptyEndpoints.Add(context.Background(), 1, otel.WithAttributes(attribute.Key("change").String(change)))
+			}
+
+			if p.rejectEmptyTargets {
+				errs = append(errs, fmt.Errorf("%s of %s %s: %w", change, endpoint.DNSName, endpoint.RecordType, errNoTargets))
+			}
+		}
+	}
+
+	check(changeCreate, changes.Create)
+	check(changeUpdate, changes.UpdateNew)
+	check(changeDelete, changes.Delete)
+
+	return errors.Join(errs...)
+}
+
+// Remove endpoints with the same name, type and targets as an earlier endpoint.
+// Multiple sources can produce the same endpoint, which would otherwise be
+// created once for each.
+func dedupeEndpoints(endpoints []*Endpoint) []*Endpoint {
+	seen := map[string]bool{}
+	unique := []*Endpoint{}
+
+	for _, endpoint := range endpoints {
+		targets := slices.Clone(endpoint.Targets)
+		slices.Sort(targets)
+
+		key := endpoint.DNSName + " " + endpoint.RecordType + " " + strings.Join(targets, " ")
+		if seen[key] {
+			slog.Debug("skipping duplicate endpoint " + endpoint.String())
+			continue
+		}
+
+		seen[key] = true
+		unique = append(unique, endpoint)
+	}
+
+	return unique
+}
+
+// Fetch and create a list of all records from all forward zones
+func (p *tidyProvider) allRecords(ctx context.Context) ([]tidyRecord, error) {
+	zones := forwardZones(p.zoneProvider.getZones())
+
+	// The records of up to p.concurrency zones are listed at a time. Results
+	// are kept per zone so the records come out in the order of the zones.
+	zoneRecords := make([][]tidyRecord, len(zones))
+	errs := make([]error, len(zones))
+	sem := make(chan struct{}, max(p.concurrency, 1))
+	wg := sync.WaitGroup{}
+
+	for i, zone := range zones {
 		wg.Add(1)
+		sem <- struct{}{}
 		go func() {
-			defer wg.Done()
-			p.createRecord(zones, new)
+			defer func() { <-sem; wg.Done() }()
+			zoneRecords[i], errs[i] = p.backends().forZone(zone.Name).ListRecords(ctx, zone.ID)
 		}()
 	}
 
 	wg.Wait()
 
-	return nil
-}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
 
-// Fetch and create a list of all records from all zones
-func (p *tidyProvider) allRecords() ([]tidyRecord, error) {
 	allRecords := []tidyRecord{}
+	for _, records := range zoneRecords {
+		allRecords = append(allRecords, records...)
+	}
 
-	for _, zone := range p.zoneProvider.getZones() {
-		records, err := p.tidy.ListRecords(zone.ID)
-		if err != nil {
-			return nil, err
+	// Records at the apex tell how this Tidy names the apex, which is used when
+	// creating records there
+	for _, record := range allRecords {
+		if isApexName(record.Name) {
+			p.seenApex.Store(record.Name)
 		}
+	}
 
-		allRecords = append(allRecords, records...)
+	// Records managed by others, e.g. by hand, are left out so they're never
+	// reported or deleted
+	if p.ownerMarker != "" {
+		allRecords = slices.DeleteFunc(allRecords, func(record tidyRecord) bool {
+			return !strings.Contains(record.Description, p.ownerMarker)
+		})
 	}
 
 	return allRecords, nil
 }
 
-// Find all matching records from a list and delete them. Since one endpoint can
-// have multiple targets an endpoint can represent multiple records in Tidy.
-func (p *tidyProvider) deleteEndpoint(allRecords []tidyRecord, endpoint *Endpoint) {
+// List the records of every zone once, retrying until it succeeds or ctx is
+// done. Used at startup so the webhook only reports ready when Tidy answers
+// for all zones.
+func (p *tidyProvider) prefetch(ctx context.Context, retryInterval time.Duration) error {
+	for {
+		start := time.Now()
+		records, err := p.allRecords(ctx)
+		if err == nil {
+			slog.Info(fmt.Sprintf("prefetched %d records in %s", len(records), time.Since(start)))
+			return nil
+		}
+
+		slog.Error("prefetching records: " + err.Error())
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// Find all records in a list matching an endpoint. Since one endpoint can have
+// multiple targets an endpoint can represent multiple records in Tidy.
+func findRecords(allRecords []tidyRecord, endpoint *Endpoint) []tidyRecord {
+	records := []tidyRecord{}
+
 	for _, target := range endpoint.Targets {
 		for _, record := range allRecords {
 			dnsName := tidyNameToFQDN(record.Name, record.ZoneName)
 
-			if dnsName != endpoint.DNSName || record.Type != endpoint.RecordType || record.Destination != target {
+			if dnsName != endpoint.DNSName || recordType(&record) != endpoint.RecordType || !matchesTarget(&record, target) {
 				continue
 			}
 
-			slog.Debug(fmt.Sprintf("delete record %+v", record))
-			err := p.tidy.DeleteRecord(record.ZoneID, record.ID)
-			if err != nil {
-				slog.Error(err.Error())
-				return
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// The records of the endpoints last reported by Records, by the name, type and
+// target they were reported with. External-DNS passes the endpoints to update
+// or delete back as it got them, so their records are found by ID rather than
+// matched. The IDs aren't kept in the labels of the endpoints, as the TXT
+// registry of External-DNS writes all labels into its ownership records.
+type reportedRecords struct {
+	lock         sync.RWMutex
+	ids          map[string][]recordRef
+	descriptions map[string]string
+}
+
+// A record by its zone and ID. Zone IDs are only unique within a Tidy server.
+type recordRef struct {
+	zoneID   json.Number
+	recordID json.Number
+}
+
+func reportedKey(dnsName, recordType, target string) string {
+	return dnsName + " " + recordType + " " + target
+}
+
+func descriptionKey(dnsName, recordType string) string {
+	return dnsName + " " + recordType
+}
+
+// Replace the records and descriptions reported
+func (r *reportedRecords) set(ids map[string][]recordRef, descriptions map[string]string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.ids = ids
+	r.descriptions = descriptions
+}
+
+// The description reported for the records of an endpoint
+func (r *reportedRecords) description(endpoint *Endpoint) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.descriptions[descriptionKey(endpoint.DNSName, endpoint.RecordType)]
+}
+
+// The records reported for the targets of an endpoint
+func (r *reportedRecords) find(endpoint *Endpoint) []recordRef {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	refs := []recordRef{}
+	for _, target := range endpoint.Targets {
+		refs = append(refs, r.ids[reportedKey(endpoint.DNSName, endpoint.RecordType, target)]...)
+	}
+
+	return refs
+}
+
+// Find the records an endpoint is made from. The records reported by Records
+// for the endpoint are used when any of them still exist. Otherwise records
+// are matched by name, type and target. Records are only matched by ID in the
+// zone of the endpoint.
+func (p *tidyProvider) endpointRecords(allRecords []tidyRecord, endpoint *Endpoint) []tidyRecord {
+	records := []tidyRecord{}
+
+	for _, ref := range p.reported.find(endpoint) {
+		for _, record := range allRecords {
+			if record.ZoneID == ref.zoneID && record.ID == ref.recordID && isSubdomain(endpoint.DNSName, record.ZoneName) {
+				records = append(records, record)
 			}
 		}
 	}
+
+	if len(records) > 0 {
+		return records
+	}
+
+	return findRecords(allRecords, endpoint)
+}
+
+// Find the description of the existing records with the same name and type as
+// an endpoint.
+func recordDescription(allRecords []tidyRecord, endpoint *Endpoint) string {
+	for _, record := range allRecords {
+		dnsName := tidyNameToFQDN(record.Name, record.ZoneName)
+		if dnsName == endpoint.DNSName && recordType(&record) == endpoint.RecordType && record.Description != "" {
+			return record.Description
+		}
+	}
+
+	return ""
+}
+
+// Add the owner marker to a description unless it's already there
+func withOwnerMarker(description, marker string) string {
+	switch {
+	case marker == "" || strings.Contains(description, marker):
+		return description
+	case description == "":
+		return marker
+	}
+
+	return description + " " + marker
+}
+
+// Remove the owner marker from a description
+func withoutOwnerMarker(description, marker string) string {
+	if marker == "" {
+		return description
+	}
+
+	return strings.TrimSpace(strings.Replace(description, marker, "", 1))
+}
+
+// The description of the records created from an endpoint, when configured
+func (p *tidyProvider) description(endpoint *Endpoint) string {
+	if p.descriptionProperty == "" {
+		return ""
+	}
+
+	description, _ := endpoint.GetProviderSpecificProperty(p.descriptionProperty)
+	return description
+}
+
+// The old and new endpoints of an update have the same name and type
+func updateKey(endpoint *Endpoint) string {
+	return endpoint.DNSName + " " + endpoint.RecordType
+}
+
+// Recreate the records replaced by an update whose new records couldn't be
+// created, so the name doesn't go missing from DNS. This is best-effort: the
+// records are recreated as they were read from Tidy, and new records created
+// before the failure are left alone. The returned error tells whether the
+// records were restored.
+func (p *tidyProvider) rollbackUpdate(ctx context.Context, new *Endpoint, replaced []tidyRecord, createErr error) error {
+	if len(replaced) == 0 {
+		return createErr
+	}
+
+	errs := []error{}
+	for _, record := range replaced {
+		slog.Warn(fmt.Sprintf("restore record %s of %s replaced by failed update", record.ID, new.DNSName))
+		if err := p.backends().forZone(record.ZoneName).CreateRecord(ctx, record.ZoneID, &record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return errors.Join(createErr, fmt.Errorf("restoring the records of %s: %w", new.DNSName, err))
+	}
+
+	return fmt.Errorf("%w, restored the records of %s", createErr, new.DNSName)
+}
+
+// Return the records which aren't in the exclude list, compared by record ID
+func excludeRecords(records, exclude []tidyRecord) []tidyRecord {
+	remaining := []tidyRecord{}
+
+	for _, record := range records {
+		excluded := false
+		for _, ex := range exclude {
+			if record.ZoneID == ex.ZoneID && record.ID == ex.ID {
+				excluded = true
+				break
+			}
+		}
+
+		if !excluded {
+			remaining = append(remaining, record)
+		}
+	}
+
+	return remaining
+}
+
+// Find all records of an endpoint and delete them. A record which Tidy reports
+// as not found is already gone and is not an error.
+func (p *tidyProvider) deleteEndpoint(ctx context.Context, allRecords []tidyRecord, endpoint *Endpoint) (err error) {
+	ctx, span := p.startSpan(ctx, "deleteEndpoint", attribute.String("dns.name", endpoint.DNSName), attribute.String("dns.type", endpoint.RecordType))
+	defer func() { endSpan(span, err) }()
+
+	if !p.managesType(endpoint.RecordType) {
+		slog.Debug(fmt.Sprintf("skip deleting %s, record type %s isn't managed", endpoint.DNSName, endpoint.RecordType))
+		return nil
+	}
+
+	for _, record := range p.endpointRecords(allRecords, endpoint) {
+		slog.Debug(fmt.Sprintf("delete record %+v", record))
+		err := p.backends().forZone(record.ZoneName).DeleteRecord(ctx, record.ZoneID, record.ID)
+		if errors.Is(err, tidydns.ErrNotFound) {
+			slog.Debug(fmt.Sprintf("record %s already deleted", record.ID))
+			continue
+		}
+
+		if err != nil {
+			slog.Error(err.Error())
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Create record(s) from an External-DNS endpoint. As endpoints can have
 // potentially multiple targets, we may create multiple records which is also
-// handled here.
-func (p *tidyProvider) createRecord(zones []tidydns.Zone, endpoint *Endpoint) {
-	dnsName, zoneID := tidyfyName(zones, endpoint.DNSName)
-	if dnsName == "" {
-		slog.Debug(fmt.Sprintf("DNS name %s cannot be mapped", endpoint.DNSName))
-		return
+// handled here. Records already present in allRecords aren't created again,
+// making retries of partially applied changes safe. The records are created with
+// the given description, marked as managed by the webhook when so configured.
+func (p *tidyProvider) createRecord(ctx context.Context, zones zoneIndex, allRecords []tidyRecord, endpoint *Endpoint, description string) (err error) {
+	ctx, span := p.startSpan(ctx, "createRecord", attribute.String("dns.name", endpoint.DNSName), attribute.String("dns.type", endpoint.RecordType))
+	defer func() { endSpan(span, err) }()
+
+	if !p.managesType(endpoint.RecordType) {
+		slog.Debug(fmt.Sprintf("skip creating %s, record type %s isn't managed", endpoint.DNSName, endpoint.RecordType))
+		return nil
 	}
 
-	ttl := clampTTL(int(endpoint.RecordTTL))
+	dnsName, zoneID, ok := tidyfyName(zones, endpoint.DNSName, p.apexName())
+	if !ok {
+		p.countDropped(context.Background(), droppedNoZone)
+		return fmt.Errorf("endpoint %s has %w", endpoint.DNSName, errNoZone)
+	}
 
-	for _, target := range endpoint.Targets {
-		// For some reason external-dns wraps the value of certain TXT records
-		// with extra double quotes. This isn't supported by Tidy and it will
-		// refuse to save and removing them seemingly causes no issues for
-		// external-dns when read back.
-		target = strings.Trim(target, "\"")
+	zone, _ := zones.find(endpoint.DNSName)
+	ttl := p.ttl.clamp(zone.Name, int(endpoint.RecordTTL))
+
+	// Tidy would reject the record without saying why
+	if endpoint.RecordType == "CNAME" && strings.EqualFold(endpoint.DNSName, zone.Name) {
+		return fmt.Errorf("endpoint %s: %w", endpoint.DNSName, errApexCNAME)
+	}
+
+	status := p.zoneDefaults.statusOf(zone.Name)
+	if value, ok := endpoint.GetProviderSpecificProperty(statusProperty); ok && value == statusDisabled {
+		status = tidydns.RecordStatusDisabled
+	}
 
-		if endpoint.RecordType == "CNAME" {
-			target += "."
+	priority, hasPriority := endpoint.GetProviderSpecificProperty(priorityProperty)
+
+	for _, target := range endpoint.Targets {
+		if hasPriority {
+			target = withPriority(endpoint.RecordType, target, priority)
 		}
 
 		newRec := &tidyRecord{
 			Type:        endpoint.RecordType,
 			Name:        dnsName,
-			Description: "",
-			Destination: target,
+			Description: withOwnerMarker(description, p.ownerMarker),
 			TTL:         json.Number(strconv.Itoa(ttl)),
+			Status:      status,
+			LocationID:  p.zoneDefaults.locationOf(zone.Name),
+		}
+
+		if err := setRecordTarget(newRec, target); err != nil {
+			slog.Warn(fmt.Sprintf("skip target of %s: %s", endpoint.DNSName, err))
+			continue
+		}
+
+		if recordExists(allRecords, zoneID, newRec) {
+			slog.Debug(fmt.Sprintf("record already exists %+v", *newRec))
+			continue
 		}
 
 		slog.Debug(fmt.Sprintf("create record %+v", *newRec))
-		if err := p.tidy.CreateRecord(zoneID, newRec); err != nil {
+		if err := p.backends().forZone(zone.Name).CreateRecord(ctx, zoneID, newRec); err != nil {
 			slog.Warn(err.Error())
 			slog.Debug(fmt.Sprintf("%+v", *newRec))
-			return
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Check if an identical record is present in a list of records
+func recordExists(allRecords []tidyRecord, zoneID json.Number, newRec *tidyRecord) bool {
+	for _, record := range allRecords {
+		if record.ZoneID == zoneID && record.Name == newRec.Name && recordType(&record) == newRec.Type && recordTarget(&record) == recordTarget(newRec) && record.TTL == newRec.TTL {
+			return true
 		}
 	}
+
+	return false
 }
 
 // Convert a Tidy record into an External-DNS endpoint. This potentially changes
-// the TTL, the content of a TXT record and the DNS name.
-func parseTidyRecord(record *tidyRecord) *Endpoint {
+// the TTL, the content of a TXT record and the DNS name. Records which can't be
+// converted, e.g. with a TTL that isn't a number, give a recordParseError.
+func parseTidyRecord(record *tidyRecord) (*Endpoint, error) {
 	// Convert DNS name into a FQDN
 	dnsName := tidyNameToFQDN(record.Name, record.ZoneName)
 
-	ttlTemp, err := record.TTL.Int64()
+	ttlTemp, coerced, err := parseTTL(record.TTL)
 	if err != nil {
-		slog.Error(err.Error())
-		return nil
+		return nil, &recordParseError{parseErrorInvalidTTL, err}
+	}
+
+	if coerced {
+		slog.Warn(fmt.Sprintf("record %s of %s has TTL %q in Tidy, reporting it as %d", record.ID, dnsName, record.TTL, ttlTemp))
 	}
 
 	// Convert TTL to TTL type
 	ttl := endpoint.TTL(ttlTemp)
 
-	if record.Type == "CNAME" {
-		record.Destination = strings.TrimRight(record.Destination, ".")
+	// A records with an IPv6 destination are reported as AAAA records, which
+	// External-DNS would otherwise take to be invalid A records
+	recordType := recordType(record)
+	if recordType != record.Type {
+		slog.Warn(fmt.Sprintf("record %s of %s has type %s in Tidy but an IPv6 destination, reporting it as %s", record.ID, dnsName, record.Type, recordType))
 	}
 
 	// Create Endpoint
-	return endpoint.NewEndpointWithTTL(dnsName, record.Type, ttl, record.Destination)
+	ep := endpoint.NewEndpointWithTTL(dnsName, recordType, ttl, recordTarget(record))
+
+	// Report the status as it was requested, so External-DNS sees no change
+	if !record.IsActive() {
+		ep.SetProviderSpecificProperty(statusProperty, statusDisabled)
+	}
+
+	return ep, nil
 }
 
+// Convert Tidy DNS names into FQDNs. Depending on the version Tidy names the
+// apex of a zone either ".", "@" or with an empty name.
 func tidyNameToFQDN(name, zone string) string {
-	if name == "." {
+	if isApexName(name) {
 		return zone
 	}
 
 	return name + "." + zone
 }
 
-// Handles sanitizing TTL to Tidy. TidyDNS doesn't support TTL under 300 except
-// 0 which is the namespace default value
-func clampTTL(ttl int) int {
-	if ttl > 0 && ttl < 300 {
-		return 300
+// Convert FQDNs into Tidy DNS names. External-DNS communicates DNS names using
+// the FQDN where-as Tidy strips away the namespace and uses '.' when the
+// namespace is the FQDN. The apex of a zone is named apexName. The name and ID
+// of the zone are only valid when ok is true, which it isn't when the name
+// doesn't belong to any of the zones.
+func tidyfyName(zones zoneIndex, name, apexName string) (string, json.Number, bool) {
+	zone, ok := zones.find(name)
+	if !ok {
+		return "", "", false
+	}
+
+	if cutted, _ := strings.CutSuffix(name, zone.Name); cutted != "" {
+		cutted, _ = strings.CutSuffix(cutted, ".")
+		return cutted, zone.ID, true
 	}
 
-	return ttl
+	return apexName, zone.ID, true
 }
 
-// Convert FQDNs into Tidy DNS names. External-DNS communicates DNS names using
-// the FQDN where-as Tidy strips away the namespace and uses '.' when the
-// namespace is the FQDN.
-func tidyfyName(zones []tidydns.Zone, name string) (string, json.Number) {
-	for _, zone := range zones {
-		if !strings.HasSuffix(name, zone.Name) {
-			continue
-		}
+// Names Tidy may give the apex of a zone
+var apexTokens = []string{".", "@", ""}
 
-		if cutted, _ := strings.CutSuffix(name, zone.Name); cutted != "" {
-			cutted, _ = strings.CutSuffix(cutted, ".")
-			return cutted, zone.ID
-		}
+// Detect the apex token from the records read rather than configuring it
+const apexTokenAuto = "auto"
+
+func isApexName(name string) bool {
+	return slices.Contains(apexTokens, name)
+}
+
+// The name of the apex of a zone in Tidy. It's the configured token, or else
+// the name Tidy has been seen to give the apex, defaulting to ".".
+func (p *tidyProvider) apexName() string {
+	if p.apexTokenSet {
+		return p.apexToken
+	}
 
-		return ".", zone.ID
+	if seen, ok := p.seenApex.Load().(string); ok {
+		return seen
 	}
 
-	return "", "0"
+	return "."
 }
@@ -19,11 +19,24 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"sigs.k8s.io/external-dns/endpoint"
 	"sigs.k8s.io/external-dns/plan"
 )
@@ -33,18 +46,24 @@ type mockTidyDNSClient struct {
 	createdRecords   []tidydns.Record
 	deletedRecordIds []json.Number
 	err              error
+
+	// ApplyChanges creates and deletes records concurrently
+	lock sync.Mutex
 }
 
-func (m *mockTidyDNSClient) CreateRecord(zoneID json.Number, record *tidydns.Record) error {
+func (m *mockTidyDNSClient) CreateRecord(ctx context.Context, zoneID json.Number, record *tidydns.Record) error {
 	if m.err != nil {
 		return m.err
 	}
 
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	m.createdRecords = append(m.createdRecords, *record)
 	return nil
 }
 
-func (m *mockTidyDNSClient) ListRecords(zoneID json.Number) ([]tidydns.Record, error) {
+func (m *mockTidyDNSClient) ListRecords(ctx context.Context, zoneID json.Number) ([]tidydns.Record, error) {
 	if m.err != nil {
 		return nil, m.err
 	}
@@ -52,16 +71,19 @@ func (m *mockTidyDNSClient) ListRecords(zoneID json.Number) ([]tidydns.Record, e
 	return m.createdRecords, nil
 }
 
-func (m *mockTidyDNSClient) DeleteRecord(zoneID json.Number, recordID json.Number) error {
+func (m *mockTidyDNSClient) DeleteRecord(ctx context.Context, zoneID json.Number, recordID json.Number) error {
 	if m.err != nil {
 		return m.err
 	}
 
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	m.deletedRecordIds = append(m.deletedRecordIds, recordID)
 	return nil
 }
 
-func (m *mockTidyDNSClient) ListZones() ([]tidydns.Zone, error) {
+func (m *mockTidyDNSClient) ListZones(ctx context.Context) ([]tidydns.Zone, error) {
 	return m.zones, m.err
 }
 
@@ -76,7 +98,10 @@ func (m *mockZoneProvider) getZones() []tidydns.Zone {
 func TestNewProvider(t *testing.T) {
 	tidy := &mockTidyDNSClient{}
 	zoneUpdateInterval := 10 * time.Minute
-	provider := newProvider(tidy, zoneUpdateInterval)
+	provider, err := newProvider(tidy, nil, providerConfig{zoneUpdateInterval: zoneUpdateInterval, apexToken: apexTokenAuto, metricsPrefix: "tidy_"}, nil, noop.NewMeterProvider().Meter("test"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
 
 	if provider.tidy != tidy {
 		t.Errorf("expected tidy to be %v, got %v", tidy, provider.tidy)
@@ -87,6 +112,59 @@ func TestNewProvider(t *testing.T) {
 	}
 }
 
+func TestEndpointsDropped(t *testing.T) {
+	reader := metric.NewManualReader()
+	meter := metric.NewMeterProvider(metric.WithReader(reader)).Meter("test")
+
+	provider, err := newProvider(&mockTidyDNSClient{}, nil, providerConfig{zoneUpdateInterval: time.Hour, apexToken: apexTokenAuto, metricsPrefix: "tidy_"}, nil, meter)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	provider.zoneProvider = &mockZoneProvider{}
+
+	endpoints := []*endpoint.Endpoint{
+		{DNSName: "www.example.com", RecordType: "PTR", Targets: endpoint.Targets{"example.com"}},
+		{DNSName: "www.example.com", RecordType: "NS", Targets: endpoint.Targets{"ns.example.com"}},
+		{DNSName: "www.example.com", RecordType: "A", Targets: endpoint.Targets{"10.0.0.1"}},
+	}
+
+	if _, err := provider.AdjustEndpoints(endpoints); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	changes := &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "www.example.org", RecordType: "A", Targets: endpoint.Targets{"10.0.0.1"}},
+		},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); !errors.Is(err, errNoZone) {
+		t.Fatalf("expected %v, got %v", errNoZone, err)
+	}
+
+	data := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	instrument := data.ScopeMetrics[0].Metrics[0]
+	if instrument.Name != "tidy_endpoints_dropped" {
+		t.Errorf("expected metric tidy_endpoints_dropped, got %s", instrument.Name)
+	}
+
+	dropped := map[string]int64{}
+	for _, point := range instrument.Data.(metricdata.Sum[int64]).DataPoints {
+		reason, _ := point.Attributes.Value("reason")
+		dropped[reason.AsString()] = point.Value
+	}
+
+	expected := map[string]int64{droppedUnsupportedType: 2, droppedNoZone: 1}
+	if !maps.Equal(dropped, expected) {
+		t.Errorf("expected %v, got %v", expected, dropped)
+	}
+}
+
 func TestGetDomainFilter(t *testing.T) {
 	tidy := &mockTidyDNSClient{}
 	zoneProvider := &mockZoneProvider{}
@@ -105,6 +183,374 @@ func TestGetDomainFilter(t *testing.T) {
 	}
 }
 
+type mockReverseZoneProvider struct{}
+
+func (m *mockReverseZoneProvider) getZones() []tidydns.Zone {
+	return []tidydns.Zone{
+		{Name: "example.com"},
+		{Name: "10.in-addr.arpa"},
+	}
+}
+
+func TestGetDomainFilterSkipsReverseZones(t *testing.T) {
+	provider := &tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockReverseZoneProvider{},
+	}
+
+	domainFilter := provider.GetDomainFilter()
+
+	if !domainFilter.Match("example.com") {
+		t.Errorf("expected domain filter to match example.com")
+	}
+
+	if domainFilter.Match("1.0.0.10.in-addr.arpa") {
+		t.Errorf("expected domain filter not to match reverse zone")
+	}
+}
+
+func TestGetDomainFilterDuplicateZones(t *testing.T) {
+	provider := &tidyProvider{
+		tidy: &mockTidyDNSClient{},
+		zoneProvider: staticZoneProvider{
+			{ID: "1", Name: "example.com"},
+			{ID: "2", Name: "example.com"},
+		},
+	}
+
+	domainFilter, ok := provider.GetDomainFilter().(endpoint.DomainFilter)
+	if !ok {
+		t.Fatalf("expected an endpoint.DomainFilter")
+	}
+
+	if !slices.Equal(domainFilter.Filters, []string{"example.com"}) {
+		t.Errorf("expected example.com to be reported once, got %v", domainFilter.Filters)
+	}
+}
+
+func TestGetDomainFilterExcludedDomain(t *testing.T) {
+	provider := &tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: staticZoneProvider{{ID: "1", Name: "example.com"}},
+		domains:      domainScope{exclude: []string{"legacy.example.com"}},
+	}
+
+	domainFilter := provider.GetDomainFilter()
+
+	for _, name := range []string{"example.com", "www.example.com", "notlegacy.example.com"} {
+		if !domainFilter.Match(name) {
+			t.Errorf("expected domain filter to match %s", name)
+		}
+	}
+
+	for _, name := range []string{"legacy.example.com", "www.legacy.example.com"} {
+		if domainFilter.Match(name) {
+			t.Errorf("expected domain filter not to match %s", name)
+		}
+	}
+
+	// External-DNS learns of the excluded domains in the negotiation
+	negotiated, err := json.Marshal(domainFilter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"include":["example.com"],"exclude":["legacy.example.com"]}`
+	if string(negotiated) != expected {
+		t.Errorf("expected %s, got %s", expected, negotiated)
+	}
+}
+
+func TestRecordsTargetOrder(t *testing.T) {
+	records := []tidydns.Record{
+		{ID: "1", Type: "A", Name: "multi", Destination: "5.6.7.8", TTL: "300", ZoneName: "example.com"},
+		{ID: "2", Type: "A", Name: "multi", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com"},
+		{ID: "3", Type: "A", Name: "multi", Destination: "3.4.5.6", TTL: "300", ZoneName: "example.com"},
+	}
+
+	// The same records returned by Tidy in another order
+	reversed := slices.Clone(records)
+	slices.Reverse(reversed)
+
+	expected := endpoint.Targets{"1.2.3.4", "3.4.5.6", "5.6.7.8"}
+	for _, order := range [][]tidydns.Record{records, reversed} {
+		provider := &tidyProvider{
+			tidy:         &mockTidyDNSClient{createdRecords: order},
+			zoneProvider: &mockZoneProvider{},
+		}
+
+		endpoints, err := provider.Records(context.Background())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(endpoints) != 1 || !slices.Equal(endpoints[0].Targets, expected) {
+			t.Errorf("expected targets %v, got %v", expected, endpoints)
+		}
+	}
+}
+
+func TestRecordsRecordIDs(t *testing.T) {
+	provider := &tidyProvider{
+		tidy: &mockTidyDNSClient{
+			createdRecords: []tidydns.Record{
+				{ID: "1", Type: "A", Name: "multi", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+				{ID: "2", Type: "A", Name: "multi", Destination: "5.6.7.8", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+			},
+		},
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	endpoints, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(endpoints) != 1 || len(endpoints[0].Labels) != 0 {
+		t.Fatalf("expected one endpoint without labels, got %v", endpoints)
+	}
+
+	expected := []recordRef{{"1", "1"}, {"1", "2"}}
+	if refs := provider.reported.find(endpoints[0]); !slices.Equal(refs, expected) {
+		t.Errorf("expected the records %v to be reported, got %v", expected, refs)
+	}
+}
+
+// The TXT registry of External-DNS adds the labels of its ownership records to
+// the endpoints reported, and makes the ownership records to delete from the
+// labels of the endpoints deleted. Labels added by the provider would end up in
+// the ownership records and they wouldn't match the records in Tidy. The
+// registry isn't vendored, so this does what it does with the endpoints.
+func TestTXTRegistryOwnership(t *testing.T) {
+	ownership := "heritage=external-dns,external-dns/owner=default,external-dns/resource=ingress/default/web"
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "www", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+			{ID: "2", Type: "TXT", Name: "a-www", Destination: ownership, TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+		},
+	}
+
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	endpoints, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	owners := map[string]endpoint.Labels{}
+	var www *Endpoint
+	for _, ep := range endpoints {
+		if ep.RecordType != "TXT" {
+			www = ep
+			continue
+		}
+
+		labels, err := endpoint.NewLabelsFromStringPlain(ep.Targets[0])
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		owners[strings.TrimPrefix(ep.DNSName, "a-")] = labels
+	}
+
+	if www == nil {
+		t.Fatalf("expected www.example.com to be reported, got %v", endpoints)
+	}
+
+	maps.Copy(www.Labels, owners[www.DNSName])
+
+	txt := endpoint.NewEndpoint("a-"+www.DNSName, "TXT", www.Labels.SerializePlain(true))
+	txt.Labels[endpoint.OwnedRecordLabelKey] = www.DNSName
+
+	if txt.Targets[0] != `"`+ownership+`"` {
+		t.Errorf("expected the ownership record %q to be made, got %s", ownership, txt.Targets[0])
+	}
+
+	if err := provider.ApplyChanges(context.Background(), &plan.Changes{Delete: []*Endpoint{www, txt}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	deleted := slices.Sorted(slices.Values(tidy.deletedRecordIds))
+	if !slices.Equal(deleted, []json.Number{"1", "2"}) {
+		t.Errorf("expected the record and its ownership record to be deleted, got %v", deleted)
+	}
+}
+
+// Tidy client keeping track of the zones it's told to forget
+type forgettingClient struct {
+	mockTidyDNSClient
+	forgotten []json.Number
+}
+
+func (c *forgettingClient) ForgetZone(zoneID json.Number) {
+	c.forgotten = append(c.forgotten, zoneID)
+}
+
+func TestZonesChangedForgetsRemovedZones(t *testing.T) {
+	fallback := &forgettingClient{}
+	other := &forgettingClient{}
+	provider := &tidyProvider{
+		tidy:        fallback,
+		zoneClients: map[string]tidydns.TidyDNSClient{"example.org": other},
+	}
+
+	added := []tidydns.Zone{{ID: "1", Name: "example.net"}}
+	removed := []tidydns.Zone{{ID: "2", Name: "example.com"}, {ID: "3", Name: "example.org"}}
+	provider.zonesChanged(added, removed)
+
+	if !slices.Equal(fallback.forgotten, []json.Number{"2"}) || !slices.Equal(other.forgotten, []json.Number{"3"}) {
+		t.Errorf("expected the removed zones to be forgotten by their clients, got %v and %v", fallback.forgotten, other.forgotten)
+	}
+}
+
+type mockUnicodeZoneProvider struct{}
+
+func (m *mockUnicodeZoneProvider) getZones() []tidydns.Zone {
+	return []tidydns.Zone{
+		{Name: "exämple.com"},
+	}
+}
+
+// Tidy client listing a record per zone, keeping track of how many zones are
+// listed at the same time
+type concurrencyTidyDNSClient struct {
+	mockTidyDNSClient
+	active    atomic.Int32
+	maxActive atomic.Int32
+	failures  atomic.Int32
+}
+
+func (c *concurrencyTidyDNSClient) ListRecords(ctx context.Context, zoneID json.Number) ([]tidydns.Record, error) {
+	active := c.active.Add(1)
+	defer c.active.Add(-1)
+
+	for {
+		maxActive := c.maxActive.Load()
+		if active <= maxActive || c.maxActive.CompareAndSwap(maxActive, active) {
+			break
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	if c.failures.Add(-1) >= 0 {
+		return nil, errors.New("tidy unavailable")
+	}
+
+	return []tidydns.Record{{ID: zoneID, ZoneID: zoneID, Name: "www"}}, nil
+}
+
+type manyZoneProvider struct{ zones int }
+
+func (m *manyZoneProvider) getZones() []tidydns.Zone {
+	zones := []tidydns.Zone{}
+	for i := range m.zones {
+		zones = append(zones, tidydns.Zone{ID: json.Number(strconv.Itoa(i)), Name: fmt.Sprintf("zone%d.com", i)})
+	}
+
+	return zones
+}
+
+func TestAllRecordsConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+	}{
+		{"Unset", 0},
+		{"Sequential", 1},
+		{"Bounded", 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tidy := &concurrencyTidyDNSClient{}
+			provider := &tidyProvider{
+				tidy:         tidy,
+				zoneProvider: &manyZoneProvider{zones: 10},
+				concurrency:  test.concurrency,
+			}
+
+			records, err := provider.allRecords(context.Background())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(records) != 10 {
+				t.Fatalf("expected 10 records, got %d", len(records))
+			}
+
+			for i, record := range records {
+				if record.ZoneID != json.Number(strconv.Itoa(i)) {
+					t.Errorf("expected record %d to be from zone %d, got %s", i, i, record.ZoneID)
+				}
+			}
+
+			if maxActive := int(tidy.maxActive.Load()); maxActive > max(test.concurrency, 1) {
+				t.Errorf("expected at most %d zones listed at a time, got %d", max(test.concurrency, 1), maxActive)
+			}
+		})
+	}
+}
+
+func TestAllRecordsError(t *testing.T) {
+	tidy := &concurrencyTidyDNSClient{}
+	tidy.failures.Store(1)
+
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &manyZoneProvider{zones: 5},
+		concurrency:  2,
+	}
+
+	if _, err := provider.allRecords(context.Background()); err == nil {
+		t.Errorf("expected error, got none")
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	tidy := &concurrencyTidyDNSClient{}
+	tidy.failures.Store(1)
+
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &manyZoneProvider{zones: 1},
+	}
+
+	if err := provider.prefetch(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("expected no error after retrying, got %v", err)
+	}
+
+	tidy.failures.Store(1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := provider.prefetch(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context canceled, got %v", err)
+	}
+}
+
+func TestGetDomainFilterUnicodeZone(t *testing.T) {
+	provider := &tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockUnicodeZoneProvider{},
+	}
+
+	endpoints, err := provider.AdjustEndpoints([]*Endpoint{
+		endpoint.NewEndpointWithTTL("www.exämple.com", "A", 300, "1.2.3.4"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	domainFilter := provider.GetDomainFilter()
+	if !domainFilter.Match(endpoints[0].DNSName) {
+		t.Errorf("expected domain filter to match %s", endpoints[0].DNSName)
+	}
+}
+
 func TestRecords(t *testing.T) {
 	tidy := &mockTidyDNSClient{}
 	zoneProvider := &mockZoneProvider{}
@@ -153,6 +599,24 @@ func TestRecords(t *testing.T) {
 			expectedError:  true,
 			expectedResult: nil,
 		},
+		{
+			name: "Fractional TTL",
+			mockRecords: []tidydns.Record{
+				{
+					ID:          "2",
+					Type:        "A",
+					Name:        "fractional-ttl",
+					Destination: "1.2.3.4",
+					TTL:         json.Number("300.2"),
+					ZoneName:    "example.com",
+					ZoneID:      "1",
+				},
+			},
+			expectedError: false,
+			expectedResult: []*Endpoint{
+				endpoint.NewEndpointWithTTL("fractional-ttl.example.com", "A", 300, "1.2.3.4"),
+			},
+		},
 		{
 			name: "Invalid TTL",
 			mockRecords: []tidydns.Record{
@@ -161,7 +625,7 @@ func TestRecords(t *testing.T) {
 					Type:        "A",
 					Name:        "invalid-ttl",
 					Destination: "1.2.3.4",
-					TTL:         json.Number("300.2"),
+					TTL:         json.Number("invalid"),
 					ZoneName:    "example.com",
 					ZoneID:      "1",
 				},
@@ -169,6 +633,35 @@ func TestRecords(t *testing.T) {
 			expectedError:  false,
 			expectedResult: []*Endpoint{},
 		},
+		{
+			name: "Inactive record",
+			mockRecords: []tidydns.Record{
+				{
+					ID:          "5",
+					Type:        "A",
+					Name:        "active",
+					Destination: "1.2.3.4",
+					TTL:         json.Number("300"),
+					ZoneName:    "example.com",
+					ZoneID:      "1",
+					Status:      json.Number("0"),
+				},
+				{
+					ID:          "6",
+					Type:        "A",
+					Name:        "inactive",
+					Destination: "1.2.3.4",
+					TTL:         json.Number("300"),
+					ZoneName:    "example.com",
+					ZoneID:      "1",
+					Status:      json.Number("1"),
+				},
+			},
+			expectedError: false,
+			expectedResult: []*Endpoint{
+				endpoint.NewEndpointWithTTL("active.example.com", "A", 300, "1.2.3.4"),
+			},
+		},
 		{
 			name: "Multiple records",
 			mockRecords: []tidydns.Record{
@@ -266,19 +759,47 @@ func TestAdjustEndpoints(t *testing.T) {
 			},
 		},
 		{
-			name: "No adjustment needed",
+			name: "Invalid IDN label left unchanged",
+			endpoints: []*Endpoint{
+				endpoint.NewEndpointWithTTL("xn--a.example.com", "A", 300, "1.2.3.4"),
+			},
+			expected: []*Endpoint{
+				endpoint.NewEndpointWithTTL("xn--a.example.com", "A", 300, "1.2.3.4"),
+			},
+		},
+		{
+			name: "Wildcard left unchanged",
+			endpoints: []*Endpoint{
+				endpoint.NewEndpointWithTTL("*.example.com", "A", 300, "1.2.3.4"),
+			},
+			expected: []*Endpoint{
+				endpoint.NewEndpointWithTTL("*.example.com", "A", 300, "1.2.3.4"),
+			},
+		},
+		{
+			name: "Unsupported type dropped",
 			endpoints: []*Endpoint{
+				endpoint.NewEndpointWithTTL("example.com", "NAPTR", 300, "100 10 \"S\" \"SIP+D2U\" \"\" _sip._udp.example.com."),
 				endpoint.NewEndpointWithTTL("example.com", "A", 300, "1.2.3.4"),
 			},
 			expected: []*Endpoint{
 				endpoint.NewEndpointWithTTL("example.com", "A", 300, "1.2.3.4"),
 			},
 		},
-	}
-
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			tidy := &mockTidyDNSClient{}
+		{
+			name: "No adjustment needed",
+			endpoints: []*Endpoint{
+				endpoint.NewEndpointWithTTL("example.com", "A", 300, "1.2.3.4"),
+			},
+			expected: []*Endpoint{
+				endpoint.NewEndpointWithTTL("example.com", "A", 300, "1.2.3.4"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tidy := &mockTidyDNSClient{}
 			provider := &tidyProvider{
 				tidy:         tidy,
 				zoneProvider: &mockZoneProvider{},
@@ -302,6 +823,163 @@ func TestAdjustEndpoints(t *testing.T) {
 	}
 }
 
+// Tidy would truncate TTLs over its maximum, so External-DNS must be told the
+// lowered TTL rather than update the record on every sync
+func TestMaxTTLStable(t *testing.T) {
+	provider := &tidyProvider{
+		tidy: &mockTidyDNSClient{createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "www", Destination: "1.2.3.4", TTL: "604800", ZoneName: "example.com", ZoneID: "1"},
+		}},
+		zoneProvider: &mockZoneProvider{},
+		ttl:          ttlPolicy{maxTTL: 604800},
+	}
+
+	current, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	desired, err := provider.AdjustEndpoints([]*Endpoint{endpoint.NewEndpointWithTTL("www.example.com", "A", 1209600, "1.2.3.4")})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if desired[0].RecordTTL != 604800 {
+		t.Errorf("expected the TTL to be lowered to the maximum, got %d", desired[0].RecordTTL)
+	}
+
+	changes := (&plan.Plan{
+		Current:        current,
+		Desired:        desired,
+		Policies:       []plan.Policy{&plan.SyncPolicy{}},
+		ManagedRecords: []string{"A"},
+	}).Calculate().Changes
+
+	if changes.HasChanges() {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
+// A record with TTL 0 inherits the default TTL of its zone in Tidy. Endpoints
+// without a TTL of their own mean the same, so syncing them again must not
+// change the record, while TTL 0 raised to the minimum replaces it once.
+func TestZeroTTLStable(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       ttlPolicy
+		recordTTL json.Number
+		updates   int
+	}{
+		{"Inherited TTL kept", ttlPolicy{}, "0", 0},
+		{"Missing TTL kept", ttlPolicy{}, "", 0},
+		{"Set TTL kept", ttlPolicy{}, "600", 0},
+		{"Inherited TTL raised", ttlPolicy{clampZero: true}, "0", 1},
+		{"Raised TTL kept", ttlPolicy{clampZero: true}, "300", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &tidyProvider{
+				tidy: &mockTidyDNSClient{createdRecords: []tidydns.Record{
+					{ID: "1", Type: "A", Name: "www", Destination: "1.2.3.4", TTL: test.recordTTL, ZoneName: "example.com", ZoneID: "1"},
+				}},
+				zoneProvider: &mockZoneProvider{},
+				ttl:          test.ttl,
+			}
+
+			current, err := provider.Records(context.Background())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			desired, err := provider.AdjustEndpoints([]*Endpoint{endpoint.NewEndpoint("www.example.com", "A", "1.2.3.4")})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			changes := (&plan.Plan{
+				Current:        current,
+				Desired:        desired,
+				Policies:       []plan.Policy{&plan.SyncPolicy{}},
+				ManagedRecords: []string{"A"},
+			}).Calculate().Changes
+
+			if len(changes.Create) != 0 || len(changes.Delete) != 0 || len(changes.UpdateNew) != test.updates {
+				t.Errorf("expected %d updates and nothing else, got %+v", test.updates, changes)
+			}
+		})
+	}
+}
+
+func TestAdjustEndpointsIDNAProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  string
+		dnsName  string
+		expected string
+	}{
+		{"Lookup maps case", "lookup", "Bücher.example.com", "xn--bcher-kva.example.com"},
+		{"Registration rejects case", "registration", "Bücher.example.com", "Bücher.example.com"},
+		{"Nontransitional maps case", "nontransitional", "Bücher.example.com", "xn--bcher-kva.example.com"},
+		{"Lookup maps compatibility characters", "lookup", "Ⅻ.example.com", "xii.example.com"},
+		{"Registration rejects compatibility characters", "registration", "Ⅻ.example.com", "Ⅻ.example.com"},
+		{"Nontransitional keeps deviations", "nontransitional", "straße.example.com", "xn--strae-oqa.example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &tidyProvider{
+				tidy:         &mockTidyDNSClient{},
+				zoneProvider: &mockZoneProvider{},
+				idnaProfile:  idnaProfiles[test.profile],
+			}
+
+			endpoints := []*Endpoint{endpoint.NewEndpoint(test.dnsName, "A", "1.2.3.4")}
+			adjusted, err := provider.AdjustEndpoints(endpoints)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if adjusted[0].DNSName != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, adjusted[0].DNSName)
+			}
+		})
+	}
+}
+
+func TestAdjustEndpointsDisableIDNA(t *testing.T) {
+	tests := []struct {
+		name        string
+		disableIDNA bool
+		dnsName     string
+		expected    string
+	}{
+		{"Underscore", true, "_dmarc.example.com", "_dmarc.example.com"},
+		{"Unicode", true, "bücher.example.com", "bücher.example.com"},
+		{"Unicode encoded", false, "bücher.example.com", "xn--bcher-kva.example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &tidyProvider{
+				tidy:         &mockTidyDNSClient{},
+				zoneProvider: &mockZoneProvider{},
+				disableIDNA:  test.disableIDNA,
+			}
+
+			endpoints := []*Endpoint{endpoint.NewEndpoint(test.dnsName, "TXT", "v=DMARC1; p=none")}
+			adjusted, err := provider.AdjustEndpoints(endpoints)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if adjusted[0].DNSName != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, adjusted[0].DNSName)
+			}
+		})
+	}
+}
+
 func TestApplyChanges(t *testing.T) {
 	tidy := &mockTidyDNSClient{}
 	zoneProvider := &mockZoneProvider{}
@@ -359,19 +1037,865 @@ func TestApplyChanges(t *testing.T) {
 		},
 	}
 
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			if test.expectErr {
-				tidy.err = fmt.Errorf("apply changes error")
-			} else {
-				tidy.err = nil
-			}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.expectErr {
+				tidy.err = fmt.Errorf("apply changes error")
+			} else {
+				tidy.err = nil
+			}
+
+			err := provider.ApplyChanges(context.Background(), test.changes)
+			if !test.expectErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyChangesUpdateRecreatesDeleted(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{
+				ID:          "1",
+				Type:        "A",
+				Name:        "update",
+				Destination: "1.2.3.4",
+				TTL:         json.Number("300"),
+				ZoneName:    "example.com",
+				ZoneID:      "1",
+			},
+		},
+	}
+
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	changes := &plan.Changes{
+		UpdateOld: []*Endpoint{
+			endpoint.NewEndpointWithTTL("update.example.com", "A", 300, "1.2.3.4"),
+		},
+		UpdateNew: []*Endpoint{
+			endpoint.NewEndpointWithTTL("update.example.com", "A", 300, "1.2.3.4").
+				WithProviderSpecific(statusProperty, statusDisabled),
+		},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(tidy.deletedRecordIds) != 1 {
+		t.Fatalf("expected 1 record to be deleted, got %d", len(tidy.deletedRecordIds))
+	}
+
+	if len(tidy.createdRecords) != 2 {
+		t.Fatalf("expected the updated record to be created, got %d records", len(tidy.createdRecords))
+	}
+}
+
+type failingDeleteClient struct {
+	*mockTidyDNSClient
+	recordID json.Number
+}
+
+func (c *failingDeleteClient) DeleteRecord(ctx context.Context, zoneID json.Number, recordID json.Number) error {
+	if recordID == c.recordID {
+		return errors.New("500 Internal Server Error")
+	}
+
+	return c.mockTidyDNSClient.DeleteRecord(ctx, zoneID, recordID)
+}
+
+func TestApplyChangesUpdateDeleteFailed(t *testing.T) {
+	mock := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "update", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+			{ID: "2", Type: "A", Name: "update", Destination: "5.6.7.8", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+		},
+	}
+
+	provider := &tidyProvider{
+		tidy:         &failingDeleteClient{mockTidyDNSClient: mock, recordID: "2"},
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	changes := &plan.Changes{
+		UpdateOld: []*Endpoint{endpoint.NewEndpointWithTTL("update.example.com", "A", 300, "1.2.3.4", "5.6.7.8")},
+		UpdateNew: []*Endpoint{endpoint.NewEndpointWithTTL("update.example.com", "A", 600, "1.2.3.4", "5.6.7.8")},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); err == nil {
+		t.Error("expected the failed delete to be reported")
+	}
+
+	// Record 2 is still in Tidy, so creating the new records would duplicate it
+	if len(mock.createdRecords) != 2 {
+		t.Errorf("expected no records to be created, got %v", mock.createdRecords[2:])
+	}
+}
+
+func TestApplyChangesUpdateKeepsDescription(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{
+				ID:          "1",
+				Type:        "A",
+				Name:        "update",
+				Description: "Managed by the web team",
+				Destination: "1.2.3.4",
+				TTL:         json.Number("300"),
+				ZoneName:    "example.com",
+				ZoneID:      "1",
+			},
+		},
+	}
+
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	changes := &plan.Changes{
+		UpdateOld: []*Endpoint{
+			endpoint.NewEndpointWithTTL("update.example.com", "A", 300, "1.2.3.4"),
+		},
+		UpdateNew: []*Endpoint{
+			endpoint.NewEndpointWithTTL("update.example.com", "A", 300, "5.6.7.8"),
+		},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	created := tidy.createdRecords[len(tidy.createdRecords)-1]
+	if created.Destination != "5.6.7.8" || created.Description != "Managed by the web team" {
+		t.Errorf("expected updated record to keep the description, got %+v", created)
+	}
+}
+
+func TestOwnerMarker(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "owned", Description: "web managed-by=external-dns", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+			{ID: "2", Type: "A", Name: "manual", Description: "Added by hand", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+			{ID: "3", Type: "A", Name: "unknown", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+		},
+	}
+
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+		ownerMarker:  "managed-by=external-dns",
+	}
+
+	endpoints, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(endpoints) != 1 || endpoints[0].DNSName != "owned.example.com" {
+		t.Fatalf("expected only the owned record, got %v", endpoints)
+	}
+
+	changes := &plan.Changes{
+		Create: []*Endpoint{
+			endpoint.NewEndpointWithTTL("new.example.com", "A", 300, "5.6.7.8"),
+		},
+		Delete: []*Endpoint{
+			endpoint.NewEndpointWithTTL("owned.example.com", "A", 300, "1.2.3.4"),
+			endpoint.NewEndpointWithTTL("manual.example.com", "A", 300, "1.2.3.4"),
+		},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !slices.Equal(tidy.deletedRecordIds, []json.Number{"1"}) {
+		t.Errorf("expected only the owned record to be deleted, got %v", tidy.deletedRecordIds)
+	}
+
+	created := tidy.createdRecords[len(tidy.createdRecords)-1]
+	if created.Name != "new" || created.Description != "managed-by=external-dns" {
+		t.Errorf("expected the created record to be marked, got %+v", created)
+	}
+}
+
+func TestWithOwnerMarker(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		marker      string
+		expected    string
+	}{
+		{"No marker", "web", "", "web"},
+		{"Empty description", "", "owner=dns", "owner=dns"},
+		{"Appended", "web", "owner=dns", "web owner=dns"},
+		{"Already marked", "owner=dns web", "owner=dns", "owner=dns web"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if description := withOwnerMarker(test.description, test.marker); description != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, description)
+			}
+		})
+	}
+}
+
+// Fails creating records with the given destination
+type failingCreateClient struct {
+	*mockTidyDNSClient
+	destination string
+}
+
+func (c *failingCreateClient) CreateRecord(ctx context.Context, zoneID json.Number, record *tidydns.Record) error {
+	if record.Destination == c.destination {
+		return errors.New("create failed")
+	}
+
+	return c.mockTidyDNSClient.CreateRecord(ctx, zoneID, record)
+}
+
+func TestApplyChangesRollbackUpdate(t *testing.T) {
+	old := tidydns.Record{ID: "1", Type: "A", Name: "update", Description: "web", Destination: "1.2.3.4", TTL: "600", ZoneName: "example.com", ZoneID: "1"}
+
+	tests := []struct {
+		name     string
+		rollback bool
+		restored bool
+	}{
+		{"Rolled back", true, true},
+		{"Not rolled back", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tidy := &mockTidyDNSClient{createdRecords: []tidydns.Record{old}}
+			provider := &tidyProvider{
+				tidy:            &failingCreateClient{tidy, "5.6.7.8"},
+				zoneProvider:    &mockZoneProvider{},
+				rollbackUpdates: test.rollback,
+			}
+
+			changes := &plan.Changes{
+				UpdateOld: []*Endpoint{endpoint.NewEndpointWithTTL("update.example.com", "A", 600, "1.2.3.4")},
+				UpdateNew: []*Endpoint{endpoint.NewEndpointWithTTL("update.example.com", "A", 600, "5.6.7.8")},
+			}
+
+			err := provider.ApplyChanges(context.Background(), changes)
+			if err == nil || !strings.Contains(err.Error(), "create failed") {
+				t.Fatalf("expected the failed create to be reported, got %v", err)
+			}
+
+			if !slices.Equal(tidy.deletedRecordIds, []json.Number{"1"}) {
+				t.Errorf("expected the old record to be deleted, got %v", tidy.deletedRecordIds)
+			}
+
+			restored := len(tidy.createdRecords) == 2 && tidy.createdRecords[1] == old
+			if restored != test.restored || strings.Contains(err.Error(), "restored") != test.restored {
+				t.Errorf("expected the old record to be restored %v, got %v and %v", test.restored, tidy.createdRecords, err)
+			}
+		})
+	}
+}
+
+func TestDescriptionAnnotation(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "update", Description: "Managed by the web team", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+		},
+	}
+
+	provider := &tidyProvider{
+		tidy:                tidy,
+		zoneProvider:        &mockZoneProvider{},
+		descriptionProperty: "webhook/tidydns-description",
+	}
+
+	create := endpoint.NewEndpointWithTTL("create.example.com", "A", 300, "1.2.3.4")
+	create.SetProviderSpecificProperty("webhook/tidydns-description", "Created by the app team")
+	updateNew := endpoint.NewEndpointWithTTL("update.example.com", "A", 300, "5.6.7.8")
+	updateNew.SetProviderSpecificProperty("webhook/tidydns-description", "Updated by the app team")
+
+	adjusted, err := provider.AdjustEndpoints([]*Endpoint{create, updateNew})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, ep := range adjusted {
+		if _, ok := ep.GetProviderSpecificProperty("webhook/tidydns-description"); !ok {
+			t.Errorf("expected the description property to be kept on %s", ep.DNSName)
+		}
+	}
+
+	changes := &plan.Changes{
+		Create:    []*Endpoint{adjusted[0]},
+		UpdateOld: []*Endpoint{endpoint.NewEndpointWithTTL("update.example.com", "A", 300, "1.2.3.4")},
+		UpdateNew: []*Endpoint{adjusted[1]},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	descriptions := map[string]string{}
+	for _, record := range tidy.createdRecords[1:] {
+		descriptions[record.Name] = record.Description
+	}
+
+	expected := map[string]string{"create": "Created by the app team", "update": "Updated by the app team"}
+	if !maps.Equal(descriptions, expected) {
+		t.Errorf("expected descriptions %v, got %v", expected, descriptions)
+	}
+}
+
+// The description is reported with the records, so External-DNS only updates
+// records when the annotation changes their description
+func TestDescriptionChanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		updates     int
+	}{
+		{"No annotations", map[string]string{}, 0},
+		{"Same description", map[string]string{"web": "Managed by the web team"}, 0},
+		{"Empty description", map[string]string{"web": ""}, 0},
+		{"Changed description", map[string]string{"web": "Managed by the app team"}, 1},
+		{"New description", map[string]string{"api": "Managed by the app team"}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &tidyProvider{
+				tidy: &mockTidyDNSClient{
+					createdRecords: []tidydns.Record{
+						{ID: "1", Type: "A", Name: "web", Description: "Managed by the web team owner=dns", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+						{ID: "2", Type: "A", Name: "api", Description: "owner=dns", Destination: "1.2.3.5", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+					},
+				},
+				zoneProvider:        &mockZoneProvider{},
+				descriptionProperty: "webhook/tidydns-description",
+				ownerMarker:         "owner=dns",
+			}
+
+			current, err := provider.Records(context.Background())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			desired := []*Endpoint{
+				endpoint.NewEndpointWithTTL("web.example.com", "A", 300, "1.2.3.4"),
+				endpoint.NewEndpointWithTTL("api.example.com", "A", 300, "1.2.3.5"),
+			}
+
+			for _, ep := range desired {
+				if description, ok := test.annotations[strings.TrimSuffix(ep.DNSName, ".example.com")]; ok {
+					ep.SetProviderSpecificProperty("webhook/tidydns-description", description)
+				}
+			}
+
+			desired, err = provider.AdjustEndpoints(desired)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			changes := (&plan.Plan{
+				Current:        current,
+				Desired:        desired,
+				Policies:       []plan.Policy{&plan.SyncPolicy{}},
+				ManagedRecords: []string{"A"},
+			}).Calculate().Changes
+
+			if len(changes.Create) != 0 || len(changes.Delete) != 0 || len(changes.UpdateNew) != test.updates {
+				t.Errorf("expected %d updates and nothing else, got %+v", test.updates, changes)
+			}
+		})
+	}
+}
+
+func TestManagedRecordTypes(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "www", Destination: "10.0.0.1", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+			{ID: "2", Type: "MX", Name: ".", Destination: "mail.example.com.", Priority: "10", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+		},
+	}
+
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+		managedTypes: []string{"A", "TXT"},
+	}
+
+	endpoints, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(endpoints) != 1 || endpoints[0].RecordType != "A" {
+		t.Errorf("expected only the A record to be reported, got %v", endpoints)
+	}
+
+	adjusted, err := provider.AdjustEndpoints([]*Endpoint{
+		endpoint.NewEndpoint("example.com", "MX", "10 mail.example.com"),
+		endpoint.NewEndpoint("www.example.com", "TXT", "text"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(adjusted) != 1 || adjusted[0].RecordType != "TXT" {
+		t.Errorf("expected only the TXT endpoint to be kept, got %v", adjusted)
+	}
+
+	changes := &plan.Changes{
+		Create: []*Endpoint{endpoint.NewEndpoint("example.com", "MX", "20 mail2.example.com")},
+		Delete: []*Endpoint{endpoint.NewEndpoint("example.com", "MX", "10 mail.example.com")},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(tidy.createdRecords) != 2 || len(tidy.deletedRecordIds) != 0 {
+		t.Errorf("expected no MX records to be created or deleted, got %v created and %v deleted", tidy.createdRecords, tidy.deletedRecordIds)
+	}
+}
+
+func TestRecordsMergePolicy(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "www", Destination: "10.0.0.2", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+			{ID: "2", Type: "A", Name: "www", Destination: "10.0.0.1", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		splitRecords bool
+		expected     [][]string
+	}{
+		{"Merged", false, [][]string{{"10.0.0.1", "10.0.0.2"}}},
+		{"Split", true, [][]string{{"10.0.0.2"}, {"10.0.0.1"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &tidyProvider{
+				tidy:         tidy,
+				zoneProvider: &mockZoneProvider{},
+				splitRecords: test.splitRecords,
+			}
+
+			endpoints, err := provider.Records(context.Background())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			targets := [][]string{}
+			for _, endpoint := range endpoints {
+				targets = append(targets, endpoint.Targets)
+			}
+
+			if fmt.Sprint(targets) != fmt.Sprint(test.expected) {
+				t.Errorf("expected targets %v, got %v", test.expected, targets)
+			}
+		})
+	}
+}
+
+func TestRecordsPreserveTargetOrder(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{ID: "10", Type: "A", Name: "www", Destination: "10.0.0.1", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+			{ID: "9", Type: "A", Name: "www", Destination: "10.0.0.3", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+			{ID: "11", Type: "A", Name: "www", Destination: "10.0.0.2", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		preserveOrder bool
+		expected      []string
+	}{
+		{"Sorted", false, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"Record ID order", true, []string{"10.0.0.3", "10.0.0.1", "10.0.0.2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &tidyProvider{
+				tidy:                tidy,
+				zoneProvider:        &mockZoneProvider{},
+				preserveTargetOrder: test.preserveOrder,
+			}
+
+			endpoints, err := provider.Records(context.Background())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(endpoints) != 1 {
+				t.Fatalf("expected 1 endpoint, got %d", len(endpoints))
+			}
+
+			if !slices.Equal(endpoints[0].Targets, test.expected) {
+				t.Errorf("expected targets %v, got %v", test.expected, endpoints[0].Targets)
+			}
+
+			adjusted, err := provider.AdjustEndpoints([]*Endpoint{endpoint.NewEndpoint("www.example.com", "A", "10.0.0.3", "10.0.0.1", "10.0.0.2")})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if !slices.Equal(adjusted[0].Targets, endpoints[0].Targets) {
+				t.Errorf("expected adjusted targets %v, got %v", endpoints[0].Targets, adjusted[0].Targets)
+			}
+		})
+	}
+}
+
+func TestDeleteSplitRecord(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "www", Destination: "10.0.0.1", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+			{ID: "2", Type: "A", Name: "www", Destination: "10.0.0.2", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+		},
+	}
+
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+		splitRecords: true,
+	}
+
+	// A single target endpoint as reported without merging, before and after
+	// the records have been reported
+	for _, report := range []bool{false, true} {
+		if report {
+			if _, err := provider.Records(context.Background()); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+
+		ep := endpoint.NewEndpoint("www.example.com", "A", "10.0.0.2")
+		tidy.deletedRecordIds = nil
+		if err := provider.ApplyChanges(context.Background(), &plan.Changes{Delete: []*Endpoint{ep}}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !slices.Equal(tidy.deletedRecordIds, []json.Number{"2"}) {
+			t.Errorf("expected record 2 to be deleted, got %v", tidy.deletedRecordIds)
+		}
+	}
+}
+
+func TestApplyChangesDuplicateCreates(t *testing.T) {
+	tidy := &mockTidyDNSClient{}
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	changes := &plan.Changes{
+		Create: []*Endpoint{
+			endpoint.NewEndpointWithTTL("dup.example.com", "A", 300, "1.2.3.4"),
+			endpoint.NewEndpointWithTTL("dup.example.com", "A", 300, "1.2.3.4"),
+		},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(tidy.createdRecords) != 1 {
+		t.Errorf("expected 1 created record, got %d", len(tidy.createdRecords))
+	}
+}
+
+func TestDedupeEndpoints(t *testing.T) {
+	endpoints := []*Endpoint{
+		endpoint.NewEndpoint("a.example.com", "A", "1.2.3.4", "5.6.7.8"),
+		endpoint.NewEndpoint("a.example.com", "A", "5.6.7.8", "1.2.3.4"),
+		endpoint.NewEndpoint("a.example.com", "TXT", "1.2.3.4", "5.6.7.8"),
+		endpoint.NewEndpoint("b.example.com", "A", "1.2.3.4"),
+	}
+
+	unique := dedupeEndpoints(endpoints)
+	if len(unique) != 3 || unique[0] != endpoints[0] || unique[1] != endpoints[2] || unique[2] != endpoints[3] {
+		t.Errorf("expected duplicates to be removed in order, got %v", unique)
+	}
+}
+
+func TestApplyChangesNoZone(t *testing.T) {
+	provider := &tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	changes := &plan.Changes{
+		Create: []*Endpoint{
+			endpoint.NewEndpointWithTTL("create.example.net", "A", 300, "1.2.3.4"),
+		},
+	}
+
+	err := provider.ApplyChanges(context.Background(), changes)
+	if !errors.Is(err, errNoZone) {
+		t.Fatalf("expected no zone error, got %v", err)
+	}
+}
+
+func TestApplyChangesSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	provider := &tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+		tracer:       tracer,
+	}
+
+	changes := &plan.Changes{
+		Create: []*Endpoint{
+			endpoint.NewEndpoint("www.example.com", "A", "10.0.0.1"),
+			endpoint.NewEndpoint("www.example.org", "A", "10.0.0.1"),
+		},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); err == nil {
+		t.Fatalf("expected an error for the endpoint outside the zones")
+	}
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	if len(spans["ApplyChanges"]) != 1 || len(spans["createRecord"]) != 2 {
+		t.Fatalf("expected an ApplyChanges span and 2 createRecord spans, got %v", spans)
+	}
+
+	apply := spans["ApplyChanges"][0]
+	if apply.Status().Code != codes.Error {
+		t.Errorf("expected the ApplyChanges span to have failed, got %v", apply.Status())
+	}
+
+	for _, create := range spans["createRecord"] {
+		if create.Parent().SpanID() != apply.SpanContext().SpanID() {
+			t.Errorf("expected createRecord to be traced within ApplyChanges")
+		}
+	}
+}
+
+func TestApplyChangesDeadline(t *testing.T) {
+	tidy := &mockTidyDNSClient{}
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	changes := &plan.Changes{
+		Create: []*Endpoint{
+			endpoint.NewEndpoint("www.example.com", "A", "10.0.0.1"),
+		},
+	}
+
+	err := provider.ApplyChanges(ctx, changes)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the changes to be abandoned, got %v", err)
+	}
+
+	if len(tidy.createdRecords) != 0 {
+		t.Errorf("expected no records created, got %d", len(tidy.createdRecords))
+	}
+}
+
+// Tidy client for which ctx is done once a record is deleted
+type cancelingDeleteClient struct {
+	*failingCreateClient
+	cancel context.CancelFunc
+}
+
+func (c *cancelingDeleteClient) DeleteRecord(ctx context.Context, zoneID json.Number, recordID json.Number) error {
+	defer c.cancel()
+	return c.mockTidyDNSClient.DeleteRecord(ctx, zoneID, recordID)
+}
+
+func TestApplyChangesDeadlineDuringUpdate(t *testing.T) {
+	old := tidydns.Record{ID: "1", Type: "A", Name: "update", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"}
+
+	tests := []struct {
+		name      string
+		failingAt string
+		expected  []string
+	}{
+		{"Create", "", []string{"1.2.3.4", "5.6.7.8"}},
+		{"Rollback", "5.6.7.8", []string{"1.2.3.4", "1.2.3.4"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			mock := &mockTidyDNSClient{createdRecords: []tidydns.Record{old}}
+			provider := &tidyProvider{
+				tidy: &cancelingDeleteClient{
+					failingCreateClient: &failingCreateClient{mockTidyDNSClient: mock, destination: test.failingAt},
+					cancel:              cancel,
+				},
+				zoneProvider:    &mockZoneProvider{},
+				rollbackUpdates: true,
+			}
+
+			changes := &plan.Changes{
+				UpdateOld: []*Endpoint{endpoint.NewEndpointWithTTL("update.example.com", "A", 300, "1.2.3.4")},
+				UpdateNew: []*Endpoint{endpoint.NewEndpointWithTTL("update.example.com", "A", 300, "5.6.7.8")},
+			}
+
+			if err := provider.ApplyChanges(ctx, changes); !errors.Is(err, context.Canceled) {
+				t.Errorf("expected the changes to be partly applied, got %v", err)
+			}
+
+			destinations := []string{}
+			for _, record := range mock.createdRecords {
+				destinations = append(destinations, record.Destination)
+			}
+
+			if !slices.Equal(destinations, test.expected) {
+				t.Errorf("expected records %v, got %v", test.expected, destinations)
+			}
+		})
+	}
+}
+
+func TestApplyChangesEmptyTargets(t *testing.T) {
+	tests := []struct {
+		name        string
+		reject      bool
+		expectError bool
+		created     int
+	}{
+		{"Warn", false, false, 1},
+		{"Reject", true, true, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := metric.NewManualReader()
+			meter := metric.NewMeterProvider(metric.WithReader(reader)).Meter("test")
+			empty, err := meter.Int64Counter("tidy_endpoints_without_targets")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			tidy := &mockTidyDNSClient{}
+			provider := &tidyProvider{
+				tidy:               tidy,
+				zoneProvider:       &mockZoneProvider{},
+				emptyEndpoints:     empty,
+				rejectEmptyTargets: test.reject,
+			}
+
+			changes := &plan.Changes{
+				Create: []*Endpoint{
+					endpoint.NewEndpoint("empty.example.com", "A"),
+					endpoint.NewEndpoint("www.example.com", "A", "10.0.0.1"),
+				},
+			}
+
+			err = provider.ApplyChanges(context.Background(), changes)
+			if test.expectError != errors.Is(err, errNoTargets) {
+				t.Errorf("expected error %v, got %v", test.expectError, err)
+			}
+
+			if len(tidy.createdRecords) != test.created {
+				t.Errorf("expected %d records created, got %d", test.created, len(tidy.createdRecords))
+			}
+
+			data := metricdata.ResourceMetrics{}
+			if err := reader.Collect(context.Background(), &data); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			point := data.ScopeMetrics[0].Metrics[0].Data.(metricdata.Sum[int64]).DataPoints[0]
+			if change, _ := point.Attributes.Value("change"); point.Value != 1 || change.AsString() != "create" {
+				t.Errorf("expected one create counted, got %d %s", point.Value, change.AsString())
+			}
+		})
+	}
+}
+
+func TestRecordsParseErrors(t *testing.T) {
+	reader := metric.NewManualReader()
+	meter := metric.NewMeterProvider(metric.WithReader(reader)).Meter("test")
+	parseErrors, err := meter.Int64Counter("tidy_records_parse_errors")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	provider := &tidyProvider{
+		tidy: &mockTidyDNSClient{createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "www", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+			{ID: "2", Type: "A", Name: "bad", Destination: "1.2.3.4", TTL: "1e999", ZoneName: "example.com", ZoneID: "1"},
+			{ID: "3", Type: "A", Name: "worse", Destination: "1.2.3.4", TTL: "1e999", ZoneName: "example.com", ZoneID: "1"},
+		}},
+		zoneProvider: &mockZoneProvider{},
+		parseErrors:  parseErrors,
+	}
+
+	endpoints, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(endpoints) != 1 || endpoints[0].DNSName != "www.example.com" {
+		t.Errorf("expected only www.example.com, got %v", endpoints)
+	}
+
+	data := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	point := data.ScopeMetrics[0].Metrics[0].Data.(metricdata.Sum[int64]).DataPoints[0]
+	if reason, _ := point.Attributes.Value("reason"); point.Value != 2 || reason.AsString() != parseErrorInvalidTTL {
+		t.Errorf("expected two records with invalid TTL counted, got %d %s", point.Value, reason.AsString())
+	}
+}
+
+func TestApplyChangesApexCNAME(t *testing.T) {
+	tidy := &mockTidyDNSClient{}
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	apex := endpoint.NewEndpointWithTTL("example.com", "CNAME", 300, "lb.example.net")
+	apex.SetProviderSpecificProperty("alias", "true")
+
+	changes := &plan.Changes{
+		Create: []*Endpoint{
+			apex,
+			endpoint.NewEndpointWithTTL("www.example.com", "CNAME", 300, "lb.example.net"),
+		},
+	}
+
+	err := provider.ApplyChanges(context.Background(), changes)
+	if !errors.Is(err, errApexCNAME) {
+		t.Fatalf("expected apex CNAME error, got %v", err)
+	}
 
-			err := provider.ApplyChanges(context.Background(), test.changes)
-			if !test.expectErr && err != nil {
-				t.Fatalf("expected no error, got %v", err)
-			}
-		})
+	if len(tidy.createdRecords) != 1 || tidy.createdRecords[0].Name != "www" {
+		t.Errorf("expected only www to be created, got %v", tidy.createdRecords)
 	}
 }
 
@@ -401,7 +1925,9 @@ func TestDeleteEndpoint(t *testing.T) {
 		name         string
 		encounterErr error
 		endpoint     *Endpoint
+		reported     map[string][]recordRef
 		expected     []json.Number
+		expectErr    bool
 	}{
 		{
 			name:         "Delete A record",
@@ -419,6 +1945,24 @@ func TestDeleteEndpoint(t *testing.T) {
 				json.Number("2"),
 			},
 		},
+		{
+			name:         "Delete by record ID",
+			encounterErr: nil,
+			endpoint:     endpoint.NewEndpoint("delete.example.com", "A", "5.6.7.8"),
+			reported:     map[string][]recordRef{"delete.example.com A 5.6.7.8": {{"1", "1"}}},
+			expected: []json.Number{
+				json.Number("1"),
+			},
+		},
+		{
+			name:         "Stale record ID falls back to matching",
+			encounterErr: nil,
+			endpoint:     endpoint.NewEndpoint("www.example.com", "CNAME", "example.com"),
+			reported:     map[string][]recordRef{"www.example.com CNAME example.com": {{"1", "9"}}},
+			expected: []json.Number{
+				json.Number("2"),
+			},
+		},
 		{
 			name:         "Delete non-existing record",
 			encounterErr: nil,
@@ -430,6 +1974,14 @@ func TestDeleteEndpoint(t *testing.T) {
 			encounterErr: fmt.Errorf("delete record error"),
 			endpoint:     endpoint.NewEndpointWithTTL("delete.example.com", "A", 300, "1.2.3.4"),
 			expected:     []json.Number{},
+			expectErr:    true,
+		},
+		{
+			name:         "Record already deleted",
+			encounterErr: tidydns.ErrNotFound,
+			endpoint:     endpoint.NewEndpointWithTTL("delete.example.com", "A", 300, "1.2.3.4"),
+			expected:     []json.Number{},
+			expectErr:    false,
 		},
 	}
 
@@ -443,8 +1995,14 @@ func TestDeleteEndpoint(t *testing.T) {
 				tidy:         tidy,
 				zoneProvider: &mockZoneProvider{},
 			}
+			provider.reported.set(test.reported, nil)
 
-			provider.deleteEndpoint(allRecords, test.endpoint)
+			err := provider.deleteEndpoint(context.Background(), allRecords, test.endpoint)
+			if test.expectErr && err == nil {
+				t.Fatalf("expected error, got none")
+			} else if !test.expectErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
 
 			if len(tidy.deletedRecordIds) != len(test.expected) {
 				t.Fatalf("expected %d records to be deleted, got %d", len(test.expected), len(tidy.deletedRecordIds))
@@ -471,6 +2029,7 @@ func TestCreateRecord(t *testing.T) {
 		encounterErr error
 		endpoint     *Endpoint
 		expected     []tidydns.Record
+		expectErr    bool
 	}{
 		{
 			name:         "Create A record",
@@ -492,6 +2051,7 @@ func TestCreateRecord(t *testing.T) {
 			encounterErr: fmt.Errorf("create record error"),
 			endpoint:     endpoint.NewEndpointWithTTL("create.example.com", "A", 300, "1.2.3.4"),
 			expected:     []tidydns.Record{},
+			expectErr:    true,
 		},
 		{
 			name:         "Create CNAME record",
@@ -541,6 +2101,7 @@ func TestCreateRecord(t *testing.T) {
 			encounterErr: nil,
 			endpoint:     endpoint.NewEndpointWithTTL("nozone.example.com", "A", 300, "1.2.3.4"),
 			expected:     []tidydns.Record{},
+			expectErr:    true,
 		},
 	}
 
@@ -555,7 +2116,12 @@ func TestCreateRecord(t *testing.T) {
 				zoneProvider: &mockZoneProvider{},
 			}
 
-			provider.createRecord(test.zones, test.endpoint)
+			err := provider.createRecord(context.Background(), newZoneIndex(test.zones), []tidydns.Record{}, test.endpoint, "")
+			if test.expectErr && err == nil {
+				t.Fatalf("expected error, got none")
+			} else if !test.expectErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
 
 			if len(tidy.createdRecords) != len(test.expected) {
 				t.Fatalf("expected %d records to be created, got %d", len(test.expected), len(tidy.createdRecords))
@@ -570,6 +2136,301 @@ func TestCreateRecord(t *testing.T) {
 	}
 }
 
+func TestCreateRecordExisting(t *testing.T) {
+	zones := []tidydns.Zone{
+		{Name: "example.com", ID: "1"},
+	}
+
+	allRecords := []tidydns.Record{
+		{
+			ID:          "1",
+			Type:        "A",
+			Name:        "create",
+			Destination: "1.2.3.4",
+			TTL:         json.Number("300"),
+			ZoneName:    "example.com",
+			ZoneID:      "1",
+		},
+	}
+
+	tidy := &mockTidyDNSClient{}
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	provider.createRecord(context.Background(), newZoneIndex(zones), allRecords, endpoint.NewEndpointWithTTL("create.example.com", "A", 300, "1.2.3.4", "5.6.7.8"), "")
+
+	if len(tidy.createdRecords) != 1 {
+		t.Fatalf("expected 1 record to be created, got %d", len(tidy.createdRecords))
+	}
+
+	if tidy.createdRecords[0].Destination != "5.6.7.8" {
+		t.Errorf("expected only the missing target to be created, got %s", tidy.createdRecords[0].Destination)
+	}
+}
+
+func TestCreateRecordZoneMinTTL(t *testing.T) {
+	zones := []tidydns.Zone{
+		{Name: "example.com", ID: "1"},
+		{Name: "example.org", ID: "2"},
+	}
+
+	tidy := &mockTidyDNSClient{}
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+		ttl:          ttlPolicy{zoneMinTTL: map[string]int{"example.com": 60}},
+	}
+
+	for _, name := range []string{"a.example.com", "a.example.org"} {
+		ep := endpoint.NewEndpointWithTTL(name, "A", 30, "1.2.3.4")
+		if err := provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, ep, ""); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	if ttl := tidy.createdRecords[0].TTL; ttl != "60" {
+		t.Errorf("expected the minimum TTL of the zone, got %s", ttl)
+	}
+
+	if ttl := tidy.createdRecords[1].TTL; ttl != "300" {
+		t.Errorf("expected the global minimum TTL, got %s", ttl)
+	}
+}
+
+func TestCreateRecordDisabled(t *testing.T) {
+	zones := []tidydns.Zone{
+		{Name: "example.com", ID: "1"},
+	}
+
+	tidy := &mockTidyDNSClient{}
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	active := endpoint.NewEndpointWithTTL("active.example.com", "A", 300, "1.2.3.4")
+	disabled := endpoint.NewEndpointWithTTL("disabled.example.com", "A", 300, "1.2.3.4").
+		WithProviderSpecific(statusProperty, statusDisabled)
+
+	provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, active, "")
+	provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, disabled, "")
+
+	if len(tidy.createdRecords) != 2 {
+		t.Fatalf("expected 2 records to be created, got %d", len(tidy.createdRecords))
+	}
+
+	if tidy.createdRecords[0].Status != tidydns.RecordStatusActive {
+		t.Errorf("expected status %s, got %s", tidydns.RecordStatusActive, tidy.createdRecords[0].Status)
+	}
+
+	if tidy.createdRecords[1].Status != tidydns.RecordStatusDisabled {
+		t.Errorf("expected status %s, got %s", tidydns.RecordStatusDisabled, tidy.createdRecords[1].Status)
+	}
+
+	// Reading the disabled record back must carry the same status property
+	result, _ := parseTidyRecord(&tidydns.Record{
+		Type:        "A",
+		Name:        "disabled",
+		Destination: "1.2.3.4",
+		TTL:         "300",
+		ZoneName:    "example.com",
+		Status:      tidydns.RecordStatusDisabled,
+	})
+
+	if value, ok := result.GetProviderSpecificProperty(statusProperty); !ok || value != statusDisabled {
+		t.Errorf("expected status property %s, got %s", statusDisabled, value)
+	}
+}
+
+func TestCreateRecordZoneDefaults(t *testing.T) {
+	zones := []tidydns.Zone{
+		{Name: "example.com", ID: "1"},
+		{Name: "example.org", ID: "2"},
+	}
+
+	tidy := &mockTidyDNSClient{}
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+		zoneDefaults: zoneDefaults{
+			location: map[string]int{"example.com": 3},
+			status:   map[string]json.Number{"example.com": tidydns.RecordStatusDisabled},
+		},
+	}
+
+	provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, endpoint.NewEndpointWithTTL("www.example.com", "A", 300, "1.2.3.4"), "")
+	provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, endpoint.NewEndpointWithTTL("www.example.org", "A", 300, "1.2.3.4"), "")
+
+	if len(tidy.createdRecords) != 2 {
+		t.Fatalf("expected 2 records to be created, got %d", len(tidy.createdRecords))
+	}
+
+	if record := tidy.createdRecords[0]; record.LocationID != "3" || record.Status != tidydns.RecordStatusDisabled {
+		t.Errorf("expected location 3 and status %s, got %s and %s", tidydns.RecordStatusDisabled, record.LocationID, record.Status)
+	}
+
+	if record := tidy.createdRecords[1]; record.LocationID != "0" || record.Status != tidydns.RecordStatusActive {
+		t.Errorf("expected location 0 and status %s, got %s and %s", tidydns.RecordStatusActive, record.LocationID, record.Status)
+	}
+}
+
+func TestRecordsZoneDefaultStatus(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{
+				ID:          "1",
+				Type:        "A",
+				Name:        "www",
+				Destination: "1.2.3.4",
+				TTL:         "300",
+				ZoneName:    "example.com",
+				ZoneID:      "1",
+				Status:      tidydns.RecordStatusDisabled,
+			},
+		},
+	}
+
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+		zoneDefaults: zoneDefaults{
+			status: map[string]json.Number{"example.com": tidydns.RecordStatusDisabled},
+		},
+	}
+
+	endpoints, err := provider.Records(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(endpoints) != 1 {
+		t.Fatalf("expected the record with the default status to be reported, got %d endpoints", len(endpoints))
+	}
+
+	if value, ok := endpoints[0].GetProviderSpecificProperty(statusProperty); ok {
+		t.Errorf("expected no status property, got %s", value)
+	}
+
+	// Asking for the default status explicitly is the same as not asking
+	requested := endpoint.NewEndpointWithTTL("www.example.com", "A", 300, "1.2.3.4").
+		WithProviderSpecific(statusProperty, statusDisabled)
+
+	adjusted, err := provider.AdjustEndpoints([]*Endpoint{requested})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if value, ok := adjusted[0].GetProviderSpecificProperty(statusProperty); ok {
+		t.Errorf("expected no status property, got %s", value)
+	}
+}
+
+func TestCNAMETrailingDotRoundTrip(t *testing.T) {
+	zones := []tidydns.Zone{
+		{Name: "example.com", ID: "1"},
+	}
+
+	tidy := &mockTidyDNSClient{}
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, endpoint.NewEndpointWithTTL("a.example.com", "CNAME", 300, "target.example.com"), "")
+	provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, endpoint.NewEndpointWithTTL("b.example.com", "CNAME", 300, "target.example.com."), "")
+
+	if len(tidy.createdRecords) != 2 {
+		t.Fatalf("expected 2 records to be created, got %d", len(tidy.createdRecords))
+	}
+
+	for _, record := range tidy.createdRecords {
+		if record.Destination != "target.example.com." {
+			t.Errorf("expected destination target.example.com., got %s", record.Destination)
+		}
+
+		record.ZoneName = "example.com"
+		result, _ := parseTidyRecord(&record)
+		if result.Targets[0] != "target.example.com" {
+			t.Errorf("expected target target.example.com, got %s", result.Targets[0])
+		}
+
+		// The stored record must be found for deletion by either form
+		for _, target := range []string{"target.example.com", "target.example.com."} {
+			ep := endpoint.NewEndpointWithTTL(result.DNSName, "CNAME", 300, target)
+			if found := findRecords([]tidydns.Record{record}, ep); len(found) != 1 {
+				t.Errorf("expected to find record for target %s", target)
+			}
+		}
+	}
+}
+
+func TestTXTRegistryRoundTrip(t *testing.T) {
+	zones := []tidydns.Zone{
+		{Name: "example.com", ID: "1"},
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"Heritage only", "heritage=external-dns"},
+		{"Owner", "heritage=external-dns,external-dns/owner=default"},
+		{"Owner and resource", "heritage=external-dns,external-dns/owner=cluster-1,external-dns/resource=ingress/default/web"},
+		{"Value ending with quote", "heritage=external-dns,external-dns/owner=\"quoted\""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tidy := &mockTidyDNSClient{}
+			provider := &tidyProvider{
+				tidy:         tidy,
+				zoneProvider: &mockZoneProvider{},
+			}
+
+			// External-DNS wraps registry values in quotes
+			quoted := "\"" + test.value + "\""
+			provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, endpoint.NewEndpointWithTTL("a-web.example.com", "TXT", 300, quoted), "")
+
+			if len(tidy.createdRecords) != 1 {
+				t.Fatalf("expected 1 record to be created, got %d", len(tidy.createdRecords))
+			}
+
+			record := tidy.createdRecords[0]
+			if record.Destination != test.value {
+				t.Fatalf("expected destination %s, got %s", test.value, record.Destination)
+			}
+
+			record.ZoneName = "example.com"
+			result, _ := parseTidyRecord(&record)
+			if result.Targets[0] != test.value {
+				t.Fatalf("expected target %s, got %s", test.value, result.Targets[0])
+			}
+
+			expected, err := endpoint.NewLabelsFromStringPlain(quoted)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			labels, err := endpoint.NewLabelsFromStringPlain(result.Targets[0])
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(labels) != len(expected) {
+				t.Fatalf("expected labels %v, got %v", expected, labels)
+			}
+
+			for key, value := range expected {
+				if labels[key] != value {
+					t.Errorf("expected label %s=%s, got %s", key, value, labels[key])
+				}
+			}
+		})
+	}
+}
+
 func TestParseTidyRecord(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -618,6 +2479,19 @@ func TestParseTidyRecord(t *testing.T) {
 			},
 			expected: endpoint.NewEndpointWithTTL("txt.example.com", "TXT", 300, "\"v=spf1 include:example.com ~all\""),
 		},
+		{
+			name: "A record with IPv6 destination",
+			record: tidyRecord{
+				ID:          "5",
+				Type:        "A",
+				Name:        "ipv6",
+				Destination: "2001:db8::1",
+				TTL:         "300",
+				ZoneName:    "example.com",
+				ZoneID:      "1",
+			},
+			expected: endpoint.NewEndpointWithTTL("ipv6.example.com", "AAAA", 300, "2001:db8::1"),
+		},
 		{
 			name: "Invalid TTL",
 			record: tidyRecord{
@@ -636,7 +2510,12 @@ func TestParseTidyRecord(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result := parseTidyRecord(&test.record)
+			result, err := parseTidyRecord(&test.record)
+			parseErr := &recordParseError{}
+			if (err != nil) != (test.expected == nil) || (err != nil && (!errors.As(err, &parseErr) || parseErr.reason != parseErrorInvalidTTL)) {
+				t.Errorf("expected a parse error %v, got %v", test.expected == nil, err)
+			}
+
 			if result == nil && test.expected != nil {
 				t.Errorf("expected %v, got nil", test.expected)
 			} else if result != nil && test.expected == nil {
@@ -658,6 +2537,8 @@ func TestTidyNameToFQDN(t *testing.T) {
 		expected  string
 	}{
 		{"Root domain", ".", "example.com", "example.com"},
+		{"Root domain empty", "", "example.com", "example.com"},
+		{"Root domain at", "@", "example.com", "example.com"},
 		{"Subdomain", "sub", "example.com", "sub.example.com"},
 		{"Root domain with dot", ".", "example.org", "example.org"},
 		{"Subdomain with dot", "sub", "example.org", "sub.example.org"},
@@ -673,23 +2554,52 @@ func TestTidyNameToFQDN(t *testing.T) {
 	}
 }
 
-func TestClampTTL(t *testing.T) {
+func TestApexName(t *testing.T) {
 	tests := []struct {
 		name     string
-		inputTTL int
-		expected int
+		apex     string
+		token    string
+		expected string
 	}{
-		{"TTL below minimum", 100, 300},
-		{"TTL at minimum", 300, 300},
-		{"TTL above minimum", 600, 600},
-		{"TTL zero", 0, 0},
+		{"Dot", ".", apexTokenAuto, "."},
+		{"Empty", "", apexTokenAuto, ""},
+		{"At", "@", apexTokenAuto, "@"},
+		{"Configured at", ".", "@", "@"},
+		{"Configured empty", ".", "", ""},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result := clampTTL(test.inputTTL)
-			if result != test.expected {
-				t.Errorf("expected %d, got %d", test.expected, result)
+			tidy := &mockTidyDNSClient{
+				createdRecords: []tidydns.Record{
+					{ID: "1", Type: "A", Name: test.apex, Destination: "10.0.0.1", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+				},
+			}
+
+			provider := &tidyProvider{
+				tidy:         tidy,
+				zoneProvider: &mockZoneProvider{},
+				apexToken:    test.token,
+				apexTokenSet: test.token != apexTokenAuto,
+			}
+
+			endpoints, err := provider.Records(context.Background())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(endpoints) != 1 || endpoints[0].DNSName != "example.com" {
+				t.Fatalf("expected the apex example.com, got %v", endpoints)
+			}
+
+			create := endpoint.NewEndpoint("example.com", "TXT", "apex")
+			if err := provider.ApplyChanges(context.Background(), &plan.Changes{Create: []*Endpoint{create}}); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			created := tidy.createdRecords[len(tidy.createdRecords)-1]
+			if created.Name != test.expected {
+				t.Errorf("expected the apex to be created as %q, got %q", test.expected, created.Name)
 			}
 		})
 	}
@@ -699,27 +2609,83 @@ func TestTidyfyName(t *testing.T) {
 	zones := []tidydns.Zone{
 		{Name: "example.com", ID: "1"},
 		{Name: "example.org", ID: "2"},
+		{Name: "example.io", ID: "0"},
 	}
 
 	tests := []struct {
 		name     string
 		fqdn     string
+		apexName string
 		expected string
 		zoneID   json.Number
+		ok       bool
 	}{
-		{"Root domain", "example.com", ".", "1"},
-		{"Subdomain", "sub.example.com", "sub", "1"},
-		{"Root domain org", "example.org", ".", "2"},
-		{"Subdomain org", "sub.example.org", "sub", "2"},
-		{"Non-matching domain", "example.net", "", "0"},
+		{"Root domain", "example.com", ".", ".", "1", true},
+		{"Root domain empty apex", "example.com", "", "", "1", true},
+		{"Subdomain", "sub.example.com", ".", "sub", "1", true},
+		{"Subdomain empty apex", "sub.example.com", "", "sub", "1", true},
+		{"Root domain org", "example.org", ".", ".", "2", true},
+		{"Subdomain org", "sub.example.org", ".", "sub", "2", true},
+		{"Zone with ID 0", "sub.example.io", ".", "sub", "0", true},
+		{"Non-matching domain", "example.net", ".", "", "", false},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result, zoneID := tidyfyName(zones, test.fqdn)
-			if result != test.expected || zoneID != test.zoneID {
-				t.Errorf("expected (%s, %s), got (%s, %s)", test.expected, test.zoneID, result, zoneID)
+			result, zoneID, ok := tidyfyName(newZoneIndex(zones), test.fqdn, test.apexName)
+			if result != test.expected || zoneID != test.zoneID || ok != test.ok {
+				t.Errorf("expected (%s, %s, %v), got (%s, %s, %v)", test.expected, test.zoneID, test.ok, result, zoneID, ok)
 			}
 		})
 	}
 }
+
+// Records the order of operations, with slow deletes to let creates overtake
+// them unless they wait
+type orderedClient struct {
+	*mockTidyDNSClient
+	operations []string
+}
+
+func (c *orderedClient) CreateRecord(ctx context.Context, zoneID json.Number, record *tidydns.Record) error {
+	c.record("create " + record.Type + " " + record.Name)
+	return c.mockTidyDNSClient.CreateRecord(ctx, zoneID, record)
+}
+
+func (c *orderedClient) DeleteRecord(ctx context.Context, zoneID json.Number, recordID json.Number) error {
+	time.Sleep(20 * time.Millisecond)
+	c.record("delete " + recordID.String())
+	return c.mockTidyDNSClient.DeleteRecord(ctx, zoneID, recordID)
+}
+
+func (c *orderedClient) record(operation string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.operations = append(c.operations, operation)
+}
+
+func TestApplyChangesDeletesBeforeCreates(t *testing.T) {
+	tidy := &orderedClient{mockTidyDNSClient: &mockTidyDNSClient{createdRecords: []tidydns.Record{
+		{ID: "1", Type: "A", Name: "www", Destination: "1.2.3.4", TTL: "600", ZoneName: "example.com", ZoneID: "1"},
+	}}}
+
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+		applyWorkers: 4,
+	}
+
+	changes := &plan.Changes{
+		Create: []*Endpoint{endpoint.NewEndpointWithTTL("www.example.com", "CNAME", 600, "web.example.com")},
+		Delete: []*Endpoint{endpoint.NewEndpointWithTTL("www.example.com", "A", 600, "1.2.3.4")},
+	}
+
+	if err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"delete 1", "create CNAME www"}
+	if !slices.Equal(tidy.operations, expected) {
+		t.Errorf("expected operations %v, got %v", expected, tidy.operations)
+	}
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"cmp"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+)
+
+// Parse a comma separated list of record types, which must be supported by the
+// webhook. The types are returned sorted without duplicates.
+func parseRecordTypes(value string) ([]string, error) {
+	supported := tidydns.SupportedRecordTypes()
+	recordTypes := []string{}
+
+	for _, recordType := range strings.Split(value, ",") {
+		recordType = strings.ToUpper(strings.TrimSpace(recordType))
+		if recordType == "" {
+			continue
+		}
+
+		if !slices.Contains(supported, recordType) {
+			return nil, fmt.Errorf("record type %s isn't supported, must be one of %s", recordType, strings.Join(supported, ", "))
+		}
+
+		if !slices.Contains(recordTypes, recordType) {
+			recordTypes = append(recordTypes, recordType)
+		}
+	}
+
+	slices.Sort(recordTypes)
+	return recordTypes, nil
+}
+
+// The DNS type of a Tidy record. AAAA records are created with the type of A
+// records in Tidy, and may be read back as A records with an IPv6 destination,
+// which are taken to be AAAA records.
+func recordType(record *tidyRecord) string {
+	if record.Type == "A" && strings.Contains(record.Destination, ":") {
+		return "AAAA"
+	}
+
+	return record.Type
+}
+
+// Parse the TTL of a Tidy record. Tidy may report a fractional TTL, which is
+// rounded to whole seconds, and a missing TTL, which is taken to be 0 meaning
+// the default of the zone. Whether the TTL had to be coerced is reported, so
+// it can be logged.
+func parseTTL(ttl json.Number) (int64, bool, error) {
+	if ttl == "" {
+		return 0, true, nil
+	}
+
+	if value, err := ttl.Int64(); err == nil {
+		return value, false, nil
+	}
+
+	value, err := ttl.Float64()
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid TTL %q: %w", ttl, err)
+	}
+
+	return int64(math.Round(value)), true, nil
+}
+
+// Order records by their numeric ID, which is the order they were created in
+// Tidy. IDs which aren't numbers are ordered after the numbers.
+func compareRecordIDs(a, b tidyRecord) int {
+	return compareIDs(a.ID, b.ID)
+}
+
+// Order Tidy IDs numerically, with IDs which aren't numbers after the numbers
+func compareIDs(a, b json.Number) int {
+	aID, aErr := a.Int64()
+	bID, bErr := b.Int64()
+
+	switch {
+	case aErr != nil && bErr != nil:
+		return strings.Compare(a.String(), b.String())
+	case aErr != nil:
+		return 1
+	case bErr != nil:
+		return -1
+	}
+
+	return cmp.Compare(aID, bID)
+}
+
+// Set the fields of a Tidy record from an External-DNS target. Most record
+// types store the target as the destination, while some are decomposed into
+// several fields in Tidy.
+func setRecordTarget(record *tidyRecord, target string) error {
+	switch record.Type {
+	case "TXT":
+		// For some reason external-dns wraps the value of certain TXT records
+		// with extra double quotes. This isn't supported by Tidy and it will
+		// refuse to save and removing them seemingly causes no issues for
+		// external-dns when read back.
+		record.Destination = unquote(target)
+	case "CNAME":
+		if !strings.HasSuffix(target, ".") {
+			target += "."
+		}
+
+		record.Destination = target
+	case "MX":
+		priority, host, err := parseMX(target)
+		if err != nil {
+			return err
+		}
+
+		record.Priority = priority
+		record.Destination = host + "."
+	case "SRV":
+		priority, weight, port, host, err := parseSRV(target)
+		if err != nil {
+			return err
+		}
+
+		record.Priority = priority
+		record.Weight = weight
+		record.Port = port
+		record.Destination = host + "."
+	case "SSHFP":
+		algorithm, fingerprintType, fingerprint, err := parseSSHFP(target)
+		if err != nil {
+			return err
+		}
+
+		record.SSHFPAlgorithm = algorithm
+		record.SSHFPType = fingerprintType
+		record.Destination = fingerprint
+	case "DS":
+		keyTag, algorithm, digestType, digest, err := parseDS(target)
+		if err != nil {
+			return err
+		}
+
+		record.DSKeyTag = keyTag
+		record.DSAlgorithm = algorithm
+		record.DSDigestType = digestType
+		record.Destination = digest
+	default:
+		record.Destination = target
+	}
+
+	return nil
+}
+
+// Reconstruct the External-DNS target from the fields of a Tidy record. This is
+// the reverse of setRecordTarget.
+func recordTarget(record *tidyRecord) string {
+	switch record.Type {
+	case "CNAME":
+		return strings.TrimRight(record.Destination, ".")
+	case "MX":
+		return fmt.Sprintf("%s %s", record.Priority, strings.TrimRight(record.Destination, "."))
+	case "SRV":
+		host := strings.TrimRight(record.Destination, ".")
+		return fmt.Sprintf("%s %s %s %s", record.Priority, record.Weight, record.Port, host)
+	case "SSHFP":
+		return fmt.Sprintf("%s %s %s", record.SSHFPAlgorithm, record.SSHFPType, record.Destination)
+	case "DS":
+		return fmt.Sprintf("%s %s %s %s", record.DSKeyTag, record.DSAlgorithm, record.DSDigestType, record.Destination)
+	default:
+		return record.Destination
+	}
+}
+
+// Check if a record has the given External-DNS target. The target is encoded
+// the way it would be stored in Tidy before comparing, so differences like a
+// CNAME's trailing dot or a TXT's quotes don't matter.
+func matchesTarget(record *tidyRecord, target string) bool {
+	other := tidyRecord{Type: record.Type}
+	if err := setRecordTarget(&other, target); err != nil {
+		return false
+	}
+
+	return recordTarget(&other) == recordTarget(record)
+}
+
+// Remove one pair of double quotes wrapping a value. Only the wrapping pair is
+// removed so quotes belonging to the value itself are preserved.
+func unquote(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
+
+// Prefix an MX or SRV target with the given priority unless the target already
+// includes one. Other targets are returned unchanged.
+func withPriority(recordType, target, priority string) string {
+	fields := strings.Fields(target)
+	if (recordType == "MX" && len(fields) == 1) || (recordType == "SRV" && len(fields) == 3) {
+		return priority + " " + target
+	}
+
+	return target
+}
+
+// Split an MX target formatted as "priority host" into its fields. The host is
+// returned without a trailing dot.
+func parseMX(target string) (json.Number, string, error) {
+	fields := strings.Fields(target)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("invalid MX record %q", target)
+	}
+
+	if _, err := strconv.ParseUint(fields[0], 10, 16); err != nil {
+		return "", "", fmt.Errorf("invalid MX priority %q", fields[0])
+	}
+
+	return json.Number(fields[0]), strings.TrimRight(fields[1], "."), nil
+}
+
+// Split an SRV target formatted as "priority weight port host" into its
+// fields. The host is returned without a trailing dot.
+func parseSRV(target string) (json.Number, json.Number, json.Number, string, error) {
+	fields := strings.Fields(target)
+	if len(fields) != 4 {
+		return "", "", "", "", fmt.Errorf("invalid SRV record %q", target)
+	}
+
+	names := []string{"priority", "weight", "port"}
+	for i, name := range names {
+		if _, err := strconv.ParseUint(fields[i], 10, 16); err != nil {
+			return "", "", "", "", fmt.Errorf("invalid SRV %s %q", name, fields[i])
+		}
+	}
+
+	host := strings.TrimRight(fields[3], ".")
+	return json.Number(fields[0]), json.Number(fields[1]), json.Number(fields[2]), host, nil
+}
+
+// Split an SSHFP target formatted as "algorithm type fingerprint" into its
+// fields. The fingerprint must be hex encoded and have the length given by the
+// fingerprint type when known.
+func parseSSHFP(target string) (json.Number, json.Number, string, error) {
+	fields := strings.Fields(target)
+	if len(fields) != 3 {
+		return "", "", "", fmt.Errorf("invalid SSHFP record %q", target)
+	}
+
+	if _, err := strconv.ParseUint(fields[0], 10, 8); err != nil {
+		return "", "", "", fmt.Errorf("invalid SSHFP algorithm %q", fields[0])
+	}
+
+	fingerprintType, err := strconv.ParseUint(fields[1], 10, 8)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid SSHFP fingerprint type %q", fields[1])
+	}
+
+	fingerprint, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid SSHFP fingerprint %q", fields[2])
+	}
+
+	// Fingerprint type 1 is SHA-1 and 2 is SHA-256
+	if (fingerprintType == 1 && len(fingerprint) != 20) || (fingerprintType == 2 && len(fingerprint) != 32) {
+		return "", "", "", fmt.Errorf("invalid SSHFP fingerprint length %q", fields[2])
+	}
+
+	return json.Number(fields[0]), json.Number(fields[1]), strings.ToLower(fields[2]), nil
+}
+
+// Split a DS target formatted as "keytag algorithm digesttype digest" into its
+// fields. The digest must be hex encoded and have the length given by the
+// digest type when known.
+func parseDS(target string) (json.Number, json.Number, json.Number, string, error) {
+	fields := strings.Fields(target)
+	if len(fields) != 4 {
+		return "", "", "", "", fmt.Errorf("invalid DS record %q", target)
+	}
+
+	if _, err := strconv.ParseUint(fields[0], 10, 16); err != nil {
+		return "", "", "", "", fmt.Errorf("invalid DS key tag %q", fields[0])
+	}
+
+	if _, err := strconv.ParseUint(fields[1], 10, 8); err != nil {
+		return "", "", "", "", fmt.Errorf("invalid DS algorithm %q", fields[1])
+	}
+
+	digestType, err := strconv.ParseUint(fields[2], 10, 8)
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("invalid DS digest type %q", fields[2])
+	}
+
+	digest, err := hex.DecodeString(fields[3])
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("invalid DS digest %q", fields[3])
+	}
+
+	// Digest type 1 is SHA-1, 2 is SHA-256 and 4 is SHA-384
+	digestLengths := map[uint64]int{1: 20, 2: 32, 4: 48}
+	if length, ok := digestLengths[digestType]; ok && len(digest) != length {
+		return "", "", "", "", fmt.Errorf("invalid DS digest length %q", fields[3])
+	}
+
+	return json.Number(fields[0]), json.Number(fields[1]), json.Number(fields[2]), fields[3], nil
+}
@@ -0,0 +1,459 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestParseSSHFP(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		expectErr bool
+	}{
+		{"SHA-1", "1 1 123456789abcdef67890123456789abcdef67890", false},
+		{"SHA-256", "4 2 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", false},
+		{"Missing field", "1 1", true},
+		{"Invalid algorithm", "x 1 123456789abcdef67890123456789abcdef67890", true},
+		{"Invalid type", "1 300 123456789abcdef67890123456789abcdef67890", true},
+		{"Not hex", "1 1 not-a-fingerprint", true},
+		{"Wrong length", "1 2 123456789abcdef67890123456789abcdef67890", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, _, err := parseSSHFP(test.target)
+			if test.expectErr && err == nil {
+				t.Errorf("expected error, got none")
+			} else if !test.expectErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseSSHFPLowerCase(t *testing.T) {
+	// Fingerprints are compared with those in Tidy, so upper case hex matches
+	_, _, fingerprint, err := parseSSHFP("1 1 123456789ABCDEF67890123456789ABCDEF67890")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if expected := "123456789abcdef67890123456789abcdef67890"; fingerprint != expected {
+		t.Errorf("expected %s, got %s", expected, fingerprint)
+	}
+}
+
+func TestSSHFPRoundTrip(t *testing.T) {
+	zones := []tidydns.Zone{
+		{Name: "example.com", ID: "1"},
+	}
+
+	tidy := &mockTidyDNSClient{}
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	target := "1 1 123456789abcdef67890123456789abcdef67890"
+	ep := endpoint.NewEndpointWithTTL("host.example.com", "SSHFP", 300, target, "1 1 invalid")
+	provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, ep, "")
+
+	// The invalid fingerprint is skipped
+	if len(tidy.createdRecords) != 1 {
+		t.Fatalf("expected 1 record to be created, got %d", len(tidy.createdRecords))
+	}
+
+	record := tidy.createdRecords[0]
+	if record.SSHFPAlgorithm != "1" || record.SSHFPType != "1" || record.Destination != "123456789abcdef67890123456789abcdef67890" {
+		t.Fatalf("expected decomposed SSHFP record, got %+v", record)
+	}
+
+	record.ZoneName = "example.com"
+	result, _ := parseTidyRecord(&record)
+	if result.RecordType != "SSHFP" || result.Targets[0] != target {
+		t.Errorf("expected SSHFP target %s, got %s", target, result.Targets[0])
+	}
+
+	if found := findRecords([]tidydns.Record{record}, ep); len(found) != 1 {
+		t.Errorf("expected to find the SSHFP record, got %d", len(found))
+	}
+}
+
+func TestEncryptedTXTRoundTrip(t *testing.T) {
+	zones := []tidydns.Zone{
+		{Name: "example.com", ID: "1"},
+	}
+
+	aesKey := []byte("0123456789abcdef0123456789abcdef")
+
+	// Random looking names compress badly, making a long ciphertext
+	longName := ""
+	for i := range 4 {
+		sum := sha512.Sum512([]byte{byte(i)})
+		longName += hex.EncodeToString(sum[:])
+	}
+
+	tests := []struct {
+		name     string
+		resource string
+	}{
+		{"Short", "ingress/default/web"},
+		{"Longer than a TXT string", "ingress/default/" + longName},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tidy := &mockTidyDNSClient{}
+			provider := &tidyProvider{
+				tidy:         tidy,
+				zoneProvider: &mockZoneProvider{},
+			}
+
+			// Encrypted like the TXT registry of External-DNS does it, with
+			// the base64 ciphertext wrapped in quotes
+			labels := endpoint.Labels{endpoint.OwnerLabelKey: "default", endpoint.ResourceLabelKey: test.resource}
+			target := labels.Serialize(true, true, aesKey)
+
+			ep := endpoint.NewEndpointWithTTL("a-web.example.com", "TXT", 300, target)
+			if err := provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, ep, ""); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			record := tidy.createdRecords[0]
+			if record.Destination != strings.Trim(target, "\"") {
+				t.Fatalf("expected the ciphertext to be stored as it is, got %s", record.Destination)
+			}
+
+			record.ZoneName = "example.com"
+			result, _ := parseTidyRecord(&record)
+
+			decrypted, err := endpoint.NewLabelsFromString(result.Targets[0], aesKey)
+			if err != nil {
+				t.Fatalf("expected the labels to decrypt, got %v", err)
+			}
+
+			if decrypted[endpoint.OwnerLabelKey] != "default" || decrypted[endpoint.ResourceLabelKey] != test.resource {
+				t.Errorf("expected labels %v, got %v", labels, decrypted)
+			}
+
+			// The record is found again, so it isn't created anew
+			if found := findRecords([]tidydns.Record{record}, ep); len(found) != 1 {
+				t.Errorf("expected to find the TXT record, got %d", len(found))
+			}
+		})
+	}
+}
+
+func TestParseDS(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		expectErr bool
+	}{
+		{"SHA-256", "20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D", false},
+		{"SHA-1", "2371 13 1 0123456789abcdef0123456789abcdef01234567", false},
+		{"Unknown digest type", "2371 13 9 abcd", false},
+		{"Missing field", "2371 13 2", true},
+		{"Invalid key tag", "70000 13 2 abcd", true},
+		{"Invalid algorithm", "2371 x 2 abcd", true},
+		{"Not hex", "2371 13 2 not-a-digest", true},
+		{"Wrong length", "2371 13 2 abcd", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, _, _, err := parseDS(test.target)
+			if test.expectErr && err == nil {
+				t.Errorf("expected error, got none")
+			} else if !test.expectErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDSRoundTrip(t *testing.T) {
+	zones := []tidydns.Zone{
+		{Name: "example.com", ID: "1"},
+	}
+
+	tidy := &mockTidyDNSClient{}
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	target := "20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"
+	ep := endpoint.NewEndpointWithTTL("child.example.com", "DS", 3600, target)
+	provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, ep, "")
+
+	if len(tidy.createdRecords) != 1 {
+		t.Fatalf("expected 1 record to be created, got %d", len(tidy.createdRecords))
+	}
+
+	record := tidy.createdRecords[0]
+	if record.DSKeyTag != "20326" || record.DSAlgorithm != "8" || record.DSDigestType != "2" {
+		t.Fatalf("expected decomposed DS record, got %+v", record)
+	}
+
+	record.ZoneName = "example.com"
+	result, _ := parseTidyRecord(&record)
+	if result.RecordType != "DS" || result.Targets[0] != target {
+		t.Errorf("expected DS target %s, got %s", target, result.Targets[0])
+	}
+
+	// Deletion must match the DS record by all four fields
+	if found := findRecords([]tidydns.Record{record}, ep); len(found) != 1 {
+		t.Errorf("expected to find the DS record, got %d", len(found))
+	}
+
+	other := endpoint.NewEndpointWithTTL("child.example.com", "DS", 3600, "20327 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D")
+	if found := findRecords([]tidydns.Record{record}, other); len(found) != 0 {
+		t.Errorf("expected DS record with another key tag not to match, got %d", len(found))
+	}
+}
+
+func TestParseMX(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		expectErr bool
+	}{
+		{"Valid", "10 mail.example.com", false},
+		{"Trailing dot", "10 mail.example.com.", false},
+		{"Missing priority", "mail.example.com", true},
+		{"Invalid priority", "x mail.example.com", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, host, err := parseMX(test.target)
+			if test.expectErr && err == nil {
+				t.Errorf("expected error, got none")
+			} else if !test.expectErr && (err != nil || host != "mail.example.com") {
+				t.Errorf("expected host mail.example.com and no error, got %s and %v", host, err)
+			}
+		})
+	}
+}
+
+func TestParseSRV(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		expectErr bool
+	}{
+		{"Valid", "10 5 443 host.example.com", false},
+		{"Missing field", "10 443 host.example.com", true},
+		{"Invalid weight", "10 x 443 host.example.com", true},
+		{"Invalid port", "10 5 70000 host.example.com", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, _, _, err := parseSRV(test.target)
+			if test.expectErr && err == nil {
+				t.Errorf("expected error, got none")
+			} else if !test.expectErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWithPriority(t *testing.T) {
+	tests := []struct {
+		name       string
+		recordType string
+		target     string
+		expected   string
+	}{
+		{"MX without priority", "MX", "mail.example.com", "20 mail.example.com"},
+		{"MX with priority", "MX", "10 mail.example.com", "10 mail.example.com"},
+		{"SRV without priority", "SRV", "5 443 host.example.com", "20 5 443 host.example.com"},
+		{"SRV with priority", "SRV", "10 5 443 host.example.com", "10 5 443 host.example.com"},
+		{"Other type", "A", "1.2.3.4", "1.2.3.4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := withPriority(test.recordType, test.target, "20"); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMXPriorityRoundTrip(t *testing.T) {
+	zones := []tidydns.Zone{
+		{Name: "example.com", ID: "1"},
+	}
+
+	tidy := &mockTidyDNSClient{}
+	provider := &tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	}
+
+	// The priority is taken from the property for targets without one
+	ep := endpoint.NewEndpointWithTTL("example.com", "MX", 300, "mail.example.com", "10 backup.example.com")
+	ep.SetProviderSpecificProperty(priorityProperty, "20")
+	if err := provider.createRecord(context.Background(), newZoneIndex(zones), []tidydns.Record{}, ep, ""); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(tidy.createdRecords) != 2 {
+		t.Fatalf("expected 2 records to be created, got %d", len(tidy.createdRecords))
+	}
+
+	record := tidy.createdRecords[0]
+	if record.Priority != "20" || record.Destination != "mail.example.com." {
+		t.Fatalf("expected decomposed MX record, got %+v", record)
+	}
+
+	if record := tidy.createdRecords[1]; record.Priority != "10" || record.Destination != "backup.example.com." {
+		t.Fatalf("expected decomposed MX record, got %+v", record)
+	}
+
+	record.ZoneName = "example.com"
+	result, _ := parseTidyRecord(&record)
+	if result.RecordType != "MX" || result.Targets[0] != "20 mail.example.com" {
+		t.Errorf("expected MX target 20 mail.example.com, got %s", result.Targets[0])
+	}
+
+	// Adjusting moves the priority into the targets like those read from Tidy
+	adjusted, _ := provider.AdjustEndpoints([]*Endpoint{ep})
+	if adjusted[0].Targets[0] != "10 backup.example.com" || adjusted[0].Targets[1] != "20 mail.example.com" {
+		t.Errorf("expected priorities in the targets, got %v", adjusted[0].Targets)
+	}
+
+	if _, ok := adjusted[0].GetProviderSpecificProperty(priorityProperty); ok {
+		t.Errorf("expected the priority property to be removed")
+	}
+}
+
+func TestRecordType(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   tidyRecord
+		expected string
+	}{
+		{"A record", tidyRecord{Type: "A", Destination: "10.0.0.1"}, "A"},
+		{"A record with IPv6 destination", tidyRecord{Type: "A", Destination: "2001:db8::1"}, "AAAA"},
+		{"AAAA record", tidyRecord{Type: "AAAA", Destination: "2001:db8::1"}, "AAAA"},
+		{"TXT record with colon", tidyRecord{Type: "TXT", Destination: "key:value"}, "TXT"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := recordType(&test.record); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseRecordTypes(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    []string
+		expectError bool
+	}{
+		{"Empty", "", []string{}, false},
+		{"Sorted", "TXT,A,CNAME", []string{"A", "CNAME", "TXT"}, false},
+		{"Whitespace and case", " a, aaaa ,", []string{"A", "AAAA"}, false},
+		{"Duplicates", "A,A", []string{"A"}, false},
+		{"Unsupported", "A,PTR", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recordTypes, err := parseRecordTypes(test.value)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error %v, got %v", test.expectError, err)
+			}
+
+			if !slices.Equal(recordTypes, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, recordTypes)
+			}
+		})
+	}
+}
+
+func TestCompareRecordIDs(t *testing.T) {
+	records := []tidyRecord{{ID: "b"}, {ID: "10"}, {ID: "a"}, {ID: "9"}, {ID: "100"}}
+	slices.SortFunc(records, compareRecordIDs)
+
+	ids := []string{}
+	for _, record := range records {
+		ids = append(ids, record.ID.String())
+	}
+
+	expected := []string{"9", "10", "100", "a", "b"}
+	if !slices.Equal(ids, expected) {
+		t.Errorf("expected %v, got %v", expected, ids)
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected int64
+		coerced  bool
+	}{
+		{"Number", `{"ttl": 300}`, 300, false},
+		{"String", `{"ttl": "300"}`, 300, false},
+		{"Fractional number", `{"ttl": 300.6}`, 301, true},
+		{"Fractional string", `{"ttl": "300.2"}`, 300, true},
+		{"Empty", `{"ttl": ""}`, 0, true},
+		{"Missing", `{}`, 0, true},
+		{"Exponent", `{"ttl": 3e2}`, 300, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record := tidyRecord{}
+			if err := json.Unmarshal([]byte(test.data), &record); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			ttl, coerced, err := parseTTL(record.TTL)
+			if err != nil || ttl != test.expected || coerced != test.coerced {
+				t.Errorf("expected (%d, %v), got (%d, %v, %v)", test.expected, test.coerced, ttl, coerced, err)
+			}
+		})
+	}
+}
+
+func TestParseTTLInvalid(t *testing.T) {
+	if _, _, err := parseTTL("invalid"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
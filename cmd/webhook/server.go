@@ -19,23 +19,89 @@ package main
 import (
 	"log/slog"
 	"net/http"
+	"net/http/pprof"
 	"runtime/metrics"
+	"time"
 )
 
 type Samples []metrics.Sample
 
-func serveExposed(addr string, metricsHandler http.Handler) error {
+// Serve health checks and metrics on addr. The debug handler is served under
+// /debug/ unless it's nil, and the Go profiler under /debug/pprof/ if enabled.
+func serveExposed(addr string, timeouts exposedTimeouts, handlers exposedHandlers, enablePprof bool) error {
 	slog.Debug("start webhook server on " + addr)
+	server := newExposedServer(addr, timeouts, handlers, enablePprof)
+	return server.ListenAndServe()
+}
+
+// Handlers of the server exposing health checks and metrics. Without a healthz
+// handler /healthz always returns 200, and without a metrics handler /metrics
+// isn't served.
+type exposedHandlers struct {
+	metrics http.Handler
+	healthz http.Handler
+	livez   http.Handler
+	readyz  http.Handler
+	debug   http.Handler
+}
+
+// Timeouts of the server exposing health checks and metrics, so slow scrapers
+// can't tie up connections indefinitely
+type exposedTimeouts struct {
+	read  time.Duration
+	write time.Duration
+	idle  time.Duration
+}
+
+// The least write timeout with the Go profiler served. CPU profiles and traces
+// are sampled for the seconds asked for, 30 by default, before they're written,
+// and the profiler refuses to sample for longer than the write timeout.
+const pprofWriteTimeout = time.Minute
+
+// The write timeout of the server, raised for the Go profiler when it's served
+func (t exposedTimeouts) writeTimeout(enablePprof bool) time.Duration {
+	if enablePprof && t.write > 0 {
+		return max(t.write, pprofWriteTimeout)
+	}
+
+	return t.write
+}
+
+func newExposedServer(addr string, timeouts exposedTimeouts, handlers exposedHandlers, enablePprof bool) *http.Server {
+	healthzHandler := handlers.healthz
+	if healthzHandler == nil {
+		healthzHandler = http.HandlerFunc(healthz)
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /healthz", healthz)
-	mux.Handle("GET /metrics", metricsHandler)
+	mux.Handle("GET /healthz", healthzHandler)
+	mux.Handle("GET /livez", handlers.livez)
+	mux.Handle("GET /readyz", handlers.readyz)
 
-	server := http.Server{
-		Addr:    addr,
-		Handler: mux,
+	if handlers.metrics != nil {
+		mux.Handle("GET /metrics", handlers.metrics)
 	}
 
-	return server.ListenAndServe()
+	if handlers.debug != nil {
+		mux.Handle("/debug/", handlers.debug)
+	}
+
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadTimeout:       timeouts.read,
+		ReadHeaderTimeout: timeouts.read,
+		WriteTimeout:      timeouts.writeTimeout(enablePprof),
+		IdleTimeout:       timeouts.idle,
+	}
 }
 
 func healthz(w http.ResponseWriter, req *http.Request) {
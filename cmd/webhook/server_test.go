@@ -17,9 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestHealthz(t *testing.T) {
@@ -35,3 +37,157 @@ func TestHealthz(t *testing.T) {
 		t.Errorf("Expected status OK; got %v", rec.Code)
 	}
 }
+
+func TestNewExposedServer(t *testing.T) {
+	timeouts := exposedTimeouts{
+		read:  time.Second,
+		write: 2 * time.Second,
+		idle:  time.Minute,
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := newExposedServer(":8080", timeouts, exposedHandlers{metrics: ok, livez: ok, readyz: ok}, false)
+	if server.ReadTimeout != time.Second || server.ReadHeaderTimeout != time.Second || server.WriteTimeout != 2*time.Second || server.IdleTimeout != time.Minute {
+		t.Errorf("Expected timeouts %+v, got %+v", timeouts, server)
+	}
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/livez", http.StatusOK},
+		{"/readyz", http.StatusOK},
+		{"/metrics", http.StatusOK},
+		{"/debug/domainfilter", http.StatusNotFound},
+		{"/debug/pprof/", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			server.Handler.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+			if rec.Code != test.expected {
+				t.Errorf("Expected status %d, got %d", test.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestNewExposedServerWithoutMetrics(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := newExposedServer(":8080", exposedTimeouts{}, exposedHandlers{livez: ok, readyz: ok}, false)
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/livez", http.StatusOK},
+		{"/metrics", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			server.Handler.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+			if rec.Code != test.expected {
+				t.Errorf("Expected status %d, got %d", test.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestNewExposedServerPprof(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := newExposedServer(":8080", exposedTimeouts{}, exposedHandlers{metrics: ok, livez: ok, readyz: ok}, true)
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/cmdline"} {
+		rec := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusOK, path, rec.Code)
+		}
+	}
+}
+
+func TestExposedWriteTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		write       time.Duration
+		enablePprof bool
+		expected    time.Duration
+	}{
+		{"Without profiler", 10 * time.Second, false, 10 * time.Second},
+		{"Raised for profiler", 10 * time.Second, true, time.Minute},
+		{"Longer than needed by profiler", 2 * time.Minute, true, 2 * time.Minute},
+		{"No timeout", 0, true, 0},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newExposedServer(":8080", exposedTimeouts{write: test.write}, exposedHandlers{livez: ok, readyz: ok}, test.enablePprof)
+			if server.WriteTimeout != test.expected {
+				t.Errorf("Expected write timeout %s, got %s", test.expected, server.WriteTimeout)
+			}
+		})
+	}
+}
+
+// Profiles take longer than the write timeout given, which is raised for them
+func TestNewExposedServerPprofWriteTimeout(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	timeouts := exposedTimeouts{read: time.Second, write: 100 * time.Millisecond, idle: time.Second}
+	exposed := newExposedServer(":8080", timeouts, exposedHandlers{metrics: ok, livez: ok, readyz: ok}, true)
+
+	server := httptest.NewUnstartedServer(exposed.Handler)
+	server.Config = exposed
+	server.Start()
+	defer server.Close()
+
+	res, err := server.Client().Get(server.URL + "/debug/pprof/profile?seconds=1")
+	if err != nil {
+		t.Fatalf("Expected the profile to be written, got %v", err)
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil || res.StatusCode != http.StatusOK || len(body) == 0 {
+		t.Errorf("Expected a profile, got status %d with %d bytes and error %v", res.StatusCode, len(body), err)
+	}
+}
+
+func TestNewExposedServerHealthz(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	unhealthy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	server := newExposedServer(":8080", exposedTimeouts{}, exposedHandlers{metrics: ok, healthz: unhealthy, livez: ok, readyz: ok}, false)
+
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
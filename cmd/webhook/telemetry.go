@@ -0,0 +1,48 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Time the meter and tracer providers get to push what they've buffered when
+// the webhook exits
+const telemetryShutdownTimeout = 5 * time.Second
+
+// Providers which buffer telemetry, like the OTLP exporting providers of the
+// SDK. Noop providers don't.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Shut down the providers buffering telemetry, so the metrics and spans not yet
+// pushed aren't lost when the webhook exits. It waits at most timeout.
+func shutdownTelemetry(timeout time.Duration, providers ...any) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, provider := range providers {
+		if provider, ok := provider.(shutdowner); ok {
+			if err := provider.Shutdown(ctx); err != nil {
+				slog.Warn("cannot shut down telemetry: " + err.Error())
+			}
+		}
+	}
+}
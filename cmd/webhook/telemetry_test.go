@@ -0,0 +1,46 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// Provider recording whether it was shut down within a deadline
+type fakeShutdowner struct {
+	shutdown    bool
+	hasDeadline bool
+}
+
+func (f *fakeShutdowner) Shutdown(ctx context.Context) error {
+	_, f.hasDeadline = ctx.Deadline()
+	f.shutdown = true
+	return nil
+}
+
+func TestShutdownTelemetry(t *testing.T) {
+	provider := &fakeShutdowner{}
+	shutdownTelemetry(time.Second, noop.NewMeterProvider(), provider)
+
+	if !provider.shutdown || !provider.hasDeadline {
+		t.Errorf("expected the provider to be shut down within a deadline, got %+v", provider)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tidydns
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// A version of the Tidy API, which decides the routes requested and how the
+// responses are decoded
+type APIVersion string
+
+const (
+	// The /=/ routes of Tidy answering in JSON, which all known Tidy versions
+	// serve
+	APIVersionV1 APIVersion = "v1"
+)
+
+// Routes and decoding of a version of the Tidy API. Requests and errors are
+// handled the same way for every version, so only what differs is here.
+type apiVersion interface {
+	loginURL() string
+	zonesURL() string
+	recordsURL(zoneID json.Number) string
+	createRecordURL(zoneID json.Number) string
+	deleteRecordURL(zoneID, recordID json.Number) string
+
+	decodeZones(body []byte) ([]Zone, error)
+	decodeRecords(body []byte) ([]Record, error)
+}
+
+// The API versions the client speaks
+var apiVersions = map[APIVersion]apiVersion{
+	APIVersionV1: apiV1{},
+}
+
+// The API versions the client speaks, sorted by name
+func APIVersions() []APIVersion {
+	return slices.Sorted(maps.Keys(apiVersions))
+}
+
+func lookupAPIVersion(version APIVersion) (apiVersion, error) {
+	api, ok := apiVersions[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown API version %s", version)
+	}
+
+	return api, nil
+}
+
+// The API of Tidy under the strange /=/ prefix after the base address
+type apiV1 struct{}
+
+func (apiV1) loginURL() string {
+	return "/=/login"
+}
+
+func (apiV1) zonesURL() string {
+	return "/=/zone?type=json"
+}
+
+func (apiV1) recordsURL(zoneID json.Number) string {
+	return fmt.Sprintf("/=/record_merged?type=json&zone_id=%s&showall=1", zoneID)
+}
+
+func (apiV1) createRecordURL(zoneID json.Number) string {
+	return fmt.Sprintf("/=/record/new/%s", zoneID)
+}
+
+func (apiV1) deleteRecordURL(zoneID, recordID json.Number) string {
+	return fmt.Sprintf("/=/record/%s/%s", recordID, zoneID)
+}
+
+func (apiV1) decodeZones(body []byte) ([]Zone, error) {
+	zones := []Zone{}
+	err := json.Unmarshal(body, &zones)
+	return zones, err
+}
+
+func (apiV1) decodeRecords(body []byte) ([]Record, error) {
+	records := []Record{}
+	err := json.Unmarshal(body, &records)
+	return records, err
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tidydns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// A version of the API with other routes and the results wrapped in an object
+type testAPIVersion struct{}
+
+func (testAPIVersion) loginURL() string { return "/api/v2/login" }
+func (testAPIVersion) zonesURL() string { return "/api/v2/zones" }
+
+func (testAPIVersion) recordsURL(zoneID json.Number) string {
+	return fmt.Sprintf("/api/v2/zones/%s/records", zoneID)
+}
+
+func (testAPIVersion) createRecordURL(zoneID json.Number) string {
+	return fmt.Sprintf("/api/v2/zones/%s/records", zoneID)
+}
+
+func (testAPIVersion) deleteRecordURL(zoneID, recordID json.Number) string {
+	return fmt.Sprintf("/api/v2/zones/%s/records/%s", zoneID, recordID)
+}
+
+func (testAPIVersion) decodeZones(body []byte) ([]Zone, error) {
+	wrapped := struct{ Data []Zone }{}
+	err := json.Unmarshal(body, &wrapped)
+	return wrapped.Data, err
+}
+
+func (testAPIVersion) decodeRecords(body []byte) ([]Record, error) {
+	wrapped := struct{ Data []Record }{}
+	err := json.Unmarshal(body, &wrapped)
+	return wrapped.Data, err
+}
+
+func TestAPIVersions(t *testing.T) {
+	if versions := APIVersions(); !slices.Equal(versions, []APIVersion{APIVersionV1}) {
+		t.Errorf("Expected only %s, got %v", APIVersionV1, versions)
+	}
+}
+
+func TestNewTidyDnsClientAPIVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		requested string
+		expectErr bool
+	}{
+		{"Default", nil, "GET http://tidy.invalid/=/zone?type=json", false},
+		{"Version 1", []Option{WithAPIVersion(APIVersionV1)}, "GET http://tidy.invalid/=/zone?type=json", false},
+		{"Unknown version", []Option{WithAPIVersion("v9")}, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requested := ""
+			doer := doerFunc(func(req *http.Request) (*http.Response, error) {
+				requested = req.Method + " " + req.URL.String()
+				return response(http.StatusOK, `[]`), nil
+			})
+
+			meter := noop.NewMeterProvider().Meter("test")
+			client, err := NewTidyDnsClient("http://tidy.invalid", "user", "pass", (10 * time.Second), meter, append(test.opts, WithDoer(doer))...)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("Expected error %v, got %v", test.expectErr, err)
+			}
+
+			if test.expectErr {
+				return
+			}
+
+			if _, err := client.ListZones(context.Background()); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if requested != test.requested {
+				t.Errorf("Expected %s, got %s", test.requested, requested)
+			}
+		})
+	}
+}
+
+func TestAPIVersionDispatch(t *testing.T) {
+	requested := []string{}
+	doer := doerFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.Method+" "+req.URL.Path)
+
+		switch req.URL.Path {
+		case "/api/v2/zones":
+			return response(http.StatusOK, `{"data": [{"id": 1, "name": "example.com"}]}`), nil
+		case "/api/v2/zones/1/records":
+			if req.Method == "GET" {
+				return response(http.StatusOK, `{"data": [{"id": 2, "name": "www", "zone_id": 1}]}`), nil
+			}
+		}
+
+		return response(http.StatusOK, ``), nil
+	})
+
+	client := &tidyDNSClient{
+		client:     doer,
+		baseURL:    "http://tidy.invalid",
+		authMode:   AuthModeSession,
+		counter:    mockCounter,
+		apiVersion: testAPIVersion{},
+	}
+
+	ctx := context.Background()
+
+	zones, err := client.ListZones(ctx)
+	if err != nil || len(zones) != 1 || zones[0].Name != "example.com" {
+		t.Fatalf("Expected zone example.com, got %v and %v", zones, err)
+	}
+
+	records, err := client.ListRecords(ctx, "1")
+	if err != nil || len(records) != 1 || records[0].Name != "www" {
+		t.Fatalf("Expected record www, got %v and %v", records, err)
+	}
+
+	if err := client.CreateRecord(ctx, "1", &Record{Type: "A", Name: "new", Destination: "1.2.3.4", TTL: "300"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if err := client.DeleteRecord(ctx, "1", "2"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if err := client.login(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{
+		"GET /api/v2/zones",
+		"GET /api/v2/zones/1/records",
+		"POST /api/v2/zones/1/records",
+		"DELETE /api/v2/zones/1/records/2",
+		"POST /api/v2/login",
+	}
+
+	if !slices.Equal(requested, expected) {
+		t.Errorf("Expected %v, got %v", expected, requested)
+	}
+}
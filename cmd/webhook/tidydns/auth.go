@@ -0,0 +1,102 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tidydns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// How the client authenticates to Tidy
+type AuthMode string
+
+const (
+	// Send the credentials with every request using basic auth
+	AuthModeBasic AuthMode = "basic"
+	// Log in to obtain a session cookie which is sent with the requests. The
+	// client logs in again when Tidy responds with 401.
+	AuthModeSession AuthMode = "session"
+)
+
+// Reads the current credentials of Tidy, e.g. from files kept up to date when
+// a Kubernetes secret is rotated
+type CredentialsFunc func() (username, password string, err error)
+
+// Read the credentials again with reload when Tidy responds with 401. With
+// basic auth the request is sent once more if they've changed, and with
+// session auth the new credentials are used to log in. The credentials given
+// to NewTidyDnsClient are used until then. They aren't reloaded by default.
+func WithCredentialsReload(reload CredentialsFunc) Option {
+	return func(o *clientOptions) {
+		o.credentials = reload
+	}
+}
+
+// The username and password currently used
+func (c *tidyDNSClient) credentials() (string, string) {
+	c.credentialsLock.RLock()
+	defer c.credentialsLock.RUnlock()
+	return c.username, c.password
+}
+
+// Read the credentials again, reporting whether they changed. Without a way to
+// reload them nothing changes.
+func (c *tidyDNSClient) reload() (bool, error) {
+	if c.reloadCredentials == nil {
+		return false, nil
+	}
+
+	username, password, err := c.reloadCredentials()
+	if err != nil {
+		return false, fmt.Errorf("cannot reload tidyDNS credentials: %w", err)
+	}
+
+	c.credentialsLock.Lock()
+	defer c.credentialsLock.Unlock()
+
+	changed := username != c.username || password != c.password
+	c.username, c.password = username, password
+	return changed, nil
+}
+
+// Log in to Tidy, storing the session cookie in the cookie jar of the client.
+// Concurrent requests finding the session expired only log in one at a time.
+func (c *tidyDNSClient) login(ctx context.Context) error {
+	c.loginLock.Lock()
+	defer c.loginLock.Unlock()
+
+	username, password := c.credentials()
+	data := url.Values{
+		"username": {username},
+		"password": {password},
+	}
+
+	res, err := c.do(ctx, "POST", c.api().loginURL(), []byte(data.Encode()), nil)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("login to tidyDNS failed: %s", res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tidydns
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// Serves a login form handing out the given session cookie and a zone list
+// requiring it
+func sessionHandler(t *testing.T, session *string, logins *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok {
+			t.Errorf("Expected no basic auth with session auth")
+		}
+
+		if r.URL.Path == "/=/login" {
+			*logins++
+			if r.FormValue("username") != "user" || r.FormValue("password") != "pass" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: *session})
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		cookie, err := r.Cookie("session")
+		if err != nil || cookie.Value != *session {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id": 1, "name": "example.com"}]`))
+	}
+}
+
+func TestSessionAuth(t *testing.T) {
+	session := "first"
+	logins := 0
+	server := httptest.NewServer(sessionHandler(t, &session, &logins))
+	defer server.Close()
+
+	meter := noop.NewMeterProvider().Meter("test")
+	client, err := NewTidyDnsClient(server.URL, "user", "pass", (10 * time.Second), meter, WithAuthMode(AuthModeSession))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The first request logs in and the second reuses the session
+	for range 2 {
+		if _, err := client.ListZones(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if logins != 1 {
+		t.Errorf("Expected 1 login, got %d", logins)
+	}
+
+	// An expired session makes the client log in again
+	session = "second"
+	zones, err := client.ListZones(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(zones) != 1 || logins != 2 {
+		t.Errorf("Expected 1 zone after 2 logins, got %d zones after %d logins", len(zones), logins)
+	}
+}
+
+func TestSessionAuthLoginFailure(t *testing.T) {
+	session := "first"
+	logins := 0
+	server := httptest.NewServer(sessionHandler(t, &session, &logins))
+	defer server.Close()
+
+	meter := noop.NewMeterProvider().Meter("test")
+	client, err := NewTidyDnsClient(server.URL, "user", "wrong", (10 * time.Second), meter, WithAuthMode(AuthModeSession))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := client.ListZones(context.Background()); err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+}
+
+func TestSessionAuthReloadFailing(t *testing.T) {
+	session := "first"
+	logins := 0
+	server := httptest.NewServer(sessionHandler(t, &session, &logins))
+	defer server.Close()
+
+	reload := func() (string, string, error) { return "", "", errors.New("no such file") }
+
+	meter := noop.NewMeterProvider().Meter("test")
+	client, err := NewTidyDnsClient(server.URL, "user", "pass", (10 * time.Second), meter, WithAuthMode(AuthModeSession), WithCredentialsReload(reload))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The expired session is renewed with the current credentials
+	if _, err := client.ListZones(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if logins != 1 {
+		t.Errorf("Expected 1 login, got %d", logins)
+	}
+}
+
+func TestNewTidyDnsClientUnknownAuthMode(t *testing.T) {
+	meter := noop.NewMeterProvider().Meter("test")
+	_, err := NewTidyDnsClient("http://tidy.invalid", "user", "pass", (10 * time.Second), meter, WithAuthMode("token"))
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+}
+
+func TestBasicAuthReloadCredentials(t *testing.T) {
+	tests := []struct {
+		name         string
+		reload       CredentialsFunc
+		requests     int
+		unauthorized bool
+	}{
+		{"Rotated", func() (string, string, error) { return "user", "rotated", nil }, 2, false},
+		{"Unchanged", func() (string, string, error) { return "user", "pass", nil }, 1, true},
+		{"Not reloaded", nil, 1, true},
+		{"Reload failing", func() (string, string, error) { return "", "", errors.New("no such file") }, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if username, password, _ := r.BasicAuth(); username != "user" || password != "rotated" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`[{"id": 1, "name": "example.com"}]`))
+			}
+			server := httptest.NewServer(http.HandlerFunc(handler))
+			defer server.Close()
+
+			meter := noop.NewMeterProvider().Meter("test")
+			client, err := NewTidyDnsClient(server.URL, "user", "pass", (10 * time.Second), meter, WithCredentialsReload(test.reload))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			_, err = client.ListZones(context.Background())
+			if !test.unauthorized && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			} else if test.unauthorized && !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("Expected %v, got %v", ErrUnauthorized, err)
+			}
+
+			if requests != test.requests {
+				t.Errorf("Expected %d requests, got %d", test.requests, requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tidydns
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// The validators of a response, which tell whether the response has changed
+// when sent with a later request for the same resource
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+func responseValidators(res *http.Response) validators {
+	return validators{etag: res.Header.Get("ETag"), lastModified: res.Header.Get("Last-Modified")}
+}
+
+// Reports whether there are no validators, as Tidy doesn't send any
+func (v validators) empty() bool {
+	return v.etag == "" && v.lastModified == ""
+}
+
+// The headers making a request conditional on the response having changed,
+// which is none without validators
+func (v validators) header() http.Header {
+	header := http.Header{}
+	if v.etag != "" {
+		header.Set("If-None-Match", v.etag)
+	}
+
+	if v.lastModified != "" {
+		header.Set("If-Modified-Since", v.lastModified)
+	}
+
+	return header
+}
+
+type cachedRecords struct {
+	validators validators
+	records    []Record
+}
+
+// The records of zones by zone ID, along with the validators of the response
+// they were listed in. Only records with validators are kept, as they're
+// listed in full every time otherwise.
+type recordCache struct {
+	lock  sync.Mutex
+	zones map[json.Number]cachedRecords
+}
+
+func (c *recordCache) get(zoneID json.Number) cachedRecords {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.zones[zoneID]
+}
+
+func (c *recordCache) put(zoneID json.Number, validators validators, records []Record) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if validators.empty() {
+		delete(c.zones, zoneID)
+		return
+	}
+
+	if c.zones == nil {
+		c.zones = map[json.Number]cachedRecords{}
+	}
+
+	c.zones[zoneID] = cachedRecords{validators: validators, records: slices.Clone(records)}
+}
+
+func (c *recordCache) evict(zoneID json.Number) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.zones, zoneID)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tidydns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListRecordsConditional(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       string
+		validator    string
+		conditional  string
+		expectedHits int
+	}{
+		{"ETag", "ETag", `"v1"`, "If-None-Match", 1},
+		{"Last-Modified", "Last-Modified", "Wed, 21 Oct 2026 07:28:00 GMT", "If-Modified-Since", 1},
+		{"No validators", "", "", "If-None-Match", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records := []Record{{ID: "1", Type: "A", Name: "www", Destination: "1.2.3.4"}}
+			hits := 0
+
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				if test.validator != "" && r.Header.Get(test.conditional) == test.validator {
+					hits++
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+
+				if test.header != "" {
+					w.Header().Set(test.header, test.validator)
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(records)
+			}
+			server := httptest.NewServer(http.HandlerFunc(handler))
+			defer server.Close()
+
+			client := &tidyDNSClient{
+				client:  server.Client(),
+				baseURL: server.URL,
+				counter: mockCounter,
+			}
+
+			for range 2 {
+				listed, err := client.ListRecords(context.Background(), "1")
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+
+				if len(listed) != 1 || listed[0].Destination != "1.2.3.4" {
+					t.Errorf("Expected the records of the zone, got %v", listed)
+				}
+			}
+
+			if hits != test.expectedHits {
+				t.Errorf("Expected %d conditional hits, got %d", test.expectedHits, hits)
+			}
+		})
+	}
+}
+
+func TestListRecordsChanged(t *testing.T) {
+	version := "v1"
+	destination := "1.2.3.4"
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == version {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", version)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]Record{{ID: "1", Type: "A", Name: "www", Destination: destination}})
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := &tidyDNSClient{
+		client:  server.Client(),
+		baseURL: server.URL,
+		counter: mockCounter,
+	}
+
+	if _, err := client.ListRecords(context.Background(), "1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	version = "v2"
+	destination = "5.6.7.8"
+
+	listed, err := client.ListRecords(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(listed) != 1 || listed[0].Destination != "5.6.7.8" {
+		t.Errorf("Expected the changed records, got %v", listed)
+	}
+
+	if cached := client.recordCache.get("1"); cached.validators.etag != "v2" {
+		t.Errorf("Expected the changed records to be cached, got %v", cached)
+	}
+
+	client.ForgetZone("1")
+	if cached := client.recordCache.get("1"); !cached.validators.empty() || cached.records != nil {
+		t.Errorf("Expected the records of the forgotten zone to be evicted, got %v", cached)
+	}
+}
+
+func TestListRecordsAfterChanges(t *testing.T) {
+	// Last-Modified has a resolution of a second, so the changes are made
+	// within the second the records were last modified
+	records := []Record{{ID: "1", Type: "A", Name: "www", Destination: "1.2.3.4"}}
+	modified := "Wed, 21 Oct 2026 07:28:00 GMT"
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			records = append(records, Record{ID: "2", Type: "A", Name: r.FormValue("name"), Destination: r.FormValue("destination")})
+		case "DELETE":
+			records = records[:1]
+		default:
+			if r.Header.Get("If-Modified-Since") == modified {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			w.Header().Set("Last-Modified", modified)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(records)
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := &tidyDNSClient{
+		client:  server.Client(),
+		baseURL: server.URL,
+		counter: mockCounter,
+	}
+
+	list := func(expected int) {
+		t.Helper()
+
+		listed, err := client.ListRecords(context.Background(), "1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if len(listed) != expected {
+			t.Errorf("Expected %d records, got %v", expected, listed)
+		}
+	}
+
+	list(1)
+
+	if err := client.CreateRecord(context.Background(), "1", &Record{Type: "A", Name: "api", Destination: "5.6.7.8", TTL: "300"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	list(2)
+
+	if err := client.DeleteRecord(context.Background(), "1", "2"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	list(1)
+}
@@ -46,3 +46,22 @@ func counterProvider(meter otel.Meter, name, desc string) (counter, error) {
 
 	return count, nil
 }
+
+type connectionCounter func(reused bool)
+
+// Counter of the connections requests to Tidy are sent on, telling whether the
+// connection was reused from the pool of idle connections or newly opened
+func connectionCounterProvider(meter otel.Meter, name, desc string) (connectionCounter, error) {
+	description := otel.WithDescription(desc)
+	intCounter, err := meter.Int64Counter(name, description)
+	if err != nil {
+		return nil, err
+	}
+
+	count := func(reused bool) {
+		opt := otel.WithAttributes(attribute.Key("reused").Bool(reused))
+		intCounter.Add(context.Background(), 1, opt)
+	}
+
+	return count, nil
+}
@@ -56,3 +56,14 @@ func TestCounterProviderError(t *testing.T) {
 		t.Fatalf("Expected an error, got nil")
 	}
 }
+
+// Meter recording the names of the instruments created
+type nameRecordingMeter struct {
+	noop.Meter
+	names []string
+}
+
+func (m *nameRecordingMeter) Int64Counter(name string, options ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	m.names = append(m.names, name)
+	return m.Meter.Int64Counter(name, options...)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tidydns
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+var numberType = reflect.TypeFor[json.Number]()
+
+func (r *Record) UnmarshalJSON(data []byte) error {
+	type record Record
+	return unmarshalLenient(data, (*record)(r))
+}
+
+func (z *Zone) UnmarshalJSON(data []byte) error {
+	type zone Zone
+	return unmarshalLenient(data, (*zone)(z))
+}
+
+// Tidy isn't consistent in how it encodes numbers like IDs. The same field may
+// be a JSON number in one response and a string in another, and a missing
+// number may be an empty string. A json.Number accepts both a number and a
+// string holding one, so before decoding an object into v the fields of v
+// which are numbers have surrounding space trimmed, and are left out when empty.
+func unmarshalLenient(data []byte, v any) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		// Anything but an object is left to fail as usual
+		return json.Unmarshal(data, v)
+	}
+
+	for _, name := range numberFields(v) {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			continue
+		}
+
+		if s = strings.TrimSpace(s); s == "" {
+			delete(fields, name)
+		} else {
+			fields[name], _ = json.Marshal(s)
+		}
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// The JSON names of the json.Number fields of the struct v points to
+func numberFields(v any) []string {
+	t := reflect.TypeOf(v).Elem()
+
+	names := []string{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Type != numberType {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
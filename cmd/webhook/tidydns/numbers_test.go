@@ -0,0 +1,98 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tidydns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnmarshalRecordNumbers(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected Record
+	}{
+		{"Numbers", `{"id": 12, "zone_id": 3, "ttl": 300, "status": 0}`, Record{ID: "12", ZoneID: "3", TTL: "300", Status: "0"}},
+		{"Strings", `{"id": "12", "zone_id": "3", "ttl": "300", "status": "0"}`, Record{ID: "12", ZoneID: "3", TTL: "300", Status: "0"}},
+		{"Mixed", `{"id": 12, "zone_id": "3", "ttl": "300", "status": 0}`, Record{ID: "12", ZoneID: "3", TTL: "300", Status: "0"}},
+		{"Empty strings", `{"id": "12", "priority": "", "port": " ", "name": ""}`, Record{ID: "12"}},
+		{"Surrounding space", `{"id": " 12 ", "name": " www "}`, Record{ID: "12", Name: " www "}},
+		{"Null", `{"id": 12, "priority": null}`, Record{ID: "12"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record := Record{}
+			if err := json.Unmarshal([]byte(test.data), &record); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if record != test.expected {
+				t.Errorf("Expected %+v, got %+v", test.expected, record)
+			}
+		})
+	}
+}
+
+func TestUnmarshalRecordInvalidNumber(t *testing.T) {
+	record := Record{}
+	if err := json.Unmarshal([]byte(`{"id": "abc"}`), &record); err == nil {
+		t.Errorf("Expected an error, got %+v", record)
+	}
+}
+
+func TestListMixedNumbers(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/=/zone" {
+			w.Write([]byte(`[{"id": 1, "name": "example.com"}, {"id": "2", "name": "example.org"}]`))
+			return
+		}
+
+		w.Write([]byte(`[{"id": 10, "zone_id": "1", "ttl": 300}, {"id": "11", "zone_id": 1, "ttl": "", "priority": ""}]`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := &tidyDNSClient{
+		client:  server.Client(),
+		baseURL: server.URL,
+		counter: mockCounter,
+	}
+
+	zones, err := client.ListZones(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(zones) != 2 || zones[0].ID != "1" || zones[1].ID != "2" {
+		t.Errorf("Expected zones 1 and 2, got %+v", zones)
+	}
+
+	records, err := client.ListRecords(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(records) != 2 || records[0].ID != "10" || records[1].ID != "11" || records[1].ZoneID != "1" {
+		t.Errorf("Expected records 10 and 11 in zone 1, got %+v", records)
+	}
+}
@@ -17,23 +17,47 @@ limitations under the License.
 package tidydns
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
+	"mime"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	otel "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
+// Returned when Tidy responds with 404, e.g. when deleting a record that has
+// already been removed.
+var ErrNotFound = errors.New("not found in tidyDNS")
+
+// Returned when Tidy responds with 401, as the credentials are wrong or have
+// been rotated
+var ErrUnauthorized = errors.New("unauthorized by tidyDNS")
+
 type TidyDNSClient interface {
-	ListZones() ([]Zone, error)
-	CreateRecord(zoneID json.Number, info *Record) error
-	ListRecords(zoneID json.Number) ([]Record, error)
-	DeleteRecord(zoneID json.Number, recordID json.Number) error
+	ListZones(ctx context.Context) ([]Zone, error)
+	CreateRecord(ctx context.Context, zoneID json.Number, info *Record) error
+	ListRecords(ctx context.Context, zoneID json.Number) ([]Record, error)
+	DeleteRecord(ctx context.Context, zoneID json.Number, recordID json.Number) error
 }
 
 type Record struct {
@@ -45,19 +69,97 @@ type Record struct {
 	TTL         json.Number `json:"ttl"`
 	ZoneName    string      `json:"zone_name"`
 	ZoneID      json.Number `json:"zone_id"`
+	Status      json.Number `json:"status"`
+	LocationID  json.Number `json:"location_id,omitempty"`
+
+	// Fields specific to MX and SRV records, where the destination holds the
+	// host. Weight and port are only used by SRV records.
+	Priority json.Number `json:"priority,omitempty"`
+	Weight   json.Number `json:"weight,omitempty"`
+	Port     json.Number `json:"port,omitempty"`
+
+	// Fields specific to SSHFP records, where the destination holds the
+	// fingerprint
+	SSHFPAlgorithm json.Number `json:"sshfp_algorithm,omitempty"`
+	SSHFPType      json.Number `json:"sshfp_type,omitempty"`
+
+	// Fields specific to DS records, where the destination holds the digest
+	DSKeyTag     json.Number `json:"ds_keytag,omitempty"`
+	DSAlgorithm  json.Number `json:"ds_algorithm,omitempty"`
+	DSDigestType json.Number `json:"ds_digest_type,omitempty"`
+}
+
+const (
+	RecordStatusActive   json.Number = "0"
+	RecordStatusDisabled json.Number = "1"
+)
+
+// Reports whether the record is active i.e. being served by Tidy. Records
+// without a status are considered active.
+func (r Record) IsActive() bool {
+	return r.Status == "" || r.Status == RecordStatusActive
 }
 
 type Zone struct {
-	ID   json.Number `json:"id"`
-	Name string      `json:"name"`
+	ID    json.Number `json:"id"`
+	Name  string      `json:"name"`
+	Group string      `json:"group_name"`
+	Type  string      `json:"type_name"`
+}
+
+// Reports whether the zone is a reverse (PTR) zone. Older Tidy versions don't
+// include the zone type, in which case the zone name decides.
+func (z Zone) IsReverse() bool {
+	if z.Type != "" {
+		return strings.EqualFold(z.Type, "reverse")
+	}
+
+	name := strings.TrimSuffix(z.Name, ".")
+	return strings.HasSuffix(name, ".in-addr.arpa") || strings.HasSuffix(name, ".ip6.arpa")
+}
+
+// Sends HTTP requests, like an http.Client does
+type doer interface {
+	Do(req *http.Request) (*http.Response, error)
 }
 
 type tidyDNSClient struct {
-	client   *http.Client
-	username string
-	password string
-	baseURL  string
-	counter  counter
+	client    doer
+	username  string
+	password  string
+	baseURL   string
+	userAgent string
+	authMode  AuthMode
+	loginLock sync.Mutex
+	counter   counter
+
+	// Counts whether requests reuse pooled connections, unless it's nil
+	connections connectionCounter
+
+	// Rate limited requests are retried up to retries times, waiting at most
+	// maxRetryWait before each
+	retries      int
+	maxRetryWait time.Duration
+	sleep        func(context.Context, time.Duration) error
+	rateLimited  counter
+
+	// Spans of the requests to Tidy, unless it's nil
+	tracer trace.Tracer
+
+	// Fields sent in addition to the fields of the record when creating records
+	createFields map[string]string
+
+	// The version of the Tidy API spoken, or APIVersionV1 when nil
+	apiVersion apiVersion
+
+	// The records of zones last listed, to only list them again if they've
+	// changed
+	recordCache recordCache
+
+	// Reads the credentials again when Tidy responds with 401, unless it's
+	// nil. The lock guards the username and password, which may then change.
+	reloadCredentials CredentialsFunc
+	credentialsLock   sync.RWMutex
 }
 
 type RecordType int
@@ -76,30 +178,225 @@ const (
 	RecordTypeCAA   RecordType = 10
 )
 
-func NewTidyDnsClient(baseURL, username, password string, timeout time.Duration, meter otel.Meter) (TidyDNSClient, error) {
-	counter, err := counterProvider(meter, "tidy_requests", ("Requtest made to " + baseURL))
+const DefaultMetricsPrefix = "tidy_"
+
+// Identifies the webhook in the access logs of Tidy
+const DefaultUserAgent = "external-dns-tidydns-webhook"
+
+// Options for the client which aren't required
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	metricsPrefix string
+	proxySet      bool
+	proxy         *url.URL
+	userAgent     string
+	authMode      AuthMode
+	retries       int
+	maxRetryWait  time.Duration
+	doer          doer
+	tracer        trace.TracerProvider
+	createFields  map[string]string
+	apiVersion    APIVersion
+	credentials   CredentialsFunc
+}
+
+// Prefix the names of the metric instruments, defaults to DefaultMetricsPrefix
+func WithMetricsPrefix(prefix string) Option {
+	return func(o *clientOptions) {
+		o.metricsPrefix = prefix
+	}
+}
+
+// Send requests through the proxy at the given URL regardless of the proxy
+// environment variables. A nil URL disables proxying altogether.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(o *clientOptions) {
+		o.proxySet = true
+		o.proxy = proxyURL
+	}
+}
+
+// Send the given User-Agent header with every request, defaults to
+// DefaultUserAgent
+func WithUserAgent(userAgent string) Option {
+	return func(o *clientOptions) {
+		o.userAgent = userAgent
+	}
+}
+
+// Authenticate to Tidy in the given way, defaults to AuthModeBasic
+func WithAuthMode(mode AuthMode) Option {
+	return func(o *clientOptions) {
+		o.authMode = mode
+	}
+}
+
+// Retry requests rate limited by Tidy up to the given number of times, waiting
+// as long as Tidy asks but at most maxWait. Retries are disabled by default.
+func WithRateLimitRetries(retries int, maxWait time.Duration) Option {
+	return func(o *clientOptions) {
+		o.retries = retries
+		o.maxRetryWait = maxWait
+	}
+}
+
+// Send the requests with the given doer, e.g. an http.Client, instead of a
+// client made from the timeout and the proxy option. With AuthModeSession the
+// doer has to keep the session cookie itself.
+func WithDoer(d doer) Option {
+	return func(o *clientOptions) {
+		o.doer = d
+	}
+}
+
+// Send the given form fields when creating records, for fields some Tidy
+// instances require which the client doesn't know about, e.g. view_id. The
+// fields of the record itself take precedence. No fields are added by default.
+func WithCreateFields(fields map[string]string) Option {
+	return func(o *clientOptions) {
+		o.createFields = fields
+	}
+}
+
+// Speak the given version of the Tidy API, defaults to APIVersionV1
+func WithAPIVersion(version APIVersion) Option {
+	return func(o *clientOptions) {
+		o.apiVersion = version
+	}
+}
+
+// Trace the requests to Tidy with the given provider. The HTTP requests carry
+// the trace context to Tidy. Requests aren't traced by default.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *clientOptions) {
+		o.tracer = provider
+	}
+}
+
+func NewTidyDnsClient(baseURL, username, password string, timeout time.Duration, meter otel.Meter, opts ...Option) (TidyDNSClient, error) {
+	options := &clientOptions{
+		metricsPrefix: DefaultMetricsPrefix,
+		userAgent:     DefaultUserAgent,
+		authMode:      AuthModeBasic,
+		tracer:        noop.NewTracerProvider(),
+		apiVersion:    APIVersionV1,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if options.authMode != AuthModeBasic && options.authMode != AuthModeSession {
+		return nil, fmt.Errorf("unknown auth mode %s", options.authMode)
+	}
+
+	api, err := lookupAPIVersion(options.apiVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	counter, err := counterProvider(meter, (options.metricsPrefix + "requests"), ("Requtest made to " + baseURL))
 	if err != nil {
 		return nil, err
 	}
 
+	rateLimited, err := counterProvider(meter, (options.metricsPrefix + "rate_limited_requests"), ("Requests rate limited by " + baseURL))
+	if err != nil {
+		return nil, err
+	}
+
+	connections, err := connectionCounterProvider(meter, (options.metricsPrefix + "connections"), ("Connections requests to " + baseURL + " are sent on"))
+	if err != nil {
+		return nil, err
+	}
+
+	client := options.doer
+	if client == nil {
+		if client, err = newHTTPClient(timeout, options); err != nil {
+			return nil, err
+		}
+	}
+
 	return &tidyDNSClient{
-		baseURL:  baseURL,
-		username: username,
-		password: password,
-		client: &http.Client{
-			Timeout: timeout,
-		},
-		counter: counter,
+		baseURL:   apiBaseURL(baseURL),
+		username:  username,
+		password:  password,
+		userAgent: options.userAgent,
+		authMode:  options.authMode,
+		client:    client,
+		counter:   counter,
+
+		connections: connections,
+
+		retries:      options.retries,
+		maxRetryWait: options.maxRetryWait,
+		sleep:        sleep,
+		rateLimited:  rateLimited,
+
+		tracer: options.tracer.Tracer("tidy"),
+
+		createFields: options.createFields,
+		apiVersion:   api,
+
+		reloadCredentials: options.credentials,
 	}, nil
 }
 
-func (c *tidyDNSClient) ListZones() ([]Zone, error) {
-	zones := []Zone{}
-	err := c.request("GET", "/=/zone?type=json", nil, &zones)
-	return zones, err
+func newHTTPClient(timeout time.Duration, options *clientOptions) (*http.Client, error) {
+	// Without a proxy option the proxy is taken from the environment like the
+	// default transport does
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.proxySet {
+		transport.Proxy = http.ProxyURL(options.proxy)
+	}
+
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: otelhttp.NewTransport(transport, otelhttp.WithTracerProvider(options.tracer), otelhttp.WithPropagators(propagation.TraceContext{})),
+	}
+
+	// The session cookie from logging in is kept in the jar
+	if options.authMode == AuthModeSession {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		client.Jar = jar
+	}
+
+	return client, nil
+}
+
+// The base URL the /=/ routes of Tidy are appended to. Tidy can be proxied
+// under a subpath like https://example.com/tidy, and the URL may be given with
+// a trailing slash or with the /= segment included.
+func apiBaseURL(baseURL string) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	baseURL = strings.TrimSuffix(baseURL, "/=")
+	return strings.TrimSuffix(baseURL, "/")
 }
 
-func (c *tidyDNSClient) CreateRecord(zoneID json.Number, info *Record) error {
+// The version of the Tidy API spoken by the client
+func (c *tidyDNSClient) api() apiVersion {
+	if c.apiVersion == nil {
+		return apiV1{}
+	}
+
+	return c.apiVersion
+}
+
+func (c *tidyDNSClient) ListZones(ctx context.Context) ([]Zone, error) {
+	body := json.RawMessage{}
+	if err := c.request(ctx, "GET", c.api().zonesURL(), nil, &body); err != nil {
+		return []Zone{}, err
+	}
+
+	return c.api().decodeZones(body)
+}
+
+func (c *tidyDNSClient) CreateRecord(ctx context.Context, zoneID json.Number, info *Record) error {
 	recordType, err := encodeRecordType(info.Type)
 	if err != nil {
 		return err
@@ -107,78 +404,368 @@ func (c *tidyDNSClient) CreateRecord(zoneID json.Number, info *Record) error {
 
 	ttl := info.TTL.String()
 
+	status := info.Status
+	if status == "" {
+		status = RecordStatusActive
+	}
+
+	location := info.LocationID
+	if location == "" {
+		location = "0"
+	}
+
 	data := url.Values{
 		"type":        {strconv.Itoa(int(recordType))},
 		"name":        {info.Name},
 		"ttl":         {ttl},
 		"description": {info.Description},
-		"status":      {strconv.Itoa(0)},
+		"status":      {status.String()},
 		"destination": {info.Destination},
-		"location_id": {strconv.Itoa(0)},
+		"location_id": {location.String()},
+	}
+
+	switch recordType {
+	case RecordTypeMX:
+		data.Set("priority", info.Priority.String())
+	case RecordTypeSRV:
+		data.Set("priority", info.Priority.String())
+		data.Set("weight", info.Weight.String())
+		data.Set("port", info.Port.String())
+	case RecordTypeSSHFP:
+		data.Set("sshfp_algorithm", info.SSHFPAlgorithm.String())
+		data.Set("sshfp_type", info.SSHFPType.String())
+	case RecordTypeDS:
+		data.Set("ds_keytag", info.DSKeyTag.String())
+		data.Set("ds_algorithm", info.DSAlgorithm.String())
+		data.Set("ds_digest_type", info.DSDigestType.String())
 	}
 
-	url := fmt.Sprintf("/=/record/new/%s", zoneID)
-	return c.request("POST", url, strings.NewReader(data.Encode()), nil)
+	for key, value := range c.createFields {
+		if !data.Has(key) {
+			data.Set(key, value)
+		}
+	}
+
+	// The records kept for the zone are listed again, as a list made within
+	// the second of the change could otherwise be reported unmodified. That's
+	// done even if the request failed, as it may still have reached Tidy.
+	defer c.recordCache.evict(zoneID)
+	return c.request(ctx, "POST", c.api().createRecordURL(zoneID), strings.NewReader(data.Encode()), nil)
 }
 
-func (c *tidyDNSClient) ListRecords(zoneID json.Number) ([]Record, error) {
-	records := []Record{}
-	url := fmt.Sprintf("/=/record_merged?type=json&zone_id=%s&showall=1", zoneID)
-	err := c.request("GET", url, nil, &records)
-	return records, err
+// List the records of a zone. When Tidy tags the records with an ETag or a
+// Last-Modified time they're kept, and listed again only if they've changed.
+func (c *tidyDNSClient) ListRecords(ctx context.Context, zoneID json.Number) ([]Record, error) {
+	cached := c.recordCache.get(zoneID)
+
+	body := json.RawMessage{}
+	validators, notModified, err := c.conditionalRequest(ctx, "GET", c.api().recordsURL(zoneID), nil, cached.validators, &body)
+	if err != nil {
+		return []Record{}, err
+	}
+
+	if notModified {
+		return slices.Clone(cached.records), nil
+	}
+
+	records, err := c.api().decodeRecords(body)
+	if err != nil {
+		return records, err
+	}
+
+	c.recordCache.put(zoneID, validators, records)
+	return records, nil
 }
 
-func (c *tidyDNSClient) DeleteRecord(zoneID json.Number, recordID json.Number) error {
-	url := fmt.Sprintf("/=/record/%s/%s", recordID, zoneID)
-	return c.request("DELETE", url, nil, nil)
+// Forget the records kept for a zone, e.g. as it's removed from Tidy
+func (c *tidyDNSClient) ForgetZone(zoneID json.Number) {
+	c.recordCache.evict(zoneID)
 }
 
-func (c *tidyDNSClient) request(method, url string, value io.Reader, resp any) error {
-	req, err := http.NewRequest(method, (c.baseURL + url), value)
-	if err != nil {
-		return err
+func (c *tidyDNSClient) DeleteRecord(ctx context.Context, zoneID json.Number, recordID json.Number) error {
+	// The records kept for the zone are listed again, like after creating one
+	defer c.recordCache.evict(zoneID)
+	return c.request(ctx, "DELETE", c.api().deleteRecordURL(zoneID, recordID), nil, nil)
+}
+
+// Send a request to Tidy and decode the response into resp, unless it's nil
+func (c *tidyDNSClient) request(ctx context.Context, method, url string, value io.Reader, resp any) error {
+	_, _, err := c.conditionalRequest(ctx, method, url, value, validators{}, resp)
+	return err
+}
+
+// Send a request to Tidy like request, made conditional on the response having
+// changed since the response with the given validators. When it hasn't, the
+// given validators are returned and notModified is set, otherwise the
+// validators of the new response are returned. The request is traced in a span
+// covering retries and logging in.
+func (c *tidyDNSClient) conditionalRequest(ctx context.Context, method, url string, value io.Reader, cached validators, resp any) (_ validators, notModified bool, err error) {
+	if c.tracer != nil {
+		var span trace.Span
+		ctx, span = c.tracer.Start(ctx, ("tidy " + method + " " + endpointLabel(url)), trace.WithSpanKind(trace.SpanKindClient))
+		span.SetAttributes(attribute.String("http.request.method", method), attribute.String("url.path", endpointLabel(url)))
+
+		defer func() {
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}()
 	}
 
-	req.SetBasicAuth(c.username, c.password)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Keep the body so the request can be sent again after logging in
+	var reqBody []byte
+	if value != nil {
+		var err error
+		if reqBody, err = io.ReadAll(value); err != nil {
+			return validators{}, false, err
+		}
+	}
 
-	res, err := c.client.Do(req)
+	header := cached.header()
+	res, err := c.send(ctx, method, url, reqBody, header)
 	if err != nil {
-		return err
+		return validators{}, false, err
+	}
+
+	// The session is missing or has expired, so log in and try once more. The
+	// session may just have expired, so the current credentials are used when
+	// they can't be reloaded.
+	if c.authMode == AuthModeSession && res.StatusCode == http.StatusUnauthorized {
+		res.Body.Close()
+
+		if _, err := c.reload(); err != nil {
+			slog.Warn(err.Error())
+		}
+
+		if err := c.login(ctx); err != nil {
+			return validators{}, false, err
+		}
+
+		if res, err = c.send(ctx, method, url, reqBody, header); err != nil {
+			return validators{}, false, err
+		}
+	}
+
+	// The credentials may have been rotated, so try once more if they've
+	// changed since. When they can't be reloaded the request is unauthorized
+	// like with unchanged credentials.
+	if c.authMode == AuthModeBasic && res.StatusCode == http.StatusUnauthorized {
+		changed, err := c.reload()
+		if err != nil {
+			slog.Warn(err.Error())
+		}
+
+		if changed {
+			res.Body.Close()
+
+			if res, err = c.send(ctx, method, url, reqBody, header); err != nil {
+				return validators{}, false, err
+			}
+		}
 	}
 
 	defer res.Body.Close()
 
-	// Tidy uses a strange /= prefix after the base address. Remove this first
-	urlPath, _ := strings.CutPrefix(url, "/=")
-	// Remove all parameters from the URL
-	urlPath, _, _ = strings.Cut(urlPath, "?")
+	if res.StatusCode == http.StatusNotModified && !cached.empty() {
+		return cached, true, nil
+	}
 
-	c.counter(method, urlPath, res.StatusCode)
+	if res.StatusCode == http.StatusNotFound {
+		return validators{}, false, fmt.Errorf("%w: %s", ErrNotFound, url)
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return validators{}, false, fmt.Errorf("%w: %s", ErrUnauthorized, url)
+	}
 
+	// Tidy tells why it rejects a request, e.g. a record failing validation, in
+	// the body
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("error from tidyDNS server: %s", res.Status)
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		if snippet := bodySnippet(body); snippet != "" {
+			return validators{}, false, fmt.Errorf("error from tidyDNS server: %s: %s", res.Status, snippet)
+		}
+
+		return validators{}, false, fmt.Errorf("error from tidyDNS server: %s", res.Status)
 	}
 
 	if resp == nil {
+		return responseValidators(res), false, nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return validators{}, false, err
+	}
+
+	// A gateway in front of Tidy may answer with e.g. an HTML login page and
+	// status 200 when a session expires. Report what was received instead of
+	// a confusing JSON syntax error.
+	contentType := res.Header.Get("Content-Type")
+	if !isJSON(contentType) && !json.Valid(body) {
+		return validators{}, false, fmt.Errorf("unexpected %s response from tidyDNS: %s", contentType, bodySnippet(body))
+	}
+
+	if err := json.Unmarshal(body, resp); err != nil {
+		return validators{}, false, err
+	}
+
+	return responseValidators(res), false, nil
+}
+
+// Reports whether the media type of a Content-Type header is JSON
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// The beginning of a response body on a single line for error messages
+func bodySnippet(body []byte) string {
+	const maxLength = 200
+
+	snippet := strings.Join(strings.Fields(string(body)), " ")
+	if len(snippet) > maxLength {
+		snippet = snippet[:maxLength] + "..."
+	}
+
+	return snippet
+}
+
+// Send a request to Tidy. When rate limited the request is retried after the
+// wait Tidy asks for, if retries are enabled and ctx isn't done before.
+func (c *tidyDNSClient) send(ctx context.Context, method, url string, body []byte, header http.Header) (*http.Response, error) {
+	res, err := c.do(ctx, method, url, body, header)
+	for attempt := 0; err == nil && res.StatusCode == http.StatusTooManyRequests && attempt < c.retries; attempt++ {
+		wait := retryAfter(res.Header.Get("Retry-After"), c.maxRetryWait)
+		res.Body.Close()
+
+		if err := c.sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+
+		res, err = c.do(ctx, method, url, body, header)
+	}
+
+	return res, err
+}
+
+// Wait for the given time, returning early with the error of ctx when it's done
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
 		return nil
-	} else {
-		return json.NewDecoder(res.Body).Decode(resp)
 	}
 }
 
+// Parse a Retry-After header given either in seconds or as a date. Without a
+// usable header a second is waited, and the wait never exceeds maxWait.
+func retryAfter(header string, maxWait time.Duration) time.Duration {
+	wait := time.Second
+	if seconds, err := strconv.Atoi(header); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		wait = time.Until(date)
+	}
+
+	return max(0, min(wait, maxWait))
+}
+
+// Send a single request to Tidy and count the response
+func (c *tidyDNSClient) do(ctx context.Context, method, url string, body []byte, header http.Header) (*http.Response, error) {
+	var value io.Reader
+	if body != nil {
+		value = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, (c.baseURL + url), value)
+	if err != nil {
+		return nil, err
+	}
+
+	// With session authentication the cookie jar of the client provides the
+	// credentials
+	if c.authMode != AuthModeSession {
+		req.SetBasicAuth(c.credentials())
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", c.userAgent)
+
+	// Trace whether the request gets a pooled connection or a new one
+	if c.connections != nil {
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) { c.connections(info.Reused) },
+		}
+
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	urlPath := endpointLabel(url)
+	c.counter(method, urlPath, res.StatusCode)
+	if res.StatusCode == http.StatusTooManyRequests {
+		c.rateLimited(method, urlPath, res.StatusCode)
+	}
+
+	return res, nil
+}
+
+// The endpoint of a request as used in metric labels. Tidy uses a strange /=
+// prefix after the base address, which is removed along with anything before
+// it, so the labels are the same whatever path Tidy is proxied under. The
+// parameters are removed too.
+func endpointLabel(url string) string {
+	urlPath, _, _ := strings.Cut(url, "?")
+	if _, route, found := strings.Cut(urlPath, "/=/"); found {
+		return "/" + route
+	}
+
+	return urlPath
+}
+
+// The DNS types which can be created in Tidy and their Tidy type-numbers. AAAA
+// records are stored as A records in Tidy.
+var recordTypes = map[string]RecordType{
+	"A":     RecordTypeA,
+	"AAAA":  RecordTypeA,
+	"CNAME": RecordTypeCNAME,
+	"MX":    RecordTypeMX,
+	"TXT":   RecordTypeTXT,
+	"SRV":   RecordTypeSRV,
+	"SSHFP": RecordTypeSSHFP,
+	"DS":    RecordTypeDS,
+}
+
+// The DNS types which can be created in Tidy, sorted by name
+func SupportedRecordTypes() []string {
+	return slices.Sorted(maps.Keys(recordTypes))
+}
+
 // Convert the DNS type represented by a string into a Tidy type-number
 func encodeRecordType(t string) (RecordType, error) {
-	switch t {
-	case "AAAA":
-		return RecordTypeA, nil
-	case "A":
-		return RecordTypeA, nil
-	case "CNAME":
-		return RecordTypeCNAME, nil
-	case "TXT":
-		return RecordTypeTXT, nil
-	default:
+	recordType, ok := recordTypes[t]
+	if !ok {
 		return RecordType(0), fmt.Errorf("unmapped record type %s", t)
 	}
+
+	return recordType, nil
 }
@@ -17,19 +17,44 @@ limitations under the License.
 package tidydns
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"go.opentelemetry.io/otel/metric/noop"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func mockCounter(method, url string, code int) {
 	// Do nothings
 }
 
+// Answers requests without a server
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func response(code int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: code,
+		Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
 func TestNewTidyDnsClient(t *testing.T) {
 	meter := noop.NewMeterProvider().Meter("test")
 	client, err := NewTidyDnsClient("http://example.com", "user", "pass", (10 * time.Second), meter)
@@ -42,6 +67,206 @@ func TestNewTidyDnsClient(t *testing.T) {
 	}
 }
 
+func TestNewTidyDnsClientMetricsPrefix(t *testing.T) {
+	meter := &nameRecordingMeter{}
+	_, err := NewTidyDnsClient("http://example.com", "user", "pass", (10 * time.Second), meter, WithMetricsPrefix("custom_"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{"custom_requests", "custom_rate_limited_requests", "custom_connections"}
+	if !slices.Equal(meter.names, expected) {
+		t.Fatalf("Expected instruments %v, got %v", expected, meter.names)
+	}
+}
+
+func TestConnectionReuse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	reused := []bool{}
+	client := &tidyDNSClient{
+		client:      server.Client(),
+		baseURL:     server.URL,
+		counter:     func(method, url string, code int) {},
+		connections: func(r bool) { reused = append(reused, r) },
+	}
+
+	for range 2 {
+		if _, err := client.ListZones(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	expected := []bool{false, true}
+	if !slices.Equal(reused, expected) {
+		t.Errorf("Expected connections reused %v, got %v", expected, reused)
+	}
+}
+
+func TestAPIBaseURL(t *testing.T) {
+	tests := []struct {
+		baseURL  string
+		expected string
+	}{
+		{"https://example.com", "https://example.com"},
+		{"https://example.com/", "https://example.com"},
+		{"https://example.com/=", "https://example.com"},
+		{"https://example.com/=/", "https://example.com"},
+		{"https://example.com/tidy", "https://example.com/tidy"},
+		{"https://example.com/tidy/", "https://example.com/tidy"},
+		{"https://example.com/tidy/=/", "https://example.com/tidy"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.baseURL, func(t *testing.T) {
+			if actual := apiBaseURL(test.baseURL); actual != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestEndpointLabel(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"/=/zone?type=json", "/zone"},
+		{"/=/record/1/2", "/record/1/2"},
+		{"/tidy/=/record_merged?type=json&zone_id=1", "/record_merged"},
+		{"/test", "/test"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			if actual := endpointLabel(test.url); actual != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNewTidyDnsClientProxiedBaseURL(t *testing.T) {
+	paths := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	meter := noop.NewMeterProvider().Meter("test")
+	client, err := NewTidyDnsClient(server.URL+"/tidy/", "user", "pass", (10 * time.Second), meter)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := client.ListZones(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := client.ListRecords(context.Background(), "1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{"/tidy/=/zone", "/tidy/=/record_merged"}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("Expected paths %v, got %v", expected, paths)
+	}
+}
+
+func TestNewTidyDnsClientProxy(t *testing.T) {
+	proxied := false
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		// Requests through a proxy carry the host of the origin server
+		proxied = r.URL.Host == "tidy.invalid"
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[]`))
+	}
+	proxy := httptest.NewServer(http.HandlerFunc(handler))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	meter := noop.NewMeterProvider().Meter("test")
+	client, err := NewTidyDnsClient("http://tidy.invalid", "user", "pass", (10 * time.Second), meter, WithProxy(proxyURL))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := client.ListZones(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !proxied {
+		t.Errorf("Expected request to go through the proxy")
+	}
+}
+
+func TestNewTidyDnsClientNoProxy(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://proxy.invalid")
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[]`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	meter := noop.NewMeterProvider().Meter("test")
+	client, err := NewTidyDnsClient(server.URL, "user", "pass", (10 * time.Second), meter, WithProxy(nil))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := client.ListZones(context.Background()); err != nil {
+		t.Fatalf("Expected request not to use the proxy, got %v", err)
+	}
+}
+
+func TestNewTidyDnsClientUserAgent(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected string
+	}{
+		{"Default", nil, DefaultUserAgent},
+		{"Custom", []Option{WithUserAgent("webhook/v1.0.0")}, "webhook/v1.0.0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userAgent := ""
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				userAgent = r.UserAgent()
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`[]`))
+			}
+			server := httptest.NewServer(http.HandlerFunc(handler))
+			defer server.Close()
+
+			meter := noop.NewMeterProvider().Meter("test")
+			client, err := NewTidyDnsClient(server.URL, "user", "pass", (10 * time.Second), meter, test.opts...)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if _, err := client.ListZones(context.Background()); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if userAgent != test.expected {
+				t.Errorf("Expected User-Agent %s, got %s", test.expected, userAgent)
+			}
+		})
+	}
+}
+
 func TestNewTidyDnsClientErrBadMeter(t *testing.T) {
 	meter := &badMeter{}
 	_, err := NewTidyDnsClient("http://example.com", "user", "pass", (10 * time.Second), meter)
@@ -66,7 +291,7 @@ func TestListZones(t *testing.T) {
 		counter:  mockCounter,
 	}
 
-	zones, err := client.ListZones()
+	zones, err := client.ListZones(context.Background())
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -76,6 +301,26 @@ func TestListZones(t *testing.T) {
 	}
 }
 
+func TestZoneIsReverse(t *testing.T) {
+	tests := []struct {
+		zone     Zone
+		expected bool
+	}{
+		{Zone{Name: "example.com"}, false},
+		{Zone{Name: "10.in-addr.arpa"}, true},
+		{Zone{Name: "8.b.d.0.1.0.0.2.ip6.arpa."}, true},
+		{Zone{Name: "example.com", Type: "forward"}, false},
+		{Zone{Name: "example.com", Type: "reverse"}, true},
+		{Zone{Name: "10.in-addr.arpa", Type: "forward"}, false},
+	}
+
+	for _, test := range tests {
+		if result := test.zone.IsReverse(); result != test.expected {
+			t.Errorf("Expected %v for %+v, got %v", test.expected, test.zone, result)
+		}
+	}
+}
+
 func TestCreateRecord(t *testing.T) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -99,12 +344,197 @@ func TestCreateRecord(t *testing.T) {
 		TTL:         "300",
 	}
 
-	err := client.CreateRecord("1", record)
+	err := client.CreateRecord(context.Background(), "1", record)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 }
 
+func TestCreateRecordFields(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		expected := map[string]string{"dynamic": "1", "view_id": "internal", "name": "test", "ttl": "300"}
+		for key, value := range expected {
+			if r.PostForm.Get(key) != value {
+				t.Errorf("Expected %s to be %q, got %q", key, value, r.PostForm.Get(key))
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	// The fields of the record aren't replaced
+	fields := map[string]string{"dynamic": "1", "view_id": "internal", "name": "other"}
+	client, err := NewTidyDnsClient(server.URL, "user", "pass", time.Second, noop.NewMeterProvider().Meter("test"), WithCreateFields(fields))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	record := &Record{Type: "A", Name: "test", Destination: "1.2.3.4", TTL: "300"}
+	if err := client.CreateRecord(context.Background(), "1", record); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestCreateRecordLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		location json.Number
+		expected string
+	}{
+		{"Default", "", "0"},
+		{"Given", "3", "3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+
+				if location := r.PostForm.Get("location_id"); location != test.expected {
+					t.Errorf("Expected location %s, got %s", test.expected, location)
+				}
+
+				w.WriteHeader(http.StatusOK)
+			}
+			server := httptest.NewServer(http.HandlerFunc(handler))
+			defer server.Close()
+
+			client := &tidyDNSClient{
+				client:   server.Client(),
+				baseURL:  server.URL,
+				username: "user",
+				password: "pass",
+				counter:  mockCounter,
+			}
+
+			record := &Record{
+				Type:        "A",
+				Name:        "test",
+				Destination: "1.2.3.4",
+				TTL:         "300",
+				LocationID:  test.location,
+			}
+
+			if err := client.CreateRecord(context.Background(), "1", record); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateRecordBase64Destination(t *testing.T) {
+	// Like an encrypted TXT registry record from External-DNS
+	destination := "q+7Ldf/3nM2Uy0p+Hh9/5eKq0bG6pXxsA1w/ZxgJdfE0Lr+aCk8m3Q=="
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if received := r.PostForm.Get("destination"); received != destination {
+			t.Errorf("Expected destination %s, got %s", destination, received)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := &tidyDNSClient{
+		client:  server.Client(),
+		baseURL: server.URL,
+		counter: mockCounter,
+	}
+
+	record := &Record{Type: "TXT", Name: "a-web", Destination: destination, TTL: "300"}
+	if err := client.CreateRecord(context.Background(), "1", record); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestCreateRecordSSHFP(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if r.PostForm.Get("sshfp_algorithm") != "1" || r.PostForm.Get("sshfp_type") != "2" || r.PostForm.Get("destination") != "abcd" {
+			t.Errorf("Expected SSHFP fields, got %v", r.PostForm)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := &tidyDNSClient{
+		client:   server.Client(),
+		baseURL:  server.URL,
+		username: "user",
+		password: "pass",
+		counter:  mockCounter,
+	}
+
+	record := &Record{
+		Type:           "SSHFP",
+		Name:           "host",
+		Destination:    "abcd",
+		TTL:            "300",
+		SSHFPAlgorithm: "1",
+		SSHFPType:      "2",
+	}
+
+	if err := client.CreateRecord(context.Background(), "1", record); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestCreateRecordSRV(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if r.PostForm.Get("priority") != "10" || r.PostForm.Get("weight") != "5" || r.PostForm.Get("port") != "443" || r.PostForm.Get("destination") != "host.example.com." {
+			t.Errorf("Expected SRV fields, got %v", r.PostForm)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := &tidyDNSClient{
+		client:   server.Client(),
+		baseURL:  server.URL,
+		username: "user",
+		password: "pass",
+		counter:  mockCounter,
+	}
+
+	record := &Record{
+		Type:        "SRV",
+		Name:        "_https._tcp",
+		Destination: "host.example.com.",
+		TTL:         "300",
+		Priority:    "10",
+		Weight:      "5",
+		Port:        "443",
+	}
+
+	if err := client.CreateRecord(context.Background(), "1", record); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
 func TestCreateRecordFailure(t *testing.T) {
 	client := &tidyDNSClient{}
 	record := &Record{
@@ -115,7 +545,7 @@ func TestCreateRecordFailure(t *testing.T) {
 		TTL:         "300",
 	}
 
-	err := client.CreateRecord("1", record)
+	err := client.CreateRecord(context.Background(), "1", record)
 	if err == nil {
 		t.Fatalf("Expected error, got nil")
 	}
@@ -137,7 +567,7 @@ func TestListRecords(t *testing.T) {
 		counter:  mockCounter,
 	}
 
-	records, err := client.ListRecords("1")
+	records, err := client.ListRecords(context.Background(), "1")
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -162,18 +592,305 @@ func TestDeleteRecord(t *testing.T) {
 		counter:  mockCounter,
 	}
 
-	err := client.DeleteRecord("1", "1")
+	err := client.DeleteRecord(context.Background(), "1", "1")
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 }
 
+func TestDeleteRecordNotFound(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := &tidyDNSClient{
+		client:   server.Client(),
+		baseURL:  server.URL,
+		username: "user",
+		password: "pass",
+		counter:  mockCounter,
+	}
+
+	err := client.DeleteRecord(context.Background(), "1", "1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRequestNonJSONResponse(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("<html>\n  <title>Login</title>\n</html>"))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := &tidyDNSClient{
+		client:   server.Client(),
+		baseURL:  server.URL,
+		username: "user",
+		password: "pass",
+		counter:  mockCounter,
+	}
+
+	_, err := client.ListZones(context.Background())
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+
+	expected := "unexpected text/html; charset=utf-8 response from tidyDNS: <html> <title>Login</title> </html>"
+	if err.Error() != expected {
+		t.Errorf("Expected error %q, got %q", expected, err.Error())
+	}
+}
+
+func TestBodySnippet(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"Short", "Service\n\tUnavailable", "Service Unavailable"},
+		{"Long", strings.Repeat("a", 250), strings.Repeat("a", 200) + "..."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := bodySnippet([]byte(test.body)); actual != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRequestRateLimited(t *testing.T) {
+	tests := []struct {
+		name         string
+		retries      int
+		limited      int
+		expectErr    bool
+		expectedWait []time.Duration
+	}{
+		{"Retries disabled", 0, 1, true, nil},
+		{"Retried", 3, 2, false, []time.Duration{2 * time.Second, 2 * time.Second}},
+		{"Retries exhausted", 1, 2, true, []time.Duration{2 * time.Second}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= test.limited {
+					w.Header().Set("Retry-After", "2")
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+
+				w.WriteHeader(http.StatusOK)
+			}
+			server := httptest.NewServer(http.HandlerFunc(handler))
+			defer server.Close()
+
+			limitedCount := 0
+			waits := []time.Duration{}
+			client := &tidyDNSClient{
+				client:       server.Client(),
+				baseURL:      server.URL,
+				counter:      mockCounter,
+				retries:      test.retries,
+				maxRetryWait: time.Minute,
+				sleep: func(ctx context.Context, d time.Duration) error {
+					waits = append(waits, d)
+					return nil
+				},
+				rateLimited: func(method, url string, code int) { limitedCount++ },
+			}
+
+			err := client.DeleteRecord(context.Background(), "1", "1")
+			if test.expectErr != (err != nil) {
+				t.Fatalf("Expected error %v, got %v", test.expectErr, err)
+			}
+
+			if !slices.Equal(waits, test.expectedWait) {
+				t.Errorf("Expected waits %v, got %v", test.expectedWait, waits)
+			}
+
+			if limitedCount != min(test.limited, test.retries+1) {
+				t.Errorf("Expected %d rate limited requests counted, got %d", min(test.limited, test.retries+1), limitedCount)
+			}
+		})
+	}
+}
+
+func TestRateLimitRetryCancelled(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	client := &tidyDNSClient{
+		client:       server.Client(),
+		baseURL:      server.URL,
+		counter:      mockCounter,
+		retries:      3,
+		maxRetryWait: time.Minute,
+		sleep:        sleep,
+		rateLimited:  func(method, url string, code int) {},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.DeleteRecord(ctx, "1", "1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to end with the context, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the wait to end with the context, waited %s", elapsed)
+	}
+}
+
+func TestWithDoer(t *testing.T) {
+	requested := ""
+	doer := doerFunc(func(req *http.Request) (*http.Response, error) {
+		requested = req.Method + " " + req.URL.String()
+		return response(http.StatusOK, `[{"id": 1, "name": "example.com"}]`), nil
+	})
+
+	meter := noop.NewMeterProvider().Meter("test")
+	client, err := NewTidyDnsClient("http://tidy.invalid", "user", "pass", (10 * time.Second), meter, WithDoer(doer))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	zones, err := client.ListZones(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if requested != "GET http://tidy.invalid/=/zone?type=json" {
+		t.Errorf("Expected the zones to be requested, got %s", requested)
+	}
+
+	if len(zones) != 1 || zones[0].Name != "example.com" {
+		t.Errorf("Expected zone example.com, got %v", zones)
+	}
+}
+
+func TestWithDoerErrors(t *testing.T) {
+	errTransport := errors.New("connection reset")
+
+	tests := []struct {
+		name     string
+		res      *http.Response
+		err      error
+		expected string
+	}{
+		{"Transport error", nil, errTransport, "connection reset"},
+		{"Server error", response(http.StatusInternalServerError, ""), nil, "error from tidyDNS server: 500 Internal Server Error"},
+		{"Validation error", response(http.StatusBadRequest, "Invalid destination\n"), nil, "error from tidyDNS server: 400 Bad Request: Invalid destination"},
+		{"Not found", response(http.StatusNotFound, ""), nil, "not found in tidyDNS: /=/zone?type=json"},
+		{"Invalid JSON", response(http.StatusOK, `[{`), nil, "unexpected end of JSON input"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doer := doerFunc(func(req *http.Request) (*http.Response, error) {
+				return test.res, test.err
+			})
+
+			meter := noop.NewMeterProvider().Meter("test")
+			client, err := NewTidyDnsClient("http://tidy.invalid", "user", "pass", (10 * time.Second), meter, WithDoer(doer))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			_, err = client.ListZones(context.Background())
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("Expected error %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestWithTracerProvider(t *testing.T) {
+	traceparent := ""
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		w.Write([]byte(`[]`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	meter := noop.NewMeterProvider().Meter("test")
+	client, err := NewTidyDnsClient(server.URL, "user", "pass", (10 * time.Second), meter, WithTracerProvider(provider))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := client.ListZones(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected a span for the request and the HTTP call, got %d", len(spans))
+	}
+
+	request := spans[1]
+	if request.Name() != "tidy GET /zone" {
+		t.Errorf("Expected span tidy GET /zone, got %s", request.Name())
+	}
+
+	if spans[0].Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("Expected the HTTP call to be traced within the request")
+	}
+
+	if !strings.Contains(traceparent, request.SpanContext().TraceID().String()) {
+		t.Errorf("Expected the trace context to be sent to Tidy, got %q", traceparent)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected time.Duration
+	}{
+		{"Seconds", "5", 5 * time.Second},
+		{"Missing", "", time.Second},
+		{"Invalid", "soon", time.Second},
+		{"Negative", "-5", 0},
+		{"Bounded", "3600", 30 * time.Second},
+		{"Past date", "Wed, 21 Oct 2015 07:28:00 GMT", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := retryAfter(test.header, 30*time.Second); actual != test.expected {
+				t.Errorf("Expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
 func TestRequestErrBadRequest(t *testing.T) {
 	client := &tidyDNSClient{
 		baseURL: "http://example.com",
 	}
 
-	err := client.request("GET", "/tes\t", nil, nil)
+	err := client.request(context.Background(), "GET", "/tes\t", nil, nil)
 	if err == nil {
 		t.Fatalf("Expected error, got nil")
 	}
@@ -194,12 +911,25 @@ func TestRequestErrorHandling(t *testing.T) {
 		counter:  mockCounter,
 	}
 
-	err := client.request("GET", "/test", nil, nil)
+	err := client.request(context.Background(), "GET", "/test", nil, nil)
 	if err == nil {
 		t.Fatalf("Expected error, got nil")
 	}
 }
 
+func TestSupportedRecordTypes(t *testing.T) {
+	expected := []string{"A", "AAAA", "CNAME", "DS", "MX", "SRV", "SSHFP", "TXT"}
+	if actual := SupportedRecordTypes(); !slices.Equal(actual, expected) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+
+	for _, recordType := range SupportedRecordTypes() {
+		if _, err := encodeRecordType(recordType); err != nil {
+			t.Errorf("Expected %s to be encodable, got %v", recordType, err)
+		}
+	}
+}
+
 func TestEncodeRecordType(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -210,6 +940,10 @@ func TestEncodeRecordType(t *testing.T) {
 		{"A", RecordTypeA, nil},
 		{"CNAME", RecordTypeCNAME, nil},
 		{"TXT", RecordTypeTXT, nil},
+		{"MX", RecordTypeMX, nil},
+		{"SRV", RecordTypeSRV, nil},
+		{"SSHFP", RecordTypeSSHFP, nil},
+		{"DS", RecordTypeDS, nil},
 		{"UNKNOWN", RecordType(0), errors.New("unmapped record type UNKNOWN")},
 	}
 
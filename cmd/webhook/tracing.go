@@ -0,0 +1,49 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Name of the webhook in traces
+const tracingServiceName = "external-dns-tidydns-webhook"
+
+// Make the tracer provider of the spans around provider operations and the
+// requests to Tidy. When enabled the spans are pushed over HTTP to the OTLP
+// collector given by the standard OTEL_EXPORTER_OTLP_* environment variables,
+// otherwise nothing is traced.
+func newTracerProvider(enabled bool) (trace.TracerProvider, error) {
+	if !enabled {
+		return noop.NewTracerProvider(), nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	service := resource.NewSchemaless(semconv.ServiceName(tracingServiceName))
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(service)), nil
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestNewTracerProvider(t *testing.T) {
+	disabled, err := newTracerProvider(false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, ok := disabled.(noop.TracerProvider); !ok {
+		t.Errorf("expected a noop tracer provider, got %T", disabled)
+	}
+
+	enabled, err := newTracerProvider(true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, ok := enabled.(*sdktrace.TracerProvider); !ok {
+		t.Errorf("expected an SDK tracer provider, got %T", enabled)
+	}
+}
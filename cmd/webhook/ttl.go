@@ -0,0 +1,96 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Tidy doesn't support TTL under 300 unless configured otherwise
+const defaultMinTTL = 300
+
+// Limits on the TTL of records sanitized before they're sent to Tidy. The zero
+// value applies the default minimum and leaves TTL 0 alone.
+type ttlPolicy struct {
+	// The lowest TTL allowed, or defaultMinTTL when zero
+	minTTL int
+	// The lowest TTL allowed in specific zones by zone name, taking precedence
+	// over minTTL
+	zoneMinTTL map[string]int
+	// The highest TTL allowed in all zones, or no maximum when zero
+	maxTTL int
+	// TTL 0 means the namespace default in Tidy and is left alone, unless
+	// it should be clamped to the minimum like other TTLs
+	clampZero bool
+}
+
+// Handles sanitizing TTL to Tidy. TTLs under the minimum of the zone are raised
+// to it except 0, which is the namespace default value, unless the policy
+// clamps it. Records with TTL 0 are read back with TTL 0, which External-DNS
+// takes as unset, so leaving 0 alone never makes it update the records. TTLs
+// over the maximum are lowered to it, as Tidy would otherwise truncate them and
+// External-DNS would keep updating the records.
+func (t ttlPolicy) clamp(zone string, ttl int) int {
+	minTTL := t.minimum(zone)
+
+	if ttl == 0 && !t.clampZero {
+		return ttl
+	}
+
+	ttl = max(ttl, minTTL)
+	if t.maxTTL > 0 {
+		ttl = min(ttl, t.maxTTL)
+	}
+
+	return ttl
+}
+
+// The lowest TTL allowed in a zone, falling back to the global minimum for
+// zones without their own
+func (t ttlPolicy) minimum(zone string) int {
+	if minTTL, ok := t.zoneMinTTL[zoneKey(zone)]; ok {
+		return minTTL
+	}
+
+	if t.minTTL == 0 {
+		return defaultMinTTL
+	}
+
+	return t.minTTL
+}
+
+// Parse minimum TTLs of zones formatted as a comma separated list of zone=ttl
+// pairs, e.g. example.com=60,example.org=300
+func parseZoneMinTTL(value string) (map[string]int, error) {
+	pairs, err := parseZoneValues(value, "zone minimum TTL")
+	if err != nil {
+		return nil, err
+	}
+
+	zoneMinTTL := map[string]int{}
+	for zone, ttlArg := range pairs {
+		ttl, err := strconv.Atoi(ttlArg)
+		if err != nil || ttl < 1 {
+			return nil, fmt.Errorf("invalid minimum TTL for zone %s: %q", zone, ttlArg)
+		}
+
+		zoneMinTTL[zone] = ttl
+	}
+
+	return zoneMinTTL, nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestClampTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ttlPolicy
+		inputTTL int
+		expected int
+	}{
+		{"TTL below minimum", ttlPolicy{}, 100, 300},
+		{"TTL at minimum", ttlPolicy{}, 300, 300},
+		{"TTL above minimum", ttlPolicy{}, 600, 600},
+		{"TTL zero", ttlPolicy{}, 0, 0},
+		{"TTL below custom minimum", ttlPolicy{minTTL: 60}, 30, 60},
+		{"TTL above custom minimum", ttlPolicy{minTTL: 60}, 120, 120},
+		{"TTL zero clamped", ttlPolicy{clampZero: true}, 0, 300},
+		{"TTL zero clamped to custom minimum", ttlPolicy{minTTL: 60, clampZero: true}, 0, 60},
+		{"TTL below minimum with zero clamped", ttlPolicy{clampZero: true}, 100, 300},
+		{"TTL below zone minimum", ttlPolicy{zoneMinTTL: map[string]int{"example.com": 60}}, 30, 60},
+		{"TTL above zone minimum", ttlPolicy{zoneMinTTL: map[string]int{"example.com": 60}}, 120, 120},
+		{"Other zone falls back", ttlPolicy{minTTL: 600, zoneMinTTL: map[string]int{"example.org": 60}}, 120, 600},
+		{"TTL above maximum", ttlPolicy{maxTTL: 604800}, 1209600, 604800},
+		{"TTL at maximum", ttlPolicy{maxTTL: 604800}, 604800, 604800},
+		{"TTL below maximum", ttlPolicy{maxTTL: 604800}, 3600, 3600},
+		{"TTL below minimum with maximum", ttlPolicy{maxTTL: 604800}, 100, 300},
+		{"TTL zero with maximum", ttlPolicy{maxTTL: 604800}, 0, 0},
+		{"TTL zero clamped with maximum", ttlPolicy{maxTTL: 3600, clampZero: true}, 0, 300},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := test.policy.clamp("example.com", test.inputTTL)
+			if result != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, result)
+			}
+		})
+	}
+}
+
+// Zone names are compared regardless of case and a trailing dot
+func TestZoneMinimumTTL(t *testing.T) {
+	policy := ttlPolicy{zoneMinTTL: map[string]int{"example.com": 60}}
+
+	for _, zone := range []string{"example.com", "Example.COM", "example.com."} {
+		if minimum := policy.minimum(zone); minimum != 60 {
+			t.Errorf("expected minimum 60 for %s, got %d", zone, minimum)
+		}
+	}
+}
+
+func TestParseZoneMinTTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		expected  map[string]int
+		expectErr bool
+	}{
+		{"Empty", "", map[string]int{}, false},
+		{"Single zone", "example.com=60", map[string]int{"example.com": 60}, false},
+		{"Multiple zones", "example.com=60, example.org.=300", map[string]int{"example.com": 60, "example.org": 300}, false},
+		{"Upper case zone", "Example.COM=60", map[string]int{"example.com": 60}, false},
+		{"Missing TTL", "example.com", nil, true},
+		{"Missing zone", "=60", nil, true},
+		{"Invalid TTL", "example.com=soon", nil, true},
+		{"Zero TTL", "example.com=0", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parseZoneMinTTL(test.value)
+			if test.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+
+			if fmt.Sprint(result) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Information about the running build, as recorded by the Go toolchain
+type buildInfo struct {
+	version   string
+	commit    string
+	modified  bool
+	goVersion string
+}
+
+// Read the module version, VCS commit and Go version embedded in the binary.
+// Values the toolchain didn't record are reported as unknown.
+func readBuildInfo() buildInfo {
+	info := buildInfo{
+		version:   "unknown",
+		commit:    "unknown",
+		goVersion: "unknown",
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	if build.Main.Version != "" {
+		info.version = build.Main.Version
+	}
+
+	if build.GoVersion != "" {
+		info.goVersion = build.GoVersion
+	}
+
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.commit = setting.Value
+		case "vcs.modified":
+			info.modified = setting.Value == "true"
+		}
+	}
+
+	return info
+}
+
+func (b buildInfo) String() string {
+	commit := b.commit
+	if b.modified {
+		commit += " (modified)"
+	}
+
+	return fmt.Sprintf("version: %s, commit: %s, go: %s", b.version, commit, b.goVersion)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestReadBuildInfo(t *testing.T) {
+	info := readBuildInfo()
+	if info.goVersion != runtime.Version() {
+		t.Errorf("expected go version %s, got %s", runtime.Version(), info.goVersion)
+	}
+}
+
+func TestBuildInfoString(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     buildInfo
+		expected string
+	}{
+		{
+			name:     "Release",
+			info:     buildInfo{version: "v1.0.0", commit: "abc123", goVersion: "go1.23.1"},
+			expected: "version: v1.0.0, commit: abc123, go: go1.23.1",
+		},
+		{
+			name:     "Modified",
+			info:     buildInfo{version: "(devel)", commit: "abc123", modified: true, goVersion: "go1.23.1"},
+			expected: "version: (devel), commit: abc123 (modified), go: go1.23.1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.info.String(); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
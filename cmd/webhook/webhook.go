@@ -0,0 +1,473 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+	"go.opentelemetry.io/otel/attribute"
+	otel "go.opentelemetry.io/otel/metric"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+const headerKey = "Content-Type"
+const mediaTypePrefix = "application/external.dns.webhook+json;version="
+const defaultVersion = "1"
+const headerValue = mediaTypePrefix + defaultVersion
+
+// Versions of the webhook protocol supported, in order of preference
+var supportedVersions = []string{defaultVersion}
+
+// The webhook implements the HTTP API External-DNS uses to talk to a provider.
+// It's the same API as the one served by api.StartHTTPApi from External-DNS,
+// but owning it lets us attach our own middleware.
+type tidyWebhook struct {
+	provider    Provider
+	recordTypes []string
+	serving     atomic.Bool
+
+	// Changes are abandoned when applying them takes longer, unless it's 0
+	applyTimeout time.Duration
+
+	// Request bodies larger than this are refused, unless it's 0
+	maxRequestBytes int64
+
+	// Log the client address given by proxies in the request headers rather
+	// than the address of the proxy
+	trustProxy bool
+
+	// Set once the records of all zones have been listed at startup
+	prefetched atomic.Bool
+}
+
+func newWebhook(provider Provider) *tidyWebhook {
+	return &tidyWebhook{
+		provider:    provider,
+		recordTypes: tidydns.SupportedRecordTypes(),
+	}
+}
+
+// Serve the webhook API on addr. Every request is counted by method, path and
+// status code using the given meter and metric name prefix.
+func serveWebhook(webhook *tidyWebhook, addr string, readTimeout, writeTimeout time.Duration, meter otel.Meter, metricsPrefix string) error {
+	slog.Debug("start webhook server on " + addr)
+	handler, err := webhook.handler(meter, metricsPrefix)
+	if err != nil {
+		return err
+	}
+
+	server := http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	webhook.serving.Store(true)
+	defer webhook.serving.Store(false)
+
+	return server.Serve(listener)
+}
+
+// Liveness check reporting whether the webhook API is serving External-DNS
+func (w *tidyWebhook) livez(resp http.ResponseWriter, req *http.Request) {
+	if !w.serving.Load() {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	resp.WriteHeader(http.StatusOK)
+}
+
+// Readiness check reporting whether the webhook API is serving and the records
+// of all zones have been listed from Tidy
+func (w *tidyWebhook) readyz(resp http.ResponseWriter, req *http.Request) {
+	if !w.serving.Load() || !w.prefetched.Load() {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	resp.WriteHeader(http.StatusOK)
+}
+
+// Negotiate with External-DNS by returning the domain filter. The protocol
+// version requested by External-DNS is echoed back if supported.
+func (w *tidyWebhook) negociate(resp http.ResponseWriter, req *http.Request) {
+	version, ok := negotiateVersion(req.Header.Get("Accept"))
+	if !ok {
+		slog.Error("unsupported webhook protocol requested: " + req.Header.Get("Accept"))
+		resp.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
+	// The record types managed by the webhook are only advertised on request,
+	// as External-DNS doesn't know about them
+	var negotiation any = w.provider.GetDomainFilter()
+	if req.URL.Query().Get("recordtypes") == "true" {
+		extended, err := withRecordTypes(negotiation, w.recordTypes)
+		if err != nil {
+			slog.Error(err.Error())
+			resp.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		negotiation = extended
+	}
+
+	resp.Header().Set(headerKey, mediaTypePrefix+version)
+	if err := json.NewEncoder(resp).Encode(negotiation); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// The routes of the webhook API External-DNS calls, behind the middleware
+// logging and counting the requests
+func (w *tidyWebhook) handler(meter otel.Meter, metricsPrefix string) (http.Handler, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", w.negociate)
+	mux.HandleFunc("GET /records", w.records)
+	mux.HandleFunc("POST /records", w.applyChanges)
+	mux.HandleFunc("POST /adjustendpoints", w.adjustEndpoints)
+
+	return countRequests(meter, metricsPrefix, mux, logRequests(w.trustProxy, mux))
+}
+
+// Extend the negotiated domain filter with the record types managed by the
+// webhook in the field recordTypes
+func withRecordTypes(domainFilter any, recordTypes []string) (map[string]any, error) {
+	data, err := json.Marshal(domainFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	extended := map[string]any{}
+	if err := json.Unmarshal(data, &extended); err != nil {
+		return nil, err
+	}
+
+	extended["recordTypes"] = recordTypes
+	return extended, nil
+}
+
+// Pick the protocol version from an Accept header. Without any webhook media
+// type in the header the default version is used.
+func negotiateVersion(accept string) (string, bool) {
+	requested := false
+	for _, mediaType := range strings.Split(accept, ",") {
+		version, found := strings.CutPrefix(strings.TrimSpace(mediaType), mediaTypePrefix)
+		if !found {
+			continue
+		}
+
+		requested = true
+		if slices.Contains(supportedVersions, version) {
+			return version, true
+		}
+	}
+
+	if requested {
+		return "", false
+	}
+
+	return defaultVersion, true
+}
+
+func (w *tidyWebhook) records(resp http.ResponseWriter, req *http.Request) {
+	records, err := w.provider.Records(req.Context())
+	if err != nil {
+		slog.Error(err.Error())
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set(headerKey, headerValue)
+	if err := json.NewEncoder(resp).Encode(records); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+func (w *tidyWebhook) applyChanges(resp http.ResponseWriter, req *http.Request) {
+	body, ok := w.readBody(resp, req)
+	if !ok {
+		return
+	}
+
+	changes := plan.Changes{}
+	if err := json.Unmarshal(body, &changes); err != nil {
+		slog.Error(err.Error())
+		badJSON(resp, err)
+		return
+	}
+
+	// The work is bounded by the lifetime of the response, so it doesn't go
+	// on after the server has stopped waiting for it
+	ctx := req.Context()
+	if w.applyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.applyTimeout)
+		defer cancel()
+	}
+
+	if err := w.provider.ApplyChanges(ctx, &changes); errors.Is(err, errNotLeader) {
+		slog.Info(err.Error())
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		applyFailed(resp, err)
+		return
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// An error applying changes, as told in the response to External-DNS. Errors
+// of changes to an endpoint tell which endpoint it was.
+type applyError struct {
+	Change     string `json:"change,omitempty"`
+	DNSName    string `json:"dnsName,omitempty"`
+	RecordType string `json:"recordType,omitempty"`
+	Error      string `json:"error"`
+}
+
+// Answer a request whose changes failed with the errors of the changes, which
+// are logged as well, so operators can tell which records Tidy rejected and
+// why
+func applyFailed(resp http.ResponseWriter, err error) {
+	applyErrors := []applyError{}
+	for _, err := range flattenErrors(err) {
+		if changeErr := (*changeError)(nil); errors.As(err, &changeErr) {
+			slog.Error("change failed", "change", changeErr.change, "dns_name", changeErr.dnsName, "record_type", changeErr.recordType, "error", changeErr.err.Error())
+			applyErrors = append(applyErrors, applyError{changeErr.change, changeErr.dnsName, changeErr.recordType, changeErr.err.Error()})
+			continue
+		}
+
+		slog.Error(err.Error())
+		applyErrors = append(applyErrors, applyError{Error: err.Error()})
+	}
+
+	resp.Header().Set(headerKey, "application/json")
+	resp.WriteHeader(http.StatusInternalServerError)
+	if err := json.NewEncoder(resp).Encode(map[string][]applyError{"errors": applyErrors}); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// The errors joined in an error, with the errors joined in those in turn
+func flattenErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	errs := []error{}
+	for _, err := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(err)...)
+	}
+
+	return errs
+}
+
+func (w *tidyWebhook) adjustEndpoints(resp http.ResponseWriter, req *http.Request) {
+	body, ok := w.readBody(resp, req)
+	if !ok {
+		return
+	}
+
+	endpoints := []*Endpoint{}
+	if err := json.Unmarshal(body, &endpoints); err != nil {
+		slog.Error(err.Error())
+		badJSON(resp, err)
+		return
+	}
+
+	endpoints, err := w.provider.AdjustEndpoints(endpoints)
+	if err != nil {
+		slog.Error(err.Error())
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set(headerKey, headerValue)
+	if err := json.NewEncoder(resp).Encode(endpoints); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// Read the body of a request, answering the request when it can't be read. A
+// body larger than maxRequestBytes is refused before all of it is read, so it
+// can't exhaust the memory of the webhook.
+func (w *tidyWebhook) readBody(resp http.ResponseWriter, req *http.Request) ([]byte, bool) {
+	if w.maxRequestBytes > 0 {
+		req.Body = http.MaxBytesReader(resp, req.Body, w.maxRequestBytes)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+		slog.Error(err.Error())
+		http.Error(resp, fmt.Sprintf("request body larger than %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+		return nil, false
+	} else if err != nil {
+		slog.Error(err.Error())
+		resp.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return body, true
+}
+
+// Answer a request with a body which isn't the JSON expected. It's the client's
+// fault, so External-DNS shouldn't retry it, and the reason is told in the body.
+func badJSON(resp http.ResponseWriter, err error) {
+	http.Error(resp, ("invalid JSON: " + err.Error()), http.StatusBadRequest)
+}
+
+// Wraps a ResponseWriter to remember the status code and the size of the body
+// written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Middleware logging every request made to the webhook by External-DNS, giving
+// an audit trail of the changes it asked for. The client is logged as given by
+// proxies in front of the webhook when they're trusted.
+func logRequests(trustProxy bool, next http.Handler) http.Handler {
+	handler := func(resp http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: resp, status: http.StatusOK}
+		next.ServeHTTP(recorder, req)
+
+		slog.Info("webhook request",
+			"client", clientAddress(req, trustProxy),
+			"method", req.Method,
+			"path", req.URL.Path,
+			"status", recorder.status,
+			"duration", time.Since(start),
+			"request_bytes", req.ContentLength,
+			"response_bytes", recorder.size,
+		)
+	}
+
+	return http.HandlerFunc(handler)
+}
+
+// The address of the client making a request. Behind trusted proxies it's the
+// first address of the Forwarded header, or else of the X-Forwarded-For or
+// X-Real-IP header, which is the client the first proxy was reached by.
+func clientAddress(req *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := req.Header.Get("Forwarded"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			for _, param := range strings.Split(first, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.EqualFold(name, "for") && value != "" {
+					return stripPort(strings.Trim(value, `"`))
+				}
+			}
+		}
+
+		if forwardedFor := req.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+			first, _, _ := strings.Cut(forwardedFor, ",")
+			return stripPort(strings.TrimSpace(first))
+		}
+
+		if realIP := req.Header.Get("X-Real-IP"); realIP != "" {
+			return stripPort(strings.TrimSpace(realIP))
+		}
+	}
+
+	return stripPort(req.RemoteAddr)
+}
+
+// Remove the port from an address, along with the brackets around IPv6
+// addresses. Addresses without a port are returned as they are.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// Middleware counting the requests made to the webhook by External-DNS by the
+// route in routes serving them
+func countRequests(meter otel.Meter, metricsPrefix string, routes *http.ServeMux, next http.Handler) (http.Handler, error) {
+	description := otel.WithDescription("Requests made to the webhook by External-DNS")
+	intCounter, err := meter.Int64Counter((metricsPrefix + "webhook_requests"), description)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := func(resp http.ResponseWriter, req *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: resp, status: http.StatusOK}
+		next.ServeHTTP(recorder, req)
+
+		opt := otel.WithAttributes(
+			attribute.Key("method").String(req.Method),
+			attribute.Key("endpoint").String(requestRoute(routes, req)),
+			attribute.Key("code").Int(recorder.status),
+		)
+
+		intCounter.Add(req.Context(), 1, opt)
+	}
+
+	return http.HandlerFunc(handler), nil
+}
+
+// The path of the pattern routing a request, or other for requests which aren't
+// routed, so labelling metrics by it doesn't add a series per path requested
+func requestRoute(routes *http.ServeMux, req *http.Request) string {
+	_, pattern := routes.Handler(req)
+	if pattern == "" {
+		return "other"
+	}
+
+	if _, path, found := strings.Cut(pattern, " "); found {
+		pattern = path
+	}
+
+	return strings.TrimSuffix(pattern, "{$}")
+}
@@ -0,0 +1,560 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestWebhookHandlers(t *testing.T) {
+	webhook := newWebhook(&tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+	})
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		method   string
+		body     string
+		expected int
+	}{
+		{"Negotiate", webhook.negociate, "GET", "", http.StatusOK},
+		{"Records", webhook.records, "GET", "", http.StatusOK},
+		{"Apply changes", webhook.applyChanges, "POST", `{"Create": []}`, http.StatusNoContent},
+		{"Apply changes bad JSON", webhook.applyChanges, "POST", `{`, http.StatusBadRequest},
+		{"Adjust endpoints", webhook.adjustEndpoints, "POST", `[]`, http.StatusOK},
+		{"Adjust endpoints bad JSON", webhook.adjustEndpoints, "POST", `[`, http.StatusBadRequest},
+		{"Apply changes wrong JSON type", webhook.applyChanges, "POST", `[]`, http.StatusBadRequest},
+		{"Adjust endpoints wrong JSON type", webhook.adjustEndpoints, "POST", `{"dnsName": "www.example.com"}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/", strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			test.handler(rec, req)
+
+			if rec.Code != test.expected {
+				t.Errorf("expected status %d, got %d", test.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestBadJSONMessage(t *testing.T) {
+	webhook := newWebhook(&tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+	})
+
+	for _, handler := range []http.HandlerFunc{webhook.applyChanges, webhook.adjustEndpoints} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("POST", "/", strings.NewReader(`{"Create": [`)))
+
+		if rec.Code != http.StatusBadRequest || !strings.HasPrefix(rec.Body.String(), "invalid JSON: unexpected end of JSON input") {
+			t.Errorf("expected a bad request telling why, got %d %q", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestMaxRequestBytes(t *testing.T) {
+	webhook := newWebhook(&tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+	})
+	webhook.maxRequestBytes = 16
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		body     string
+		expected int
+	}{
+		{"Apply changes", webhook.applyChanges, `{"Create": []}`, http.StatusNoContent},
+		{"Apply changes too large", webhook.applyChanges, `{"Create": [], "Delete": []}`, http.StatusRequestEntityTooLarge},
+		{"Adjust endpoints", webhook.adjustEndpoints, `[]`, http.StatusOK},
+		{"Adjust endpoints too large", webhook.adjustEndpoints, `[` + strings.Repeat(" ", 16) + `]`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			test.handler(rec, httptest.NewRequest("POST", "/", strings.NewReader(test.body)))
+
+			if rec.Code != test.expected {
+				t.Errorf("expected status %d, got %d", test.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestApplyChangesNotLeader(t *testing.T) {
+	webhook := newWebhook(&leaderOnlyProvider{
+		Provider: &tidyProvider{
+			tidy:         &mockTidyDNSClient{},
+			zoneProvider: &mockZoneProvider{},
+		},
+		isLeader: func() bool { return false },
+	})
+
+	rec := httptest.NewRecorder()
+	webhook.applyChanges(rec, httptest.NewRequest("POST", "/records", strings.NewReader(`{"Create": []}`)))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestApplyChangesErrorBody(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "old", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+		},
+	}
+
+	webhook := newWebhook(&tidyProvider{
+		tidy:         &failingCreateClient{tidy, "5.6.7.8"},
+		zoneProvider: &mockZoneProvider{},
+	})
+
+	body := `{"Create": [{"dnsName": "new.example.com", "recordType": "A", "targets": ["5.6.7.8"]}, {"dnsName": "www.example.org", "recordType": "A", "targets": ["1.2.3.4"]}], "Delete": [{"dnsName": "old.example.com", "recordType": "A", "targets": ["1.2.3.4"]}]}`
+	rec := httptest.NewRecorder()
+	webhook.applyChanges(rec, httptest.NewRequest("POST", "/records", strings.NewReader(body)))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+	}
+
+	response := map[string][]applyError{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("expected a JSON body, got %q", rec.Body.String())
+	}
+
+	expected := []applyError{
+		{"create", "new.example.com", "A", "create failed"},
+		{"create", "www.example.org", "A", "endpoint www.example.org has no managed zone"},
+	}
+
+	errs := response["errors"]
+	slices.SortFunc(errs, func(a, b applyError) int { return strings.Compare(a.DNSName, b.DNSName) })
+	if !slices.Equal(errs, expected) {
+		t.Errorf("expected errors %v, got %v", expected, errs)
+	}
+}
+
+func TestFlattenErrors(t *testing.T) {
+	first, second, third := errors.New("first"), errors.New("second"), errors.New("third")
+
+	errs := flattenErrors(errors.Join(first, errors.Join(second, third)))
+	if !slices.Equal(errs, []error{first, second, third}) {
+		t.Errorf("expected the joined errors, got %v", errs)
+	}
+
+	if errs := flattenErrors(first); !slices.Equal(errs, []error{first}) {
+		t.Errorf("expected the error itself, got %v", errs)
+	}
+}
+
+// Provider blocking in ApplyChanges until its context is done
+type blockingProvider struct {
+	Provider
+}
+
+func (p *blockingProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestApplyChangesTimeout(t *testing.T) {
+	webhook := newWebhook(&blockingProvider{})
+	webhook.applyTimeout = 10 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	webhook.applyChanges(rec, httptest.NewRequest("POST", "/records", strings.NewReader(`{"Create": []}`)))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+	}
+}
+
+func TestLivez(t *testing.T) {
+	webhook := newWebhook(&tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+	})
+
+	rec := httptest.NewRecorder()
+	webhook.livez(rec, httptest.NewRequest("GET", "/livez", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d before serving, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	webhook.serving.Store(true)
+
+	rec = httptest.NewRecorder()
+	webhook.livez(rec, httptest.NewRequest("GET", "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d while serving, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	webhook := newWebhook(&tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+	})
+
+	tests := []struct {
+		name       string
+		serving    bool
+		prefetched bool
+		expected   int
+	}{
+		{"Starting", false, false, http.StatusServiceUnavailable},
+		{"Prefetching", true, false, http.StatusServiceUnavailable},
+		{"Prefetched", false, true, http.StatusServiceUnavailable},
+		{"Ready", true, true, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			webhook.serving.Store(test.serving)
+			webhook.prefetched.Store(test.prefetched)
+
+			rec := httptest.NewRecorder()
+			webhook.readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+			if rec.Code != test.expected {
+				t.Errorf("expected status %d, got %d", test.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestServeWebhookAddressInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer listener.Close()
+
+	webhook := newWebhook(&tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+	})
+
+	meter := noop.NewMeterProvider().Meter("test")
+	err = serveWebhook(webhook, listener.Addr().String(), time.Second, time.Second, meter, "tidy_")
+	if err == nil {
+		t.Fatalf("expected error, got none")
+	}
+
+	if webhook.serving.Load() {
+		t.Errorf("expected webhook not to be serving")
+	}
+}
+
+func TestWebhookRoutes(t *testing.T) {
+	tidy := &mockTidyDNSClient{
+		createdRecords: []tidydns.Record{
+			{ID: "1", Type: "A", Name: "www", Destination: "1.2.3.4", TTL: "300", ZoneName: "example.com", ZoneID: "1"},
+		},
+	}
+
+	webhook := newWebhook(&tidyProvider{
+		tidy:         tidy,
+		zoneProvider: &mockZoneProvider{},
+	})
+
+	handler, err := webhook.handler(noop.NewMeterProvider().Meter("test"), "tidy_")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		expected int
+		response string
+	}{
+		{"Negotiate", "GET", "/", "", http.StatusOK, `"include":["example.com"]`},
+		{"Records", "GET", "/records", "", http.StatusOK, `"dnsName":"www.example.com"`},
+		{"Apply changes", "POST", "/records", `{"Delete": [{"dnsName": "www.example.com", "recordType": "A", "targets": ["1.2.3.4"]}]}`, http.StatusNoContent, ""},
+		{"Adjust endpoints", "POST", "/adjustendpoints", `[{"dnsName": "new.example.com", "recordType": "A", "targets": ["5.6.7.8"], "recordTTL": 60}]`, http.StatusOK, `"recordTTL":300`},
+		{"Unknown path", "GET", "/unknown", "", http.StatusNotFound, ""},
+		{"Wrong method", "DELETE", "/records", "", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, (server.URL + test.path), strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if resp.StatusCode != test.expected || !strings.Contains(string(body), test.response) {
+				t.Errorf("expected status %d with %s, got %d with %s", test.expected, test.response, resp.StatusCode, body)
+			}
+		})
+	}
+
+	if !slices.Equal(tidy.deletedRecordIds, []json.Number{"1"}) {
+		t.Errorf("expected the record to be deleted, got %v", tidy.deletedRecordIds)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		expected string
+		ok       bool
+	}{
+		{"No header", "", defaultVersion, true},
+		{"Other media type", "application/json", defaultVersion, true},
+		{"Version 1", "application/external.dns.webhook+json;version=1", "1", true},
+		{"Multiple media types", "application/json, application/external.dns.webhook+json;version=1", "1", true},
+		{"Unsupported version", "application/external.dns.webhook+json;version=99", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version, ok := negotiateVersion(test.accept)
+			if version != test.expected || ok != test.ok {
+				t.Errorf("expected (%s, %v), got (%s, %v)", test.expected, test.ok, version, ok)
+			}
+		})
+	}
+}
+
+func TestNegotiateUnsupportedVersion(t *testing.T) {
+	webhook := newWebhook(&tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/external.dns.webhook+json;version=99")
+	rec := httptest.NewRecorder()
+	webhook.negociate(rec, req)
+
+	if rec.Code != http.StatusNotAcceptable {
+		t.Errorf("expected status %d, got %d", http.StatusNotAcceptable, rec.Code)
+	}
+}
+
+func TestNegotiateRecordTypes(t *testing.T) {
+	webhook := newWebhook(&tidyProvider{
+		tidy:         &mockTidyDNSClient{},
+		zoneProvider: &mockZoneProvider{},
+	})
+
+	tests := []struct {
+		name     string
+		target   string
+		expected string
+	}{
+		{"Not requested", "/", `{"include":["example.com"]}`},
+		{"Requested", "/?recordtypes=true", `{"include":["example.com"],"recordTypes":["A","AAAA","CNAME","DS","MX","SRV","SSHFP","TXT"]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			webhook.negociate(rec, httptest.NewRequest("GET", test.target, nil))
+
+			if body := strings.TrimSpace(rec.Body.String()); body != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, body)
+			}
+		})
+	}
+}
+
+func TestCountRequests(t *testing.T) {
+	reader := metric.NewManualReader()
+	meter := metric.NewMeterProvider(metric.WithReader(reader)).Meter("test")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	routes := http.NewServeMux()
+	routes.Handle("POST /records", next)
+
+	handler, err := countRequests(meter, "tidy_", routes, next)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/records", nil))
+
+	data := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	instrument := data.ScopeMetrics[0].Metrics[0]
+	if instrument.Name != "tidy_webhook_requests" {
+		t.Errorf("expected metric tidy_webhook_requests, got %s", instrument.Name)
+	}
+
+	sum := instrument.Data.(metricdata.Sum[int64])
+	point := sum.DataPoints[0]
+	if point.Value != 1 {
+		t.Errorf("expected count 1, got %d", point.Value)
+	}
+
+	if code, _ := point.Attributes.Value("code"); code.AsInt64() != http.StatusNoContent {
+		t.Errorf("expected code %d, got %d", http.StatusNoContent, code.AsInt64())
+	}
+
+	if endpoint, _ := point.Attributes.Value("endpoint"); endpoint.AsString() != "/records" {
+		t.Errorf("expected endpoint /records, got %s", endpoint.AsString())
+	}
+}
+
+func TestRequestRoute(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	routes := http.NewServeMux()
+	routes.Handle("GET /{$}", ok)
+	routes.Handle("POST /records", ok)
+
+	tests := []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{"GET", "/", "/"},
+		{"POST", "/records", "/records"},
+		{"GET", "/records/1234", "other"},
+		{"DELETE", "/records", "other"},
+		{"GET", "/unknown", "other"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			if route := requestRoute(routes, httptest.NewRequest(test.method, test.path, nil)); route != test.expected {
+				t.Errorf("expected route %s, got %s", test.expected, route)
+			}
+		})
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	out := &strings.Builder{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(out, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+
+	body := `{"Create": []}`
+	req := httptest.NewRequest("POST", "/records", strings.NewReader(body))
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	logRequests(false, next).ServeHTTP(httptest.NewRecorder(), req)
+
+	line := map[string]any{}
+	if err := json.Unmarshal([]byte(out.String()), &line); err != nil {
+		t.Fatalf("expected a JSON log line, got %q", out.String())
+	}
+
+	expected := map[string]any{
+		"client":         "192.0.2.1",
+		"method":         "POST",
+		"path":           "/records",
+		"status":         float64(http.StatusCreated),
+		"request_bytes":  float64(len(body)),
+		"response_bytes": float64(len("created")),
+	}
+
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, line[key])
+		}
+	}
+
+	if _, ok := line["duration"]; !ok {
+		t.Errorf("expected the duration to be logged")
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		trustProxy bool
+		expected   string
+	}{
+		{"Remote address", nil, false, "192.0.2.1"},
+		{"Untrusted proxy", map[string]string{"X-Forwarded-For": "198.51.100.7"}, false, "192.0.2.1"},
+		{"X-Forwarded-For", map[string]string{"X-Forwarded-For": "198.51.100.7, 203.0.113.9"}, true, "198.51.100.7"},
+		{"X-Real-IP", map[string]string{"X-Real-IP": "198.51.100.7"}, true, "198.51.100.7"},
+		{"Forwarded", map[string]string{"Forwarded": "proto=http;for=198.51.100.7, for=203.0.113.9", "X-Forwarded-For": "203.0.113.9"}, true, "198.51.100.7"},
+		{"Forwarded IPv6", map[string]string{"Forwarded": `For="[2001:db8::1]:4711"`}, true, "2001:db8::1"},
+		{"No headers", nil, true, "192.0.2.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/records", nil)
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+
+			if client := clientAddress(req, test.trustProxy); client != test.expected {
+				t.Errorf("expected client %s, got %s", test.expected, client)
+			}
+		})
+	}
+}
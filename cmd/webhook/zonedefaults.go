@@ -0,0 +1,93 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+)
+
+// Status of records in Tidy by the name used by the flags
+var recordStatuses = map[string]json.Number{
+	"active":   tidydns.RecordStatusActive,
+	"disabled": tidydns.RecordStatusDisabled,
+}
+
+// Location and status records are created with in specific zones. Zones
+// without a default have records created active in location 0.
+type zoneDefaults struct {
+	location map[string]int
+	status   map[string]json.Number
+}
+
+func (d zoneDefaults) locationOf(zone string) json.Number {
+	return json.Number(strconv.Itoa(d.location[zoneKey(zone)]))
+}
+
+func (d zoneDefaults) statusOf(zone string) json.Number {
+	if status, ok := d.status[zoneKey(zone)]; ok {
+		return status
+	}
+
+	return tidydns.RecordStatusActive
+}
+
+// Parse locations of zones formatted as a comma separated list of
+// zone=location pairs, e.g. example.com=3,example.org=5
+func parseZoneLocations(value string) (map[string]int, error) {
+	pairs, err := parseZoneValues(value, "zone default location")
+	if err != nil {
+		return nil, err
+	}
+
+	zoneLocations := map[string]int{}
+	for zone, locationArg := range pairs {
+		location, err := strconv.Atoi(locationArg)
+		if err != nil || location < 0 {
+			return nil, fmt.Errorf("invalid default location for zone %s: %q", zone, locationArg)
+		}
+
+		zoneLocations[zone] = location
+	}
+
+	return zoneLocations, nil
+}
+
+// Parse statuses of zones formatted as a comma separated list of zone=status
+// pairs, where the status is active or disabled, e.g. example.com=disabled
+func parseZoneStatuses(value string) (map[string]json.Number, error) {
+	pairs, err := parseZoneValues(value, "zone default status")
+	if err != nil {
+		return nil, err
+	}
+
+	zoneStatuses := map[string]json.Number{}
+	for zone, statusArg := range pairs {
+		status, ok := recordStatuses[strings.ToLower(statusArg)]
+		if !ok {
+			return nil, fmt.Errorf("invalid default status for zone %s: %q", zone, statusArg)
+		}
+
+		zoneStatuses[zone] = status
+	}
+
+	return zoneStatuses, nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+)
+
+func TestParseZoneLocations(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    map[string]int
+		expectError bool
+	}{
+		{"Empty", "", map[string]int{}, false},
+		{"Zones", "example.com=3, Example.org.=0", map[string]int{"example.com": 3, "example.org": 0}, false},
+		{"Missing location", "example.com", nil, true},
+		{"Missing zone", "=3", nil, true},
+		{"Negative location", "example.com=-1", nil, true},
+		{"Not a number", "example.com=dc1", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			locations, err := parseZoneLocations(test.value)
+			if test.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", test.expectError, err)
+			}
+
+			if fmt.Sprint(locations) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, locations)
+			}
+		})
+	}
+}
+
+func TestParseZoneStatuses(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    map[string]json.Number
+		expectError bool
+	}{
+		{"Empty", "", map[string]json.Number{}, false},
+		{"Zones", "example.com=Disabled,example.org=active", map[string]json.Number{"example.com": tidydns.RecordStatusDisabled, "example.org": tidydns.RecordStatusActive}, false},
+		{"Number", "example.com=1", nil, true},
+		{"Unknown status", "example.com=paused", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statuses, err := parseZoneStatuses(test.value)
+			if test.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", test.expectError, err)
+			}
+
+			if fmt.Sprint(statuses) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, statuses)
+			}
+		})
+	}
+}
+
+func TestZoneDefaults(t *testing.T) {
+	defaults := zoneDefaults{
+		location: map[string]int{"example.com": 3},
+		status:   map[string]json.Number{"example.com": tidydns.RecordStatusDisabled},
+	}
+
+	if location := defaults.locationOf("Example.com"); location != "3" {
+		t.Errorf("expected location 3, got %s", location)
+	}
+
+	if status := defaults.statusOf("example.com"); status != tidydns.RecordStatusDisabled {
+		t.Errorf("expected status %s, got %s", tidydns.RecordStatusDisabled, status)
+	}
+
+	if location, status := defaults.locationOf("example.org"), defaults.statusOf("example.org"); location != "0" || status != tidydns.RecordStatusActive {
+		t.Errorf("expected location 0 and status %s, got %s and %s", tidydns.RecordStatusActive, location, status)
+	}
+}
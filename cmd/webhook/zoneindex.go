@@ -0,0 +1,56 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+)
+
+// Zones indexed by name. The zone of a name is found by looking up the name
+// and then the domains above it, so the most specific zone is found in time
+// proportional to the number of labels rather than the number of zones.
+type zoneIndex map[string]tidydns.Zone
+
+func newZoneIndex(zones []tidydns.Zone) zoneIndex {
+	index := zoneIndex{}
+	for _, zone := range zones {
+		if _, ok := index[zone.Name]; !ok {
+			index[zone.Name] = zone
+		}
+	}
+
+	return index
+}
+
+// Find the zone an FQDN belongs to, which is the zone with the longest name
+// the FQDN is or is below
+func (index zoneIndex) find(name string) (tidydns.Zone, bool) {
+	for {
+		if zone, ok := index[name]; ok {
+			return zone, true
+		}
+
+		_, parent, found := strings.Cut(name, ".")
+		if !found {
+			return tidydns.Zone{}, false
+		}
+
+		name = parent
+	}
+}
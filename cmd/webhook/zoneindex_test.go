@@ -0,0 +1,100 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
+)
+
+func TestZoneIndexFind(t *testing.T) {
+	index := newZoneIndex([]tidydns.Zone{
+		{ID: "1", Name: "example.com"},
+		{ID: "2", Name: "sub.example.com"},
+		{ID: "3", Name: "example.org"},
+		{ID: "4", Name: "example.com"},
+	})
+
+	tests := []struct {
+		name     string
+		expected json.Number
+		ok       bool
+	}{
+		{"example.com", "1", true},
+		{"www.example.com", "1", true},
+		{"sub.example.com", "2", true},
+		{"www.sub.example.com", "2", true},
+		{"www.notsub.example.com", "1", true},
+		{"notexample.com", "", false},
+		{"www.example.net", "", false},
+		{"com", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			zone, ok := index.find(test.name)
+			if zone.ID != test.expected || ok != test.ok {
+				t.Errorf("expected zone %s (%v), got %s (%v)", test.expected, test.ok, zone.ID, ok)
+			}
+		})
+	}
+}
+
+// The zones and names of a large installation
+func benchmarkZones() ([]tidydns.Zone, []string) {
+	zones := []tidydns.Zone{}
+	for i := range 500 {
+		zones = append(zones, tidydns.Zone{ID: json.Number(fmt.Sprint(i)), Name: fmt.Sprintf("zone%d.example.com", i)})
+	}
+
+	names := []string{}
+	for i := range 5000 {
+		names = append(names, fmt.Sprintf("host%d.app.zone%d.example.com", i, i%500))
+	}
+
+	return zones, names
+}
+
+// Finding zones by scanning them all, as done before the index, for comparison
+func BenchmarkFindZoneScan(b *testing.B) {
+	zones, names := benchmarkZones()
+
+	for range b.N {
+		for _, name := range names {
+			for _, zone := range zones {
+				if name == zone.Name || strings.HasSuffix(name, "."+zone.Name) {
+					break
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkFindZoneIndex(b *testing.B) {
+	zones, names := benchmarkZones()
+
+	for range b.N {
+		index := newZoneIndex(zones)
+		for _, name := range names {
+			index.find(name)
+		}
+	}
+}
@@ -17,7 +17,10 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"log/slog"
+	"math/rand/v2"
+	"slices"
 	"time"
 
 	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
@@ -27,33 +30,103 @@ type ZoneProvider interface {
 	getZones() []tidydns.Zone
 }
 
-type zoneProvider chan chan []tidydns.Zone
+// Zone providers which can list the zones in Tidy on demand rather than waiting
+// for the next update
+type zoneRefresher interface {
+	refreshZones(ctx context.Context) ([]tidydns.Zone, error)
+}
+
+type zoneProvider chan zoneRequest
+
+// A message to the zone provider asking for its zones, which are listed from
+// Tidy first when refresh is set
+type zoneRequest struct {
+	refresh   bool
+	responder chan zoneResponse
+}
+
+// The zones of the zone provider, or why refreshing them failed
+type zoneResponse struct {
+	zones []tidydns.Zone
+	err   error
+}
+
+// Called with the zones added to and removed from the zone list when an update
+// changes it, so state derived from the zones can be refreshed right away.
+type zoneObserver func(added, removed []tidydns.Zone)
+
+// Returns a channel receiving the time once the duration has passed, like
+// time.After which is used outside of tests
+type zoneClock func(d time.Duration) <-chan time.Time
 
 // For most requests a list of zones is needed, so to not make that many call to
 // Tidy and delay the request processing this zone provider acts as a cache for
 // the zone list. It's operated upon with messageing and initilly block any
 // calls until the list of zones has been populated. After initialization the
-// zone list is re-fetched every 10 minutes.
-func newZoneProvider(tidy tidydns.TidyDNSClient, updateInterval time.Duration) ZoneProvider {
+// zone list is re-fetched every 10 minutes. Each interval is randomly made up
+// to the jitter fraction longer or shorter, so replicas started together don't
+// all call Tidy at once. When zoneGroup is set only zones in that Tidy group
+// are kept. The observer, if any, is told when an update changes the zones.
+func newZoneProvider(tidy zoneLister, updateInterval time.Duration, jitter float64, zoneGroup string, observer zoneObserver) ZoneProvider {
+	return newZoneProviderWithClock(tidy, updateInterval, jitter, zoneGroup, observer, time.After)
+}
+
+// Make a zone provider waiting for the next update with the given clock, so
+// tests can decide when updates happen
+func newZoneProviderWithClock(tidy zoneLister, updateInterval time.Duration, jitter float64, zoneGroup string, observer zoneObserver, after zoneClock) ZoneProvider {
 	provider := make(zoneProvider, 1)
 
+	listZones := func() ([]tidydns.Zone, error) {
+		zones, err := tidy.ListZones(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		return selectZones(zones, zoneGroup), nil
+	}
+
 	// Get all tidy zones
-	zones, err := tidy.ListZones()
+	zones, err := listZones()
 	if err != nil {
 		panic(err.Error())
 	}
 
-	ticker := time.NewTicker(updateInterval)
+	next := after(jitteredInterval(updateInterval, jitter, rand.Float64))
+
+	update := func() error {
+		updated, err := listZones()
+		if err != nil {
+			return err
+		}
+
+		added, removed := diffZones(zones, updated)
+		zones = updated
+
+		if observer != nil && (len(added) > 0 || len(removed) > 0) {
+			observer(added, removed)
+		}
+
+		return nil
+	}
 
 	go func() {
 		for {
 			select {
-			case respChan := <-provider:
-				respChan <- zones
-			case <-ticker.C:
-				if zones, err = tidy.ListZones(); err != nil {
+			case req := <-provider:
+				// A failed refresh keeps the zones, like a failed update
+				if req.refresh {
+					if err := update(); err != nil {
+						req.responder <- zoneResponse{err: err}
+						continue
+					}
+				}
+
+				req.responder <- zoneResponse{zones: zones}
+			case <-next:
+				next = after(jitteredInterval(updateInterval, jitter, rand.Float64))
+
+				if err := update(); err != nil {
 					slog.Error("error updating zones", "error", err)
-					continue
 				}
 			}
 		}
@@ -63,7 +136,118 @@ func newZoneProvider(tidy tidydns.TidyDNSClient, updateInterval time.Duration) Z
 }
 
 func (provider zoneProvider) getZones() []tidydns.Zone {
-	responder := make(chan []tidydns.Zone, 1)
-	provider <- responder
-	return <-responder
+	responder := make(chan zoneResponse, 1)
+	provider <- zoneRequest{responder: responder}
+	return (<-responder).zones
+}
+
+// List the zones from Tidy now rather than at the next update, returning the
+// new zones. Requests for the zones wait for the refresh to finish. The regular
+// updates continue at their interval.
+func (provider zoneProvider) refreshZones(ctx context.Context) ([]tidydns.Zone, error) {
+	responder := make(chan zoneResponse, 1)
+
+	select {
+	case provider <- zoneRequest{refresh: true, responder: responder}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case resp := <-responder:
+		return resp.zones, resp.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// The zones only in updated and the zones only in current. A zone renamed in
+// Tidy is both removed and added.
+func diffZones(current, updated []tidydns.Zone) (added, removed []tidydns.Zone) {
+	key := func(zone tidydns.Zone) string { return zone.ID.String() + "/" + zone.Name }
+
+	missing := func(zones []tidydns.Zone, zone tidydns.Zone) bool {
+		return !slices.ContainsFunc(zones, func(z tidydns.Zone) bool { return key(z) == key(zone) })
+	}
+
+	for _, zone := range updated {
+		if missing(current, zone) {
+			added = append(added, zone)
+		}
+	}
+
+	for _, zone := range current {
+		if missing(updated, zone) {
+			removed = append(removed, zone)
+		}
+	}
+
+	return added, removed
+}
+
+// Randomly lengthen or shorten an interval by up to the jitter fraction of it,
+// using random to get a number in [0, 1)
+func jitteredInterval(interval time.Duration, jitter float64, random func() float64) time.Duration {
+	offset := jitter * (2*random() - 1)
+	return time.Duration(float64(interval) * (1 + offset))
+}
+
+// The zones listed from Tidy which the webhook manages: those in the zone
+// group, if any, each name kept once
+func selectZones(zones []tidydns.Zone, group string) []tidydns.Zone {
+	return dedupeZones(filterZoneGroup(zones, group))
+}
+
+// Leave out the reverse zones, as PTR records aren't supported
+func forwardZones(zones []tidydns.Zone) []tidydns.Zone {
+	forward := []tidydns.Zone{}
+	for _, zone := range zones {
+		if !zone.IsReverse() {
+			forward = append(forward, zone)
+		}
+	}
+
+	return forward
+}
+
+// Keep only the zones belonging to the given group. An empty group keeps all
+// zones.
+func filterZoneGroup(zones []tidydns.Zone, group string) []tidydns.Zone {
+	if group == "" {
+		return zones
+	}
+
+	filtered := []tidydns.Zone{}
+	for _, zone := range zones {
+		if zone.Group == group {
+			filtered = append(filtered, zone)
+		}
+	}
+
+	return filtered
+}
+
+// Keep a single zone of each name. Tidy can return several zones with the same
+// name, e.g. from different views, and which one would otherwise be used
+// depends on the order Tidy lists them in. The zone with the lowest ID, the
+// one created first, is kept so the choice is the same on every update.
+func dedupeZones(zones []tidydns.Zone) []tidydns.Zone {
+	kept := map[string]int{}
+	deduped := []tidydns.Zone{}
+
+	for _, zone := range zones {
+		i, ok := kept[zone.Name]
+		if !ok {
+			kept[zone.Name] = len(deduped)
+			deduped = append(deduped, zone)
+			continue
+		}
+
+		slog.Warn("duplicate zone name in Tidy", "zone", zone.Name, "ids", []string{deduped[i].ID.String(), zone.ID.String()})
+		if compareIDs(zone.ID, deduped[i].ID) < 0 {
+			deduped[i] = zone
+		}
+	}
+
+	return deduped
 }
@@ -15,13 +15,38 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"errors"
+	"math/rand/v2"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/neticdk/external-dns-tidydns-webhook/cmd/webhook/tidydns"
 )
 
+// Clock of a zone provider, which only ticks when told to and remembers the
+// durations the provider waits for
+type manualClock struct {
+	ticks chan time.Time
+	waits chan time.Duration
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{ticks: make(chan time.Time), waits: make(chan time.Duration, 16)}
+}
+
+func (c *manualClock) after(d time.Duration) <-chan time.Time {
+	c.waits <- d
+	return c.ticks
+}
+
+// Make the provider update its zones. The provider is done updating when it
+// answers the next request.
+func (c *manualClock) tick() {
+	c.ticks <- time.Now()
+}
+
 func TestNewZoneProvider(t *testing.T) {
 	mockZones := []tidydns.Zone{
 		{Name: "zone1"},
@@ -29,7 +54,7 @@ func TestNewZoneProvider(t *testing.T) {
 	}
 
 	mockClient := &mockTidyDNSClient{zones: mockZones}
-	provider := newZoneProvider(mockClient, (10 * time.Minute))
+	provider := newZoneProvider(mockClient, (10 * time.Minute), 0, "", nil)
 
 	zones := provider.getZones()
 	if len(zones) != len(mockZones) {
@@ -49,7 +74,8 @@ func TestZoneProviderUpdateWithError(t *testing.T) {
 	}
 
 	mockClient := &mockTidyDNSClient{zones: initialZones}
-	provider := newZoneProvider(mockClient, (1 * time.Second))
+	clock := newManualClock()
+	provider := newZoneProviderWithClock(mockClient, (10 * time.Minute), 0, "", nil, clock.after)
 
 	// Initial zones check
 	zones := provider.getZones()
@@ -66,8 +92,7 @@ func TestZoneProviderUpdateWithError(t *testing.T) {
 	// Introduce an error in the mock client
 	mockClient.err = errors.New("mock update error")
 
-	// Wait for the update interval to pass
-	time.Sleep(2 * time.Second)
+	clock.tick()
 
 	// Check zones after error
 	zones = provider.getZones()
@@ -93,7 +118,8 @@ func TestZoneProviderUpdateWithNewZones(t *testing.T) {
 	}
 
 	mockClient := &mockTidyDNSClient{zones: initialZones}
-	provider := newZoneProvider(mockClient, (1 * time.Second))
+	clock := newManualClock()
+	provider := newZoneProviderWithClock(mockClient, (10 * time.Minute), 0, "", nil, clock.after)
 
 	// Initial zones check
 	zones := provider.getZones()
@@ -110,8 +136,7 @@ func TestZoneProviderUpdateWithNewZones(t *testing.T) {
 	// Update the zones in the mock client
 	mockClient.zones = updatedZones
 
-	// Wait for the update interval to pass
-	time.Sleep(2 * time.Second)
+	clock.tick()
 
 	// Check zones after update
 	zones = provider.getZones()
@@ -135,16 +160,224 @@ func TestZoneProviderErrorHandling(t *testing.T) {
 		}
 	}()
 
-	newZoneProvider(mockClient, (10 * time.Minute))
+	newZoneProvider(mockClient, (10 * time.Minute), 0, "", nil)
 }
 
 func TestZoneProviderNoZones(t *testing.T) {
 	mockClient := &mockTidyDNSClient{zones: []tidydns.Zone{}}
 
-	provider := newZoneProvider(mockClient, (10 * time.Minute))
+	provider := newZoneProvider(mockClient, (10 * time.Minute), 0, "", nil)
 
 	zones := provider.getZones()
 	if len(zones) != 0 {
 		t.Fatalf("Expected 0 zones, got %d", len(zones))
 	}
 }
+
+func TestZoneProviderZoneGroup(t *testing.T) {
+	mockZones := []tidydns.Zone{
+		{Name: "zone1", Group: "group1"},
+		{Name: "zone2", Group: "group2"},
+		{Name: "zone3", Group: "group1"},
+	}
+
+	mockClient := &mockTidyDNSClient{zones: mockZones}
+	provider := newZoneProvider(mockClient, (10 * time.Minute), 0, "group1", nil)
+
+	zones := provider.getZones()
+	if len(zones) != 2 {
+		t.Fatalf("Expected 2 zones, got %d", len(zones))
+	}
+
+	for _, zone := range zones {
+		if zone.Group != "group1" {
+			t.Errorf("Expected only zones in group1, got %s in %s", zone.Name, zone.Group)
+		}
+	}
+}
+
+func TestZoneProviderDuplicateNames(t *testing.T) {
+	mockZones := []tidydns.Zone{
+		{ID: "12", Name: "zone1", Group: "views"},
+		{ID: "2", Name: "zone2"},
+		{ID: "3", Name: "zone1"},
+	}
+
+	mockClient := &mockTidyDNSClient{zones: mockZones}
+	provider := newZoneProvider(mockClient, (10 * time.Minute), 0, "", nil)
+
+	expected := []tidydns.Zone{{ID: "3", Name: "zone1"}, {ID: "2", Name: "zone2"}}
+	if zones := provider.getZones(); !slices.Equal(zones, expected) {
+		t.Errorf("Expected %v, got %v", expected, zones)
+	}
+}
+
+func TestDedupeZones(t *testing.T) {
+	zone1 := tidydns.Zone{ID: "1", Name: "zone1"}
+	other1 := tidydns.Zone{ID: "10", Name: "zone1"}
+	zone2 := tidydns.Zone{ID: "2", Name: "zone2"}
+	invalid := tidydns.Zone{ID: "x", Name: "zone1"}
+
+	tests := []struct {
+		name     string
+		zones    []tidydns.Zone
+		expected []tidydns.Zone
+	}{
+		{"No duplicates", []tidydns.Zone{zone1, zone2}, []tidydns.Zone{zone1, zone2}},
+		{"Lowest ID first", []tidydns.Zone{zone1, zone2, other1}, []tidydns.Zone{zone1, zone2}},
+		{"Lowest ID last", []tidydns.Zone{other1, zone2, zone1}, []tidydns.Zone{zone1, zone2}},
+		{"Numeric ID before other", []tidydns.Zone{invalid, other1}, []tidydns.Zone{other1}},
+		{"Empty", []tidydns.Zone{}, []tidydns.Zone{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if deduped := dedupeZones(test.zones); !slices.Equal(deduped, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, deduped)
+			}
+		})
+	}
+}
+
+func TestZoneProviderObserver(t *testing.T) {
+	mockClient := &mockTidyDNSClient{zones: []tidydns.Zone{{ID: "1", Name: "zone1"}}}
+
+	changes := make(chan []tidydns.Zone, 1)
+	observer := func(added, removed []tidydns.Zone) {
+		changes <- added
+	}
+
+	clock := newManualClock()
+	provider := newZoneProviderWithClock(mockClient, (10 * time.Minute), 0, "", observer, clock.after)
+	provider.getZones()
+
+	// Nothing changes
+	clock.tick()
+	provider.getZones()
+
+	mockClient.zones = []tidydns.Zone{{ID: "1", Name: "zone1"}, {ID: "2", Name: "zone2"}}
+	clock.tick()
+	provider.getZones()
+
+	if added := <-changes; len(added) != 1 || added[0].Name != "zone2" {
+		t.Errorf("Expected zone2 to be added, got %v", added)
+	}
+
+	if len(changes) != 0 {
+		t.Errorf("Expected the observer to be told only about the added zone")
+	}
+}
+
+func TestZoneProviderRefresh(t *testing.T) {
+	mockClient := &mockTidyDNSClient{zones: []tidydns.Zone{{ID: "1", Name: "zone1"}}}
+
+	changes := make(chan []tidydns.Zone, 1)
+	observer := func(added, removed []tidydns.Zone) {
+		changes <- added
+	}
+
+	clock := newManualClock()
+	provider := newZoneProviderWithClock(mockClient, (10 * time.Minute), 0, "", observer, clock.after).(zoneRefresher)
+
+	// The zone is listed without the clock ticking
+	mockClient.zones = []tidydns.Zone{{ID: "1", Name: "zone1"}, {ID: "2", Name: "zone2"}}
+	zones, err := provider.refreshZones(context.Background())
+	if err != nil || len(zones) != 2 {
+		t.Fatalf("Expected 2 zones, got %v and %v", zones, err)
+	}
+
+	if added := <-changes; len(added) != 1 || added[0].Name != "zone2" {
+		t.Errorf("Expected zone2 to be added, got %v", added)
+	}
+
+	// A failed refresh keeps the zones
+	mockClient.err = errors.New("unavailable")
+	if _, err := provider.refreshZones(context.Background()); err == nil {
+		t.Errorf("Expected an error")
+	}
+
+	if zones := provider.(ZoneProvider).getZones(); len(zones) != 2 {
+		t.Errorf("Expected the zones to be kept, got %v", zones)
+	}
+}
+
+func TestZoneProviderClock(t *testing.T) {
+	mockClient := &mockTidyDNSClient{zones: []tidydns.Zone{{ID: "1", Name: "zone1"}}}
+	clock := newManualClock()
+	provider := newZoneProviderWithClock(mockClient, (10 * time.Minute), 0.5, "", nil, clock.after)
+
+	for range 3 {
+		clock.tick()
+	}
+	provider.getZones()
+
+	// The first wait and one after each update
+	if len(clock.waits) != 4 {
+		t.Fatalf("Expected 4 waits, got %d", len(clock.waits))
+	}
+
+	for range 4 {
+		if wait := <-clock.waits; wait < (5*time.Minute) || wait >= (15*time.Minute) {
+			t.Errorf("Expected a wait of 10m with 50%% jitter, got %s", wait)
+		}
+	}
+}
+
+func TestDiffZones(t *testing.T) {
+	zone1 := tidydns.Zone{ID: "1", Name: "zone1"}
+	zone2 := tidydns.Zone{ID: "2", Name: "zone2"}
+	renamed := tidydns.Zone{ID: "2", Name: "zone3"}
+
+	tests := []struct {
+		name            string
+		current         []tidydns.Zone
+		updated         []tidydns.Zone
+		expectedAdded   []tidydns.Zone
+		expectedRemoved []tidydns.Zone
+	}{
+		{"Unchanged", []tidydns.Zone{zone1, zone2}, []tidydns.Zone{zone2, zone1}, nil, nil},
+		{"Added", []tidydns.Zone{zone1}, []tidydns.Zone{zone1, zone2}, []tidydns.Zone{zone2}, nil},
+		{"Removed", []tidydns.Zone{zone1, zone2}, []tidydns.Zone{zone1}, nil, []tidydns.Zone{zone2}},
+		{"Renamed", []tidydns.Zone{zone1, zone2}, []tidydns.Zone{zone1, renamed}, []tidydns.Zone{renamed}, []tidydns.Zone{zone2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			added, removed := diffZones(test.current, test.updated)
+			if !slices.Equal(added, test.expectedAdded) || !slices.Equal(removed, test.expectedRemoved) {
+				t.Errorf("Expected added %v and removed %v, got %v and %v", test.expectedAdded, test.expectedRemoved, added, removed)
+			}
+		})
+	}
+}
+
+func TestJitteredInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		jitter   float64
+		random   float64
+		expected time.Duration
+	}{
+		{"No jitter", 0, 0.9, 10 * time.Minute},
+		{"Shortest", 0.1, 0, 9 * time.Minute},
+		{"Middle", 0.1, 0.5, 10 * time.Minute},
+		{"Longest", 0.1, 1, 11 * time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			random := func() float64 { return test.random }
+			if actual := jitteredInterval(10*time.Minute, test.jitter, random); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+
+	// Real random intervals stay within the jittered window
+	for range 100 {
+		interval := jitteredInterval(10*time.Minute, 0.1, rand.Float64)
+		if interval < 9*time.Minute || interval > 11*time.Minute {
+			t.Errorf("expected interval within 9m and 11m, got %v", interval)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The name of a zone as a key of the settings of zones, so settings are found
+// regardless of case and a trailing dot
+func zoneKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Split a comma separated list of key=value pairs, e.g. dynamic=1,view_id=2.
+// Values may be empty. Errors name the pairs as what.
+func parsePairs(value, what string) (map[string]string, error) {
+	pairs := map[string]string{}
+	if value == "" {
+		return pairs, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		key, pairValue, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid %s %q", what, pair)
+		}
+
+		pairs[key] = pairValue
+	}
+
+	return pairs, nil
+}
+
+// Split a comma separated list of zone=value pairs, e.g. example.com=60, with
+// the zones as returned by zoneKey
+func parseZoneValues(value, what string) (map[string]string, error) {
+	pairs, err := parsePairs(value, what)
+	if err != nil {
+		return nil, err
+	}
+
+	zoneValues := map[string]string{}
+	for zone, zoneValue := range pairs {
+		if zoneKey(zone) == "" {
+			return nil, fmt.Errorf("invalid %s %q", what, zone+"="+zoneValue)
+		}
+
+		zoneValues[zoneKey(zone)] = zoneValue
+	}
+
+	return zoneValues, nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2024 Netic A/S.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParsePairs(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		expected  map[string]string
+		expectErr bool
+	}{
+		{"Empty", "", map[string]string{}, false},
+		{"Pairs", "dynamic=1, view_id=2", map[string]string{"dynamic": "1", "view_id": "2"}, false},
+		{"Empty value", "view_id=", map[string]string{"view_id": ""}, false},
+		{"Keys kept", "View_ID=2", map[string]string{"View_ID": "2"}, false},
+		{"Missing value", "dynamic", nil, true},
+		{"Missing key", "=1", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parsePairs(test.value, "form field")
+			if test.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+
+			if !maps.Equal(result, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseZoneValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		expected  map[string]string
+		expectErr bool
+	}{
+		{"Empty", "", map[string]string{}, false},
+		{"Zones", "example.com=1,example.org=2", map[string]string{"example.com": "1", "example.org": "2"}, false},
+		{"Zones normalised", "Example.COM.=1", map[string]string{"example.com": "1"}, false},
+		{"Missing value", "example.com", nil, true},
+		{"Only a dot", ".=1", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parseZoneValues(test.value, "zone setting")
+			if test.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+
+			if !maps.Equal(result, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}